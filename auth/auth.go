@@ -34,6 +34,7 @@ func (a *Auth) Init(ab *authboss.Authboss) (err error) {
 
 	a.Authboss.Config.Core.Router.Get("/login", a.Authboss.Core.ErrorHandler.Wrap(a.LoginGet))
 	a.Authboss.Config.Core.Router.Post("/login", a.Authboss.Core.ErrorHandler.Wrap(a.LoginPost))
+	a.Authboss.LimitRoute("/login", authboss.RateLimitedRoute{Page: PageLogin})
 
 	return nil
 }
@@ -41,10 +42,16 @@ func (a *Auth) Init(ab *authboss.Authboss) (err error) {
 // LoginGet simply displays the login form
 func (a *Auth) LoginGet(w http.ResponseWriter, r *http.Request) error {
 	data := authboss.HTMLData{}
-	if redir := r.URL.Query().Get(authboss.FormValueRedirect); len(redir) != 0 {
+	if redir := r.URL.Query().Get(authboss.FormValueRedirect); a.Authboss.Config.Paths.RedirectPolicy.Allowed(redir) {
 		data[authboss.FormValueRedirect] = redir
 	}
-	return a.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data)
+
+	challenge, err := a.Authboss.ChallengeData(r, "")
+	if err != nil {
+		return err
+	}
+
+	return a.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data.Merge(challenge))
 }
 
 // LoginPost attempts to validate the credentials passed in
@@ -62,11 +69,22 @@ func (a *Auth) LoginPost(w http.ResponseWriter, r *http.Request) error {
 	creds := authboss.MustHaveUserValues(validatable)
 
 	pid := creds.GetPID()
+
+	challenge, ok, err := a.Authboss.CheckChallenge(r, pid, validatable)
+	if err != nil {
+		return err
+	} else if !ok {
+		logger.Infof("user %s failed to complete the login challenge", pid)
+		return a.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, challenge)
+	}
+
 	pidUser, err := a.Authboss.Storage.Server.Load(r.Context(), pid)
 	if err == authboss.ErrUserNotFound {
 		logger.Infof("failed to load user requested by pid: %s", pid)
-		data := authboss.HTMLData{authboss.DataErr: "Invalid Credentials"}
-		return a.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data)
+		if err = a.Authboss.ChallengeFailed(r, pid); err != nil {
+			return err
+		}
+		return a.invalidCredentials(w, r, pid)
 	} else if err != nil {
 		return err
 	}
@@ -79,6 +97,10 @@ func (a *Auth) LoginPost(w http.ResponseWriter, r *http.Request) error {
 	var handled bool
 	err = bcrypt.CompareHashAndPassword([]byte(password), []byte(creds.GetPassword()))
 	if err != nil {
+		if err = a.Authboss.ChallengeFailed(r, pid); err != nil {
+			return err
+		}
+
 		handled, err = a.Authboss.Events.FireAfter(authboss.EventAuthFail, w, r)
 		if err != nil {
 			return err
@@ -87,8 +109,11 @@ func (a *Auth) LoginPost(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		logger.Infof("user %s failed to log in", pid)
-		data := authboss.HTMLData{authboss.DataErr: "Invalid Credentials"}
-		return a.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data)
+		return a.invalidCredentials(w, r, pid)
+	}
+
+	if err = a.Authboss.ChallengeSucceeded(r.Context(), pid); err != nil {
+		return err
 	}
 
 	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, validatable))
@@ -109,6 +134,7 @@ func (a *Auth) LoginPost(w http.ResponseWriter, r *http.Request) error {
 
 	logger.Infof("user %s logged in", pid)
 	authboss.PutSession(w, authboss.SessionKey, pid)
+	authboss.PutSessionSecurityStamp(w, pidUser)
 	authboss.DelSession(w, authboss.SessionHalfAuthKey)
 
 	handled, err = a.Authboss.Events.FireAfter(authboss.EventAuth, w, r)
@@ -125,3 +151,15 @@ func (a *Auth) LoginPost(w http.ResponseWriter, r *http.Request) error {
 	}
 	return a.Authboss.Core.Redirector.Redirect(w, r, ro)
 }
+
+// invalidCredentials renders the login page with an error, and a challenge
+// if one is now required for pid.
+func (a *Auth) invalidCredentials(w http.ResponseWriter, r *http.Request, pid string) error {
+	challenge, err := a.Authboss.ChallengeData(r, pid)
+	if err != nil {
+		return err
+	}
+
+	data := authboss.HTMLData{authboss.DataErr: "Invalid Credentials"}
+	return a.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data.Merge(challenge))
+}
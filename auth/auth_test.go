@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/challenge"
 	"github.com/p000ic/authboss-echo/mocks"
 )
 
@@ -36,6 +37,9 @@ func TestAuthInit(t *testing.T) {
 	if err := router.HasPosts("/login"); err != nil {
 		t.Error(err)
 	}
+	if limited, ok := ab.LimitedRoute("/login"); !ok || limited.Page != PageLogin {
+		t.Error("login should be rate limited by the pid in the body:", limited)
+	}
 }
 
 func TestAuthGet(t *testing.T) {
@@ -66,6 +70,26 @@ func TestAuthGet(t *testing.T) {
 	}
 }
 
+func TestAuthGetUnsafeRedirect(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	responder := &mocks.Responder{}
+	ab.Config.Core.Responder = responder
+
+	a := &Auth{ab}
+
+	r := mocks.Request("GET")
+	r.URL.RawQuery = "redir=%2F%2Fevil.com"
+	if err := a.LoginGet(nil, r); err != nil {
+		t.Error(err)
+	}
+
+	if got, ok := responder.Data[authboss.FormValueRedirect]; ok {
+		t.Error("an unsafe redirect should not be put in the page:", got)
+	}
+}
+
 type testHarness struct {
 	auth *Auth
 	ab   *authboss.Authboss
@@ -367,3 +391,68 @@ func TestAuthPostUserNotFound(t *testing.T) {
 		t.Error("after should not have been called")
 	}
 }
+
+func TestAuthPostChallenge(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	verifier := &mocks.ChallengeVerifier{Challenge: "challenge", Response: "solved"}
+	h.ab.Config.Core.ChallengeVerifier = verifier
+	h.ab.Config.Storage.ChallengeCounter = challenge.NewMemoryCounter()
+	h.ab.Config.Modules.ChallengeAfter = 2
+
+	h.storer.Users["test@test.com"] = &mocks.User{
+		Email:    "test@test.com",
+		Password: "$2a$10$IlfnqVyDZ6c1L.kaA/q3bu1nkAC6KukNUsizvlzay1pZPXnX2C9Ji", // hello world
+	}
+
+	login := func(password, response string) {
+		h.responder.Data = nil
+		h.bodyReader.Return = mocks.Values{PID: "test@test.com", Password: password, Challenge: response}
+		if err := h.auth.LoginPost(h.ab.NewResponse(httptest.NewRecorder()), mocks.Request("POST")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	login("wrong", "")
+	if _, ok := h.responder.Data[authboss.DataChallengeRequired]; ok {
+		t.Error("challenge should not be required after one failure")
+	}
+
+	login("wrong", "")
+	if h.responder.Data[authboss.DataChallengeRequired] != true || h.responder.Data[authboss.DataChallenge] != "challenge" {
+		t.Error("challenge should be required after two failures:", h.responder.Data)
+	}
+
+	login("hello world", "")
+	if h.responder.Data[authboss.DataErr] != "Please complete the challenge" {
+		t.Error("login without the challenge should fail:", h.responder.Data)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("user should not be logged in")
+	}
+
+	login("hello world", "solved")
+	if h.session.ClientValues[authboss.SessionKey] != "test@test.com" {
+		t.Error("user should be logged in after solving the challenge")
+	}
+	if len(verifier.Verified) != 1 || verifier.Verified[0] != "solved" {
+		t.Error("verifier should only have been given the response once:", verifier.Verified)
+	}
+}
+
+func TestAuthGetChallenge(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Core.ChallengeVerifier = &mocks.ChallengeVerifier{Challenge: "challenge"}
+	h.ab.Config.Modules.ChallengeAfter = 0
+
+	if err := h.auth.LoginGet(nil, mocks.Request("GET")); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Data[authboss.DataChallengeRequired] != true || h.responder.Data[authboss.DataChallenge] != "challenge" {
+		t.Error("login page should have a challenge:", h.responder.Data)
+	}
+}
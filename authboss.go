@@ -22,7 +22,8 @@ type Authboss struct {
 	Config
 	Events *Events
 
-	loadedModules map[string]Moduler
+	loadedModules     map[string]Moduler
+	rateLimitedRoutes map[string]RateLimitedRoute
 }
 
 // New makes a new instance of authboss with a default
@@ -31,6 +32,7 @@ func New() *Authboss {
 	ab := &Authboss{}
 
 	ab.loadedModules = make(map[string]Moduler)
+	ab.rateLimitedRoutes = make(map[string]RateLimitedRoute)
 	ab.Events = NewEvents()
 
 	ab.Config.Defaults()
@@ -43,27 +45,40 @@ func (a *Authboss) Init(modulesToLoad ...string) error {
 		modulesToLoad = RegisteredModules()
 	}
 
+	if a.Config.Core.ChallengeVerifier != nil && a.Config.Modules.ChallengeAfter > 0 && a.Config.Storage.ChallengeCounter == nil {
+		return errors.New("a ChallengeCounter must be set to use a ChallengeVerifier with ChallengeAfter")
+	}
+
 	for _, name := range modulesToLoad {
 		if err := a.loadModule(name); err != nil {
 			return errors.Errorf("module %s failed to load: %+v", name, err)
 		}
 	}
 
+	a.Events.StartAsync(a.Config.Modules.EventAsyncWorkers, a.Config.Modules.EventAsyncQueueSize, a.Config.Core.Logger)
+
 	return nil
 }
 
+// Shutdown waits for the async event handlers that are still queued to
+// finish, or for ctx to be done. When Modules.EventAsyncWorkers is set it
+// must be called when the web server is shutting down, otherwise the
+// worker goroutines are never stopped.
+func (a *Authboss) Shutdown(ctx context.Context) error {
+	return a.Events.Shutdown(ctx)
+}
+
 // UpdatePassword updates the password field of a user using the same semantics
 // that register/auth do to create and verify passwords. It saves this using
 // the storer.
 //
 // In addition to that, it also invalidates any remember me tokens, if the
-// storer supports that kind of operation.
+// storer supports that kind of operation, and fires EventPasswordChange with
+// the user in the context under CTXKeyUser.
 //
-// Note that it's best practice after having called this method to also delete
-// all the user's logged in sessions. The CURRENT logged in session can be
-// deleted with `authboss.DelKnown(Session|Cookie)` but to delete ALL logged
-// in sessions for a user requires special mechanisms not currently provided
-// by authboss.
+// If the user is a SecurityStampUser its security stamp is bumped, so that
+// SecurityStampMiddleware logs out all of the user's sessions. To keep the
+// CURRENT session logged in call PutSessionSecurityStamp afterwards.
 func (a *Authboss) UpdatePassword(ctx context.Context, user AuthableUser, newPassword string) error {
 	pass, err := bcrypt.GenerateFromPassword([]byte(newPassword), a.Config.Modules.BCryptCost)
 	if err != nil {
@@ -71,18 +86,22 @@ func (a *Authboss) UpdatePassword(ctx context.Context, user AuthableUser, newPas
 	}
 
 	user.PutPassword(string(pass))
+	if err := BumpSecurityStamp(user); err != nil {
+		return err
+	}
 
 	storer := a.Config.Storage.Server
 	if err := storer.Save(ctx, user); err != nil {
 		return err
 	}
 
-	rmStorer, ok := storer.(RememberingServerStorer)
-	if !ok {
-		return nil
+	if rmStorer, ok := storer.(RememberingServerStorer); ok {
+		if err := rmStorer.DelRememberTokens(ctx, user.GetPID()); err != nil {
+			return err
+		}
 	}
 
-	return rmStorer.DelRememberTokens(ctx, user.GetPID())
+	return a.Events.FireAfterContext(context.WithValue(ctx, CTXKeyUser, user), EventPasswordChange)
 }
 
 // VerifyPassword uses authboss mechanisms to check that a password is correct.
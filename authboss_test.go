@@ -15,17 +15,26 @@ func TestAuthBossInit(t *testing.T) {
 	if err != nil {
 		t.Error("Unexpected error:", err)
 	}
+	if ab.Events.pool != nil {
+		t.Error("the async worker pool should only be started when it's configured")
+	}
 }
 
 func TestAuthbossUpdatePassword(t *testing.T) {
 	t.Parallel()
 
-	user := &mockUser{}
+	user := &mockUser{SecurityStamp: "old"}
 	storer := newMockServerStorer()
 
 	ab := New()
 	ab.Config.Storage.Server = storer
 
+	var changed User
+	ab.Events.After(EventPasswordChange, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		changed = r.Context().Value(CTXKeyUser).(User)
+		return false, nil
+	})
+
 	if err := ab.UpdatePassword(context.Background(), user, "hello world"); err != nil {
 		t.Error(err)
 	}
@@ -33,6 +42,12 @@ func TestAuthbossUpdatePassword(t *testing.T) {
 	if len(user.Password) == 0 {
 		t.Error("password was not updated")
 	}
+	if changed != user {
+		t.Error("password change event should have fired with the user")
+	}
+	if len(user.SecurityStamp) == 0 || user.SecurityStamp == "old" {
+		t.Error("security stamp should have been bumped")
+	}
 }
 
 type testRedirector struct {
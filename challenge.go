@@ -0,0 +1,155 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Keys for challenge data in HTMLData
+const (
+	// DataChallengeRequired is true when the page must render a challenge
+	// and submit its response under the challenge response form value.
+	DataChallengeRequired = "challenge_required"
+	// DataChallenge is the string returned by ChallengeVerifier.NewChallenge
+	// that the page needs to render the challenge.
+	DataChallenge = "challenge"
+)
+
+// ChallengeVerifier issues and verifies challenges (a CAPTCHA or proof of
+// work for example) that clients must complete once they've failed to log in
+// too many times. See the challenge package for implementations.
+type ChallengeVerifier interface {
+	// NewChallenge returns what a page needs to render a challenge, it may
+	// be empty if the challenge is entirely rendered client side.
+	NewChallenge(ctx context.Context) (challenge string, err error)
+	// Verify the response to a challenge submitted by the client at
+	// remoteIP. Invalid responses are not an error.
+	Verify(ctx context.Context, response, remoteIP string) (ok bool, err error)
+}
+
+// ChallengeCounter keeps count of recent failures for a key (an ip address
+// or pid) in order to decide when a challenge is required.
+type ChallengeCounter interface {
+	// Fail records a failure for key. Failures are forgotten once window
+	// has passed without another failure.
+	Fail(ctx context.Context, key string, window time.Duration) error
+	// Failures returns the number of failures recorded for key.
+	Failures(ctx context.Context, key string) (int, error)
+	// Reset forgets the failures for key.
+	Reset(ctx context.Context, key string) error
+}
+
+// ChallengeValuer provides the response to a challenge submitted with a form
+type ChallengeValuer interface {
+	GetChallengeResponse() string
+}
+
+// ChallengeRequired reports whether the client must complete a challenge
+// because its ip address, or the pid if it's not empty, has failed to log
+// in Modules.ChallengeAfter times. It's always false if there is no
+// Core.ChallengeVerifier.
+func (a *Authboss) ChallengeRequired(r *http.Request, pid string) (bool, error) {
+	if a.Config.Core.ChallengeVerifier == nil {
+		return false, nil
+	}
+
+	after := a.Config.Modules.ChallengeAfter
+	if after <= 0 {
+		return true, nil
+	}
+
+	keys := []string{"ip:" + a.ClientIP(r)}
+	if len(pid) != 0 {
+		keys = append(keys, "pid:"+pid)
+	}
+
+	for _, key := range keys {
+		failures, err := a.Config.Storage.ChallengeCounter.Failures(r.Context(), key)
+		if err != nil {
+			return false, err
+		}
+		if failures >= after {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ChallengeData returns the HTMLData a page needs to render a new challenge
+// if one is required, otherwise it returns nil.
+func (a *Authboss) ChallengeData(r *http.Request, pid string) (HTMLData, error) {
+	required, err := a.ChallengeRequired(r, pid)
+	if err != nil || !required {
+		return nil, err
+	}
+
+	challenge, err := a.Config.Core.ChallengeVerifier.NewChallenge(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	return HTMLData{DataChallengeRequired: true, DataChallenge: challenge}, nil
+}
+
+// CheckChallenge verifies the challenge response in values if a challenge is
+// required. When it's missing or wrong ok is false and data holds an error
+// and a new challenge for the page to render.
+func (a *Authboss) CheckChallenge(r *http.Request, pid string, values Validator) (data HTMLData, ok bool, err error) {
+	required, err := a.ChallengeRequired(r, pid)
+	if err != nil || !required {
+		return nil, err == nil, err
+	}
+
+	var response string
+	if cv, isChallenger := values.(ChallengeValuer); isChallenger {
+		response = cv.GetChallengeResponse()
+	}
+
+	if len(response) != 0 {
+		ok, err = a.Config.Core.ChallengeVerifier.Verify(r.Context(), response, a.ClientIP(r))
+		if err != nil || ok {
+			return nil, ok, err
+		}
+	}
+
+	data, err = a.ChallengeData(r, pid)
+	if err != nil {
+		return nil, false, err
+	}
+	if data == nil {
+		// The failures may have expired since they were checked above
+		data = HTMLData{}
+	}
+
+	return data.MergeKV(DataErr, "Please complete the challenge"), false, nil
+}
+
+// ChallengeFailed records a failed login for the client's ip address and
+// the pid if it's not empty.
+func (a *Authboss) ChallengeFailed(r *http.Request, pid string) error {
+	if a.Config.Core.ChallengeVerifier == nil || a.Config.Modules.ChallengeAfter <= 0 {
+		return nil
+	}
+
+	counter := a.Config.Storage.ChallengeCounter
+	window := a.Config.Modules.ChallengeWindow
+	if err := counter.Fail(r.Context(), "ip:"+a.ClientIP(r), window); err != nil {
+		return err
+	}
+	if len(pid) == 0 {
+		return nil
+	}
+
+	return counter.Fail(r.Context(), "pid:"+pid, window)
+}
+
+// ChallengeSucceeded forgets the failed logins for pid.
+func (a *Authboss) ChallengeSucceeded(ctx context.Context, pid string) error {
+	if a.Config.Core.ChallengeVerifier == nil || a.Config.Modules.ChallengeAfter <= 0 {
+		return nil
+	}
+
+	return a.Config.Storage.ChallengeCounter.Reset(ctx, "pid:"+pid)
+}
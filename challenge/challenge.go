@@ -0,0 +1,8 @@
+// Package challenge provides implementations of authboss.ChallengeVerifier
+// and an in-memory authboss.ChallengeCounter.
+//
+// PoW needs no third-party service: clients solve an HMAC signed proof of
+// work in the browser. HTTPVerifier verifies responses with a CAPTCHA
+// service that follows the common siteverify protocol (reCAPTCHA, hCaptcha,
+// Turnstile).
+package challenge
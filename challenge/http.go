@@ -0,0 +1,82 @@
+package challenge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/friendsofgo/errors"
+)
+
+// HTTPVerifier verifies challenge responses by posting them to a
+// verification endpoint, as done by reCAPTCHA, hCaptcha and Turnstile.
+//
+// The endpoint is sent a form with the secret, response and remoteip
+// fields and must reply with a JSON object with a boolean success field.
+type HTTPVerifier struct {
+	// Endpoint is the url responses are verified with, for example
+	// https://www.google.com/recaptcha/api/siteverify
+	Endpoint string
+	// Secret is the secret key given by the service.
+	Secret string
+	// SiteKey is the public key given by the service, it's passed to
+	// pages as the challenge to render the widget with.
+	SiteKey string
+
+	// Client is used to make requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// NewHTTPVerifier constructor
+func NewHTTPVerifier(endpoint, secret, siteKey string) *HTTPVerifier {
+	return &HTTPVerifier{Endpoint: endpoint, Secret: secret, SiteKey: siteKey}
+}
+
+// NewChallenge returns the site key, the challenge itself is issued by the
+// service's widget on the page.
+func (h *HTTPVerifier) NewChallenge(ctx context.Context) (string, error) {
+	return h.SiteKey, nil
+}
+
+// Verify the response with the endpoint
+func (h *HTTPVerifier) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	form := url.Values{
+		"secret":   []string{h.Secret},
+		"response": []string{response},
+	}
+	if len(remoteIP) != 0 {
+		form.Set("remoteip", remoteIP)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to verify challenge")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.Errorf("challenge verification endpoint responded with status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, errors.Wrap(err, "failed to decode challenge verification response")
+	}
+
+	return result.Success, nil
+}
@@ -0,0 +1,73 @@
+package challenge
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPVerifier(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if r.Method != http.MethodPost {
+			t.Error("method was wrong:", r.Method)
+		}
+		if s := r.PostForm.Get("secret"); s != "secret" {
+			t.Error("secret was wrong:", s)
+		}
+		if ip := r.PostForm.Get("remoteip"); ip != "1.2.3.4" {
+			t.Error("remote ip was wrong:", ip)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("response") == "good" {
+			w.Write([]byte(`{"success": true, "hostname": "localhost"}`))
+		} else {
+			w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+		}
+	}))
+	defer server.Close()
+
+	h := NewHTTPVerifier(server.URL, "secret", "sitekey")
+
+	challenge, err := h.NewChallenge(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge != "sitekey" {
+		t.Error("challenge should be the site key:", challenge)
+	}
+
+	if ok, err := h.Verify(context.Background(), "good", "1.2.3.4"); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Error("good response should verify")
+	}
+
+	if ok, err := h.Verify(context.Background(), "bad", "1.2.3.4"); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Error("bad response should not verify")
+	}
+}
+
+func TestHTTPVerifierError(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	h := NewHTTPVerifier(server.URL, "secret", "sitekey")
+	h.Client = server.Client()
+
+	if _, err := h.Verify(context.Background(), "good", ""); err == nil {
+		t.Error("expected an error for a bad status")
+	}
+}
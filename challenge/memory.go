@@ -0,0 +1,90 @@
+package challenge
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired failures are removed from memory
+const sweepInterval = time.Minute
+
+// MemoryCounter is an in-memory authboss.ChallengeCounter. It's not shared
+// between processes, so a load balanced application should use an
+// implementation backed by shared storage instead.
+type MemoryCounter struct {
+	mu        sync.Mutex
+	failures  map[string]*failures
+	lastSweep time.Time
+
+	// now is for testing
+	now func() time.Time
+}
+
+type failures struct {
+	count   int
+	expires time.Time
+}
+
+// NewMemoryCounter constructor
+func NewMemoryCounter() *MemoryCounter {
+	return &MemoryCounter{
+		failures: make(map[string]*failures),
+		now:      time.Now,
+	}
+}
+
+// Fail records a failure for key
+func (m *MemoryCounter) Fail(ctx context.Context, key string, window time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	f, ok := m.failures[key]
+	if !ok || now.After(f.expires) {
+		f = &failures{}
+		m.failures[key] = f
+	}
+	f.count++
+	f.expires = now.Add(window)
+
+	return nil
+}
+
+// sweep removes the failures that have expired
+func (m *MemoryCounter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for key, f := range m.failures {
+		if now.After(f.expires) {
+			delete(m.failures, key)
+		}
+	}
+}
+
+// Failures returns the number of failures for key
+func (m *MemoryCounter) Failures(ctx context.Context, key string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.failures[key]
+	if !ok || m.now().After(f.expires) {
+		return 0, nil
+	}
+
+	return f.count, nil
+}
+
+// Reset forgets the failures for key
+func (m *MemoryCounter) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.failures, key)
+	return nil
+}
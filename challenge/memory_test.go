@@ -0,0 +1,83 @@
+package challenge
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryCounter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Now()
+	m := NewMemoryCounter()
+	m.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if err := m.Fail(ctx, "key", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(30 * time.Second)
+	}
+
+	if n, err := m.Failures(ctx, "key"); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Error("failures were wrong:", n)
+	}
+	if n, _ := m.Failures(ctx, "other"); n != 0 {
+		t.Error("other keys should have no failures:", n)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if n, _ := m.Failures(ctx, "key"); n != 0 {
+		t.Error("failures should be forgotten after the window:", n)
+	}
+
+	if err := m.Fail(ctx, "key", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := m.Failures(ctx, "key"); n != 1 {
+		t.Error("failures should start again:", n)
+	}
+
+	if err := m.Reset(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := m.Failures(ctx, "key"); n != 0 {
+		t.Error("failures should be reset:", n)
+	}
+}
+
+func TestMemoryCounterSweep(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Now()
+	m := NewMemoryCounter()
+	m.now = func() time.Time { return now }
+
+	if err := m.Fail(ctx, "old", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Second)
+	if err := m.Fail(ctx, "new", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.failures["old"]; !ok {
+		t.Error("failures should not be swept more than once per interval")
+	}
+
+	now = now.Add(sweepInterval)
+	if err := m.Fail(ctx, "new", 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.failures["old"]; ok {
+		t.Error("expired failures should have been swept")
+	}
+	if n, _ := m.Failures(ctx, "new"); n != 2 {
+		t.Error("failures were wrong:", n)
+	}
+}
@@ -0,0 +1,194 @@
+package challenge
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	powNonceSize = 16
+
+	// DefaultPoWDifficulty is the number of leading zero bits a solution's
+	// hash must have when PoW.Difficulty is not set.
+	DefaultPoWDifficulty = 18
+	// DefaultPoWExpiry is how long a challenge may be solved for when
+	// PoW.Expiry is not set.
+	DefaultPoWExpiry = 5 * time.Minute
+)
+
+// PoW is a proof of work ChallengeVerifier that needs no third-party
+// service or server side storage for issued challenges.
+//
+// A challenge is a random nonce, an expiry and a difficulty signed with
+// an HMAC of Secret, in the form:
+//
+//	nonce.expiry.difficulty.signature
+//
+// To solve it the client finds a counter such that the SHA-256 of
+// "challenge:counter" starts with difficulty zero bits, and responds with
+// "challenge:counter". Solved challenges are remembered until they expire so
+// they can't be used twice. See Solve.
+type PoW struct {
+	// Secret signs challenges, it should be at least 32 random bytes and
+	// the same for all servers.
+	Secret []byte
+	// Difficulty is the number of leading zero bits required, each extra
+	// bit doubles the average work. DefaultPoWDifficulty if 0.
+	Difficulty int
+	// Expiry is how long a challenge may be solved for. DefaultPoWExpiry
+	// if 0.
+	Expiry time.Duration
+
+	mu   sync.Mutex
+	used map[string]time.Time
+
+	// now is for testing
+	now func() time.Time
+}
+
+// NewPoW constructor
+func NewPoW(secret []byte, difficulty int) *PoW {
+	return &PoW{Secret: secret, Difficulty: difficulty}
+}
+
+// NewChallenge creates a new signed challenge
+func (p *PoW) NewChallenge(ctx context.Context) (string, error) {
+	nonce := make([]byte, powNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	expiry := p.clock().Add(p.expiry()).Unix()
+	payload := strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString(nonce),
+		strconv.FormatInt(expiry, 10),
+		strconv.Itoa(p.difficulty()),
+	}, ".")
+
+	return payload + "." + p.sign(payload), nil
+}
+
+// Verify checks the signature, expiry and work of a response and that it
+// hasn't been used before.
+func (p *PoW) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	colon := strings.LastIndexByte(response, ':')
+	if colon < 0 {
+		return false, nil
+	}
+	challenge := response[:colon]
+
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 {
+		return false, nil
+	}
+
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(p.sign(payload))) {
+		return false, nil
+	}
+
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false, nil
+	}
+	expires := time.Unix(expiry, 0)
+	now := p.clock()
+	if now.After(expires) {
+		return false, nil
+	}
+
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil || difficulty < p.difficulty() {
+		return false, nil
+	}
+
+	if leadingZeroBits(sha256.Sum256([]byte(response))) < difficulty {
+		return false, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for c, exp := range p.used {
+		if now.After(exp) {
+			delete(p.used, c)
+		}
+	}
+
+	if _, ok := p.used[challenge]; ok {
+		return false, nil
+	}
+	if p.used == nil {
+		p.used = make(map[string]time.Time)
+	}
+	p.used[challenge] = expires
+
+	return true, nil
+}
+
+// Solve finds a response to a PoW challenge. It's what clients have to do,
+// usually in javascript, and is useful for testing.
+func Solve(challenge string) string {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 {
+		return ""
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return ""
+	}
+
+	for counter := 0; ; counter++ {
+		response := challenge + ":" + strconv.Itoa(counter)
+		if leadingZeroBits(sha256.Sum256([]byte(response))) >= difficulty {
+			return response
+		}
+	}
+}
+
+func (p *PoW) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.Secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (p *PoW) difficulty() int {
+	if p.Difficulty <= 0 {
+		return DefaultPoWDifficulty
+	}
+	return p.Difficulty
+}
+
+func (p *PoW) expiry() time.Duration {
+	if p.Expiry <= 0 {
+		return DefaultPoWExpiry
+	}
+	return p.Expiry
+}
+
+func (p *PoW) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,106 @@
+package challenge
+
+import (
+	"context"
+	"crypto/sha256"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoW(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	p := NewPoW([]byte("secret"), 8)
+
+	challenge, err := p.NewChallenge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts := strings.Split(challenge, "."); len(parts) != 4 || parts[2] != "8" {
+		t.Error("challenge was malformed:", challenge)
+	}
+
+	response := Solve(challenge)
+	if ok, err := p.Verify(ctx, response, ""); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Error("solved challenge should verify")
+	}
+
+	if ok, _ := p.Verify(ctx, response, ""); ok {
+		t.Error("a solved challenge should not be usable twice")
+	}
+}
+
+func TestPoWInvalid(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	p := NewPoW([]byte("secret"), 8)
+
+	challenge, err := p.NewChallenge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewPoW([]byte("other"), 8)
+	forged, err := other.NewChallenge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	easy := NewPoW([]byte("secret"), 1)
+	easyChallenge, err := easy.NewChallenge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"Empty":        "",
+		"NoCounter":    challenge,
+		"Garbage":      "a.b.c:1",
+		"WrongSecret":  Solve(forged),
+		"TooEasy":      Solve(easyChallenge),
+		"NotEnoughPoW": unsolved(challenge, 8),
+	}
+
+	for name, response := range tests {
+		if ok, err := p.Verify(ctx, response, ""); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if ok {
+			t.Errorf("%s: should not verify", name)
+		}
+	}
+}
+
+func TestPoWExpired(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Now()
+	p := &PoW{Secret: []byte("secret"), Difficulty: 4, Expiry: time.Minute}
+	p.now = func() time.Time { return now }
+
+	challenge, err := p.NewChallenge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if ok, _ := p.Verify(ctx, Solve(challenge), ""); ok {
+		t.Error("expired challenge should not verify")
+	}
+}
+
+// unsolved finds a response that does not have enough leading zero bits
+func unsolved(challenge string, difficulty int) string {
+	for counter := 0; ; counter++ {
+		response := challenge + ":" + strconv.Itoa(counter)
+		if leadingZeroBits(sha256.Sum256([]byte(response))) < difficulty {
+			return response
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package authboss
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockChallengeVerifier struct{}
+
+func (mockChallengeVerifier) NewChallenge(ctx context.Context) (string, error) {
+	return "challenge", nil
+}
+
+func (mockChallengeVerifier) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	return response == "solved", nil
+}
+
+type mockChallengeCounter map[string]int
+
+func (m mockChallengeCounter) Fail(ctx context.Context, key string, window time.Duration) error {
+	m[key]++
+	return nil
+}
+
+func (m mockChallengeCounter) Failures(ctx context.Context, key string) (int, error) {
+	return m[key], nil
+}
+
+func (m mockChallengeCounter) Reset(ctx context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+type mockChallengeValues struct {
+	response string
+}
+
+func (mockChallengeValues) Validate() []error              { return nil }
+func (m mockChallengeValues) GetChallengeResponse() string { return m.response }
+
+func TestChallengeRequired(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	r := httptest.NewRequest("POST", "/", nil)
+
+	if required, err := ab.ChallengeRequired(r, "test@test.com"); err != nil || required {
+		t.Error("challenges should not be required without a verifier", err)
+	}
+
+	counter := mockChallengeCounter{}
+	ab.Config.Core.ChallengeVerifier = mockChallengeVerifier{}
+	ab.Config.Storage.ChallengeCounter = counter
+	ab.Config.Modules.ChallengeAfter = 2
+
+	for i := 0; i < 2; i++ {
+		if required, _ := ab.ChallengeRequired(r, "test@test.com"); required {
+			t.Errorf("%d) challenge should not be required yet", i)
+		}
+		if err := ab.ChallengeFailed(r, "test@test.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if required, _ := ab.ChallengeRequired(r, "test@test.com"); !required {
+		t.Error("challenge should be required for the pid")
+	}
+
+	if err := ab.ChallengeSucceeded(r.Context(), "test@test.com"); err != nil {
+		t.Fatal(err)
+	}
+	if required, _ := ab.ChallengeRequired(r, ""); !required {
+		t.Error("challenge should still be required for the ip")
+	}
+
+	other := httptest.NewRequest("POST", "/", nil)
+	other.RemoteAddr = "10.0.0.1:1234"
+	if required, _ := ab.ChallengeRequired(other, "test@test.com"); required {
+		t.Error("challenge should not be required for a reset pid from another ip")
+	}
+
+	ab.Config.Modules.ChallengeAfter = 0
+	if required, _ := ab.ChallengeRequired(other, ""); !required {
+		t.Error("challenge should always be required when ChallengeAfter is 0")
+	}
+}
+
+func TestCheckChallenge(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.ChallengeVerifier = mockChallengeVerifier{}
+	ab.Config.Modules.ChallengeAfter = 0
+
+	r := httptest.NewRequest("POST", "/", nil)
+
+	data, ok, err := ab.CheckChallenge(r, "", mockChallengeValues{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("missing response should fail")
+	}
+	if data[DataChallengeRequired] != true || data[DataChallenge] != "challenge" {
+		t.Error("data should have a new challenge:", data)
+	}
+	if _, hasErr := data[DataErr]; !hasErr {
+		t.Error("data should have an error")
+	}
+
+	if _, ok, _ = ab.CheckChallenge(r, "", mockChallengeValues{response: "wrong"}); ok {
+		t.Error("wrong response should fail")
+	}
+
+	data, ok, err = ab.CheckChallenge(r, "", mockChallengeValues{response: "solved"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || data != nil {
+		t.Error("solved challenge should pass without data")
+	}
+}
+
+// expiringChallengeCounter has failures the first time it's asked only
+type expiringChallengeCounter struct {
+	mockChallengeCounter
+	asked bool
+}
+
+func (m *expiringChallengeCounter) Failures(ctx context.Context, key string) (int, error) {
+	if m.asked {
+		return 0, nil
+	}
+	m.asked = true
+	return 5, nil
+}
+
+func TestCheckChallengeExpired(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.ChallengeVerifier = mockChallengeVerifier{}
+	ab.Config.Storage.ChallengeCounter = &expiringChallengeCounter{}
+	ab.Config.Modules.ChallengeAfter = 3
+
+	r := httptest.NewRequest("POST", "/", nil)
+
+	data, ok, err := ab.CheckChallenge(r, "", mockChallengeValues{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("missing response should fail")
+	}
+	if _, hasErr := data[DataErr]; !hasErr {
+		t.Error("data should have an error")
+	}
+}
+
+func TestChallengeInitRequiresCounter(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	ab.Config.Core.ChallengeVerifier = mockChallengeVerifier{}
+
+	if err := ab.Init(); err == nil {
+		t.Error("expected an error without a challenge counter")
+	}
+}
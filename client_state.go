@@ -21,6 +21,9 @@ const (
 	// SessionLastAction is the session key to retrieve the
 	// last action of a user.
 	SessionLastAction = "last_action"
+	// SessionSecurityStamp is the security stamp of the user at the time
+	// they logged in, see SecurityStampUser.
+	SessionSecurityStamp = "security_stamp"
 	// Session2FA is set when a user has been authenticated with a second factor
 	Session2FA = "twofactor"
 	// Session2FAAuthToken is a random token set in the session to be verified
@@ -35,6 +38,15 @@ const (
 	// SessionOAuth2Params is the additional settings for oauth
 	// like redirection/remember.
 	SessionOAuth2Params = "oauth2_params"
+	// SessionOAuth2Nonce is the nonce sent to the oauth2 provider that must
+	// come back in the ID token.
+	SessionOAuth2Nonce = "oauth2_nonce"
+	// SessionOAuth2Verifier is the PKCE code verifier that must be sent
+	// along with the code to get an access token.
+	SessionOAuth2Verifier = "oauth2_verifier"
+	// SessionOAuth2Link is the pid of the user linking an oauth2 provider
+	// to their account, it's set instead of logging in when the flow ends.
+	SessionOAuth2Link = "oauth2_link"
 
 	// CookieRemember is used for cookies and form input names.
 	CookieRemember = "rm"
@@ -304,6 +316,7 @@ func DelKnownSession(w http.ResponseWriter) {
 	DelSession(w, SessionKey)
 	DelSession(w, SessionHalfAuthKey)
 	DelSession(w, SessionLastAction)
+	DelSession(w, SessionSecurityStamp)
 }
 
 // DelKnownCookie deletes all known cookie variables, which can be used
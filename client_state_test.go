@@ -192,14 +192,15 @@ func TestDelKnown(t *testing.T) {
 		}
 	}
 
-	if len(csrw.sessionStateEvents) != 3 {
-		t.Error("should have deleted 3 session entries")
+	if len(csrw.sessionStateEvents) != 4 {
+		t.Error("should have deleted 4 session entries")
 	}
 	mustBeDel(csrw.sessionStateEvents[0])
 	mustBeDel(csrw.sessionStateEvents[1])
 	mustBeDel(csrw.sessionStateEvents[2])
+	mustBeDel(csrw.sessionStateEvents[3])
 
-	for i, key := range []string{SessionKey, SessionHalfAuthKey, SessionLastAction} {
+	for i, key := range []string{SessionKey, SessionHalfAuthKey, SessionLastAction, SessionSecurityStamp} {
 		if sessionKey := csrw.sessionStateEvents[i].Key; key != sessionKey {
 			t.Errorf("%d) key was wrong, want: %s, got: %s", i, key, sessionKey)
 		}
@@ -0,0 +1,84 @@
+package authboss
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"math/big"
+)
+
+const (
+	codeSelectorSize = 32
+)
+
+// GenerateCode generates the pieces needed for a short numeric code that's
+// sent to a user, as confirm and recover do
+// selector: hash of a 32 byte value
+// (to be stored in the database and used in SELECT query)
+// verifier: hash of the 32 byte value and the code
+// (to be stored in database but never used in SELECT query)
+// token: the base64 encoded 32 byte value, submitted along with the code
+// code: the numeric code of the given length for the user to enter
+func GenerateCode(length int) (selector, verifier, token, code string, err error) {
+	rawSelector := make([]byte, codeSelectorSize)
+	if _, err = io.ReadFull(rand.Reader, rawSelector); err != nil {
+		return "", "", "", "", err
+	}
+
+	digits := make([]byte, length)
+	for i := range digits {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", "", "", "", err
+		}
+		digits[i] = byte('0' + n.Int64())
+	}
+	code = string(digits)
+
+	selectorBytes := sha512.Sum512(rawSelector)
+	verifierBytes := codeVerifier(rawSelector, code)
+
+	return base64.StdEncoding.EncodeToString(selectorBytes[:]),
+		base64.StdEncoding.EncodeToString(verifierBytes[:]),
+		base64.URLEncoding.EncodeToString(rawSelector),
+		code,
+		nil
+}
+
+// CodeSelector returns the selector to look up the user a code token from
+// GenerateCode belongs to. ok is false if the token is malformed.
+func CodeSelector(token string) (selector string, ok bool) {
+	rawSelector, err := base64.URLEncoding.DecodeString(token)
+	if err != nil || len(rawSelector) != codeSelectorSize {
+		return "", false
+	}
+
+	selectorBytes := sha512.Sum512(rawSelector)
+	return base64.StdEncoding.EncodeToString(selectorBytes[:]), true
+}
+
+// VerifyCode checks in constant time that code was generated along with
+// token by GenerateCode, given the verifier that was stored.
+func VerifyCode(token, code, verifier string) bool {
+	rawSelector, err := base64.URLEncoding.DecodeString(token)
+	if err != nil || len(rawSelector) != codeSelectorSize {
+		return false
+	}
+
+	dbVerifierBytes, err := base64.StdEncoding.DecodeString(verifier)
+	if err != nil {
+		return false
+	}
+
+	verifierBytes := codeVerifier(rawSelector, code)
+	return subtle.ConstantTimeEq(int32(len(verifierBytes)), int32(len(dbVerifierBytes))) == 1 &&
+		subtle.ConstantTimeCompare(verifierBytes[:], dbVerifierBytes) == 1
+}
+
+// codeVerifier hashes the code with the selector so equal codes don't
+// produce equal verifiers
+func codeVerifier(rawSelector []byte, code string) [sha512.Size]byte {
+	return sha512.Sum512(append(append([]byte{}, rawSelector...), code...))
+}
@@ -0,0 +1,71 @@
+package authboss
+
+import "testing"
+
+func TestGenerateCode(t *testing.T) {
+	t.Parallel()
+
+	selector, verifier, token, code, err := GenerateCode(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(selector) == 0 || len(verifier) == 0 || len(token) == 0 {
+		t.Error("selector, verifier and token should be set")
+	}
+	if len(code) != 8 {
+		t.Error("code was the wrong length:", code)
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			t.Error("code should be numeric:", code)
+		}
+	}
+
+	_, otherVerifier, _, _, _ := GenerateCode(8)
+	if verifier == otherVerifier {
+		t.Error("verifiers should differ")
+	}
+}
+
+func TestCodeSelector(t *testing.T) {
+	t.Parallel()
+
+	selector, _, token, _, err := GenerateCode(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := CodeSelector(token); !ok || got != selector {
+		t.Error("selector was wrong:", got)
+	}
+	if _, ok := CodeSelector("short"); ok {
+		t.Error("a malformed token should not have a selector")
+	}
+}
+
+func TestVerifyCode(t *testing.T) {
+	t.Parallel()
+
+	_, verifier, token, code, err := GenerateCode(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, otherToken, _, err := GenerateCode(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !VerifyCode(token, code, verifier) {
+		t.Error("the code should be valid")
+	}
+	if VerifyCode(token, code+"1", verifier) {
+		t.Error("a wrong code should not be valid")
+	}
+	if VerifyCode(otherToken, code, verifier) {
+		t.Error("a code with another token should not be valid")
+	}
+	if VerifyCode(token, code, "not base64!") {
+		t.Error("a malformed verifier should not be valid")
+	}
+}
@@ -30,6 +30,9 @@ type Config struct {
 
 		// LockNotOK is a path to go to when the user fails
 		LockNotOK string
+		// UnlockOK is the redirect path after a user requests an unlock
+		// e-mail or unlocks their account with the link in it.
+		UnlockOK string
 
 		// LogoutOK is the redirect path after a log out.
 		LogoutOK string
@@ -39,6 +42,9 @@ type Config struct {
 		// OAuth2LoginNotOK is the redirect path after
 		// an unsuccessful oauth2 login
 		OAuth2LoginNotOK string
+		// OAuth2LinkOK is the redirect path after linking or unlinking an
+		// oauth2 provider
+		OAuth2LinkOK string
 
 		// RecoverOK is the redirect path after a successful recovery of a
 		// password.
@@ -46,12 +52,18 @@ type Config struct {
 
 		// RegisterOK is the redirect path after a successful registration.
 		RegisterOK string
+		// InviteOK is the redirect path after a user sends an invitation.
+		InviteOK string
 
 		// RootURL is the scheme+host+port of the web application
 		// (eg https://www.happiness.com:8080) for url generation.
 		// No trailing slash.
 		RootURL string
 
+		// RedirectPolicy decides where users can be sent with the redir
+		// parameter, only paths on this site are allowed by default.
+		RedirectPolicy RedirectPolicy
+
 		// TwoFactorEmailAuthNotOK is where a user is redirected when
 		// the user attempts to add 2fa to their account without verifying
 		// their e-mail OR when they've completed the first step towards
@@ -70,6 +82,51 @@ type Config struct {
 		// from an e-mail, but in api-like cases it needs to be able to be a
 		// post since there's data that must be sent to it.
 		ConfirmMethod string
+		// ConfirmResend enables the /confirm/resend route where users can
+		// ask for a new confirmation e-mail.
+		ConfirmResend bool
+		// ConfirmResendCooldown is how long a user must wait after a
+		// confirmation e-mail was sent before another can be sent to them
+		// with the /confirm/resend route. Users must implement
+		// ConfirmResendableUser for it to apply.
+		ConfirmResendCooldown time.Duration
+		// ConfirmWithCode makes confirmation e-mails contain a short
+		// numeric code that's entered on the /confirm/code page instead of
+		// a link, which suits mobile and api clients. Users must implement
+		// ConfirmCodeUser.
+		ConfirmWithCode bool
+		// ConfirmCodeLength is the number of digits in a confirm code.
+		ConfirmCodeLength int
+		// ConfirmCodeAttempts is how many wrong codes may be entered
+		// before a new code must be sent.
+		ConfirmCodeAttempts int
+		// ConfirmGracePeriod is how long after registering an unconfirmed
+		// user may still log in and use routes protected by
+		// confirm.Middleware. Users must implement CreatedAtUser for there
+		// to be a grace period.
+		ConfirmGracePeriod time.Duration
+		// ConfirmTokenDuration controls how long a token sent via e-mail
+		// for confirming an account is valid for. It's only enforced for
+		// users that implement ConfirmExpiringUser.
+		ConfirmTokenDuration time.Duration
+
+		// EventAsyncWorkers is how many goroutines run the event handlers
+		// registered with Events.AfterAsync. If it's 0, the default, those
+		// handlers are run synchronously like any other. When it's set
+		// Authboss.Shutdown must be called to stop the goroutines.
+		EventAsyncWorkers int
+		// EventAsyncQueueSize is how many async event handlers may be waiting
+		// for a worker before new ones are dropped.
+		EventAsyncQueueSize int
+
+		// ChallengeAfter is how many failed logins an ip address or pid may
+		// have before Core.ChallengeVerifier requires a challenge on the
+		// login, register and recover pages. If it's 0 a challenge is
+		// always required.
+		ChallengeAfter int
+		// ChallengeWindow is how long failed logins are remembered for after
+		// the last one when deciding whether a challenge is required.
+		ChallengeWindow time.Duration
 
 		// ExpireAfter controls the time an account is idle before being
 		// logged out by the ExpireMiddleware.
@@ -81,6 +138,18 @@ type Config struct {
 		LockWindow time.Duration
 		// LockDuration is how long an account is locked for.
 		LockDuration time.Duration
+		// LockPolicy decides when and for how long accounts are locked.
+		// If nil the lock module uses a lock.FixedPolicy built from
+		// LockAfter, LockWindow and LockDuration.
+		LockPolicy LockPolicy
+		// LockUnlockByEmail allows locked users to unlock their account
+		// with a single-use link sent to them via e-mail, both when they're
+		// locked and when they ask for one. Users must implement
+		// UnlockableUser and the server storer UnlockingServerStorer.
+		LockUnlockByEmail bool
+		// LockUnlockTokenDuration controls how long a token sent via e-mail
+		// for unlocking an account is valid for.
+		LockUnlockTokenDuration time.Duration
 
 		// LogoutMethod is the method the logout route should use
 		// (default should be DELETE)
@@ -128,6 +197,57 @@ type Config struct {
 		// then it would be available to be whitelisted by this
 		// configuration variable.
 		RegisterPreserveFields []string
+		// RegisterAfter is what happens once a user has registered, see
+		// RegisterAfter.
+		RegisterAfter RegisterAfter
+		// RegisterRequireInvite only lets users register with an invitation
+		// from the invite module. The storer must implement
+		// InvitingServerStorer.
+		RegisterRequireInvite bool
+		// InviteDuration is how long an invitation is valid for, unless
+		// invite.Send was given another duration.
+		InviteDuration time.Duration
+		// RegisterAllowedDomains when not empty only lets e-mail addresses
+		// on these domains, or their subdomains, register. It applies to
+		// the register and oauth2 modules.
+		RegisterAllowedDomains []string
+		// RegisterBlockedDomains stops e-mail addresses on these domains,
+		// or their subdomains, from registering.
+		RegisterBlockedDomains []string
+		// RegisterBlockDisposable stops e-mail addresses from the bundled
+		// list of disposable e-mail providers from registering,
+		// see IsDisposableDomain.
+		RegisterBlockDisposable bool
+		// RegisterDisposableDomains are added to the list of disposable
+		// e-mail providers when RegisterBlockDisposable is set. To replace
+		// the bundled list see SetDisposableDomains.
+		RegisterDisposableDomains []string
+
+		// RateLimitIP is how many attempts a single ip address may make on
+		// the routes throttled by the ratelimit module.
+		RateLimitIP Rate
+		// RateLimitIPPID is how many attempts may be made on the routes
+		// throttled by the ratelimit module for a single account from a
+		// single ip address.
+		RateLimitIPPID Rate
+
+		// TrustedProxyHeader is the name of a header set by a trusted
+		// reverse proxy containing the client's ip address, for example
+		// X-Forwarded-For or X-Real-IP. It must not be set unless all
+		// requests pass through such a proxy, since clients could spoof
+		// their address. See Authboss.ClientIP.
+		TrustedProxyHeader string
+
+		// SecurityStampMigrate lets sessions that have no security stamp
+		// take the user's current one in SecurityStampMiddleware, instead
+		// of being logged out. It's for sessions logged in before stamps
+		// were recorded and should be turned off once they have expired.
+		SecurityStampMigrate bool
+
+		// RememberDuration is how long a remember me series is valid for
+		// after the user logs in. It only applies to storers implementing
+		// SeriesRememberingServerStorer.
+		RememberDuration time.Duration
 
 		// RecoverTokenDuration controls how long a token sent via
 		// email for password recovery is valid for.
@@ -136,6 +256,42 @@ type Config struct {
 		// recovery, if false they will be redirected and need to log in
 		// again manually.
 		RecoverLoginAfterRecovery bool
+		// RecoverMinInterval is how long after a recovery e-mail was sent
+		// to an account before another will be sent. Users must implement
+		// RecoverResendableUser for it to be enforced.
+		RecoverMinInterval time.Duration
+		// RecoverRateIP is how many recovery e-mails may be sent because
+		// of requests from a single ip address. It's counted in
+		// Storage.RateLimiter and not enforced if that's not set.
+		RecoverRateIP Rate
+		// RecoverSkip2FA if true lets users that have two factor
+		// authentication enabled reset their password with only the
+		// e-mailed token. By default they must also enter a totp code or
+		// one of their recovery codes.
+		RecoverSkip2FA bool
+		// RecoverChannel decides whether recovery is done with an e-mailed
+		// link or a code sent by text message, see RecoverChannel.
+		RecoverChannel RecoverChannel
+		// RecoverSMSSender sends recovery codes by text message. It must be
+		// set when RecoverChannel is not RecoverChannelEmail, and when it's
+		// set users with only sms 2fa are texted their second factor.
+		RecoverSMSSender SMSSender
+		// RecoverCodeLength is the number of digits in a recovery code
+		// sent by text message.
+		RecoverCodeLength int
+		// RecoverCodeDuration is how long a recovery code sent by text
+		// message is valid for.
+		RecoverCodeDuration time.Duration
+		// RecoverCodeAttempts is how many wrong recovery codes, or wrong
+		// second factors for 2fa users, can be entered before a new one
+		// must be sent. Only users that implement RecoverCodeUser are
+		// limited.
+		RecoverCodeAttempts int
+
+		// ConsentVersion is the version of the terms of service users must
+		// have accepted to log in with the consent module. Changing it makes
+		// everyone accept the new version the next time they log in.
+		ConsentVersion string
 
 		// OAuth2Providers lists all providers that can be used. See
 		// OAuthProvider documentation for more details.
@@ -193,6 +349,16 @@ type Config struct {
 		// database for user operations.
 		Server ServerStorer
 
+		// RateLimiter stores the attempts counted by the ratelimit module.
+		// If it's not set the ratelimit module uses an in-memory
+		// implementation, which is not shared between servers.
+		RateLimiter RateLimiter
+
+		// ChallengeCounter stores failed logins to decide when a challenge is
+		// required, it must be set if Core.ChallengeVerifier is set and
+		// Modules.ChallengeAfter is not 0. See challenge.MemoryCounter.
+		ChallengeCounter ChallengeCounter
+
 		// CookieState must be defined to provide an interface capapable of
 		// storing cookies for the given response, and reading them from the
 		// request.
@@ -239,6 +405,11 @@ type Config struct {
 		// Mailer is the mailer being used to send e-mails out via smtp
 		Mailer Mailer
 
+		// ChallengeVerifier issues and verifies challenges like CAPTCHAs
+		// that clients must complete after failing to log in too many times.
+		// If it's nil challenges are never required.
+		ChallengeVerifier ChallengeVerifier
+
 		// Logger implies just a few log levels for use, can optionally
 		// also implement the ContextLogger to be able to upgrade to a
 		// request specific logger.
@@ -254,22 +425,44 @@ func (c *Config) Defaults() {
 	c.Paths.ConfirmOK = "/"
 	c.Paths.ConfirmNotOK = "/"
 	c.Paths.LockNotOK = "/"
+	c.Paths.UnlockOK = "/"
 	c.Paths.LogoutOK = "/"
 	c.Paths.OAuth2LoginOK = "/"
 	c.Paths.OAuth2LoginNotOK = "/"
+	c.Paths.OAuth2LinkOK = "/"
 	c.Paths.RecoverOK = "/"
 	c.Paths.RegisterOK = "/"
+	c.Paths.InviteOK = "/"
 	c.Paths.RootURL = "http://localhost:8080"
 	c.Paths.TwoFactorEmailAuthNotOK = "/"
 
 	c.Modules.BCryptCost = bcrypt.DefaultCost
+	c.Modules.ChallengeAfter = 3
+	c.Modules.ChallengeWindow = 15 * time.Minute
+	c.Modules.ConfirmCodeLength = 6
+	c.Modules.ConfirmCodeAttempts = 5
 	c.Modules.ConfirmMethod = http.MethodGet
+	c.Modules.ConfirmResendCooldown = 5 * time.Minute
+	c.Modules.ConfirmTokenDuration = 24 * time.Hour
+	c.Modules.EventAsyncQueueSize = 64
 	c.Modules.ExpireAfter = time.Hour
 	c.Modules.LockAfter = 3
 	c.Modules.LockWindow = 5 * time.Minute
 	c.Modules.LockDuration = 12 * time.Hour
+	c.Modules.LockUnlockTokenDuration = time.Hour
 	c.Modules.LogoutMethod = "DELETE"
 	c.Modules.MailRouteMethod = http.MethodGet
+	c.Modules.RateLimitIP = Rate{Attempts: 30, Per: time.Minute}
+	c.Modules.RateLimitIPPID = Rate{Attempts: 5, Per: time.Minute}
+	c.Modules.RememberDuration = 30 * 24 * time.Hour
 	c.Modules.RecoverLoginAfterRecovery = false
 	c.Modules.RecoverTokenDuration = 24 * time.Hour
+	c.Modules.RecoverMinInterval = 5 * time.Minute
+	c.Modules.RecoverRateIP = Rate{Attempts: 10, Per: time.Hour}
+	c.Modules.RecoverChannel = RecoverChannelEmail
+	c.Modules.RegisterAfter = RegisterAfterLogin
+	c.Modules.RecoverCodeLength = 6
+	c.Modules.RecoverCodeDuration = 10 * time.Minute
+	c.Modules.RecoverCodeAttempts = 5
+	c.Modules.InviteDuration = 7 * 24 * time.Hour
 }
@@ -0,0 +1,187 @@
+package confirm
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/friendsofgo/errors"
+
+	"github.com/p000ic/authboss-echo"
+)
+
+const (
+	// PageConfirmCode is the page for entering a confirm code
+	PageConfirmCode = "confirm_code"
+
+	// EmailConfirmCodeHTML is the name of the html template for code e-mails
+	EmailConfirmCodeHTML = "confirm_code_html"
+	// EmailConfirmCodeTxt is the name of the text template for code e-mails
+	EmailConfirmCodeTxt = "confirm_code_txt"
+
+	// DataConfirmCode is the name of the e-mail template variable
+	// that holds the code the user must enter.
+	DataConfirmCode = "code"
+
+	// SessionConfirmSelector is the session key holding the selector for
+	// the code that was last sent, so the user only has to enter the code.
+	SessionConfirmSelector = "confirm_selector"
+
+	confirmCodeInvalid  = "The confirmation code is invalid."
+	confirmCodeAttempts = "Too many wrong codes were entered, please ask for a new one to be sent to you."
+)
+
+// initCode sets up the routes and templates for confirming with a code
+func (c *Confirm) initCode() error {
+	if err := c.Authboss.Config.Core.ViewRenderer.Load(PageConfirmCode); err != nil {
+		return err
+	}
+
+	if err := c.Authboss.Config.Core.MailRenderer.Load(EmailConfirmCodeHTML, EmailConfirmCodeTxt); err != nil {
+		return err
+	}
+
+	c.Authboss.Config.Core.Router.Get("/confirm/code", c.Authboss.Config.Core.ErrorHandler.Wrap(c.CodeGet))
+	c.Authboss.Config.Core.Router.Post("/confirm/code", c.Authboss.Config.Core.ErrorHandler.Wrap(c.CodePost))
+	c.Authboss.LimitRoute("/confirm/code", authboss.RateLimitedRoute{SessionKey: SessionConfirmSelector})
+
+	return nil
+}
+
+// StartConfirmationCode begins confirmation on a user by setting them to
+// require confirmation via a created code, and optionally sending them an
+// e-mail with it. The returned selector must be submitted with the code,
+// StartConfirmationWeb keeps it in the session but api clients may prefer
+// to hand it to the client.
+func (c *Confirm) StartConfirmationCode(ctx context.Context, user authboss.ConfirmableUser, sendEmail bool) (string, error) {
+	logger := c.Authboss.Logger(ctx)
+
+	cu := authboss.MustBeConfirmCode(user)
+
+	selector, verifier, token, code, err := authboss.GenerateCode(c.Authboss.Config.Modules.ConfirmCodeLength)
+	if err != nil {
+		return "", err
+	}
+
+	cu.PutConfirmed(false)
+	cu.PutConfirmSelector(selector)
+	cu.PutConfirmVerifier(verifier)
+	cu.PutConfirmAttempts(0)
+	cu.PutConfirmExpiry(time.Now().UTC().Add(c.Authboss.Config.Modules.ConfirmTokenDuration))
+	if ru, ok := user.(authboss.ConfirmResendableUser); ok {
+		ru.PutConfirmLastSent(time.Now().UTC())
+	}
+
+	logger.Infof("generated new confirm code for user: %s", user.GetPID())
+	if err := c.Authboss.Config.Storage.Server.Save(ctx, cu); err != nil {
+		return "", errors.Wrap(err, "failed to save user during StartConfirmationCode, user data may be in weird state")
+	}
+
+	if !sendEmail {
+		return token, nil
+	}
+
+	if c.Authboss.Config.Modules.MailNoGoroutine {
+		c.SendConfirmCodeEmail(ctx, user.GetEmail(), code)
+	} else {
+		go c.SendConfirmCodeEmail(ctx, user.GetEmail(), code)
+	}
+
+	return token, nil
+}
+
+// SendConfirmCodeEmail sends an e-mail with a confirm code to a user
+func (c *Confirm) SendConfirmCodeEmail(ctx context.Context, to, code string) {
+	logger := c.Authboss.Logger(ctx)
+
+	email := authboss.Email{
+		To:       []string{to},
+		From:     c.Config.Mail.From,
+		FromName: c.Config.Mail.FromName,
+		Subject:  c.Config.Mail.SubjectPrefix + "Confirm New Account",
+	}
+
+	logger.Infof("sending confirm code e-mail to: %s", to)
+
+	ro := authboss.EmailResponseOptions{
+		Data:         authboss.NewHTMLData(DataConfirmCode, code),
+		HTMLTemplate: EmailConfirmCodeHTML,
+		TextTemplate: EmailConfirmCodeTxt,
+	}
+	if err := c.Authboss.Email(ctx, email, ro); err != nil {
+		logger.Errorf("failed to send confirm code e-mail to %s: %+v", to, err)
+	}
+}
+
+// CodeGet renders a form for a user to enter their confirm code.
+func (c *Confirm) CodeGet(w http.ResponseWriter, r *http.Request) error {
+	return c.Authboss.Config.Core.Responder.Respond(w, r, http.StatusOK, PageConfirmCode, nil)
+}
+
+// CodePost confirms a user with a valid code
+func (c *Confirm) CodePost(w http.ResponseWriter, r *http.Request) error {
+	logger := c.RequestLogger(r)
+
+	validatable, err := c.Authboss.Config.Core.BodyReader.Read(PageConfirmCode, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("confirm code validation failed")
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return c.Authboss.Config.Core.Responder.Respond(w, r, http.StatusOK, PageConfirmCode, data)
+	}
+
+	values := authboss.MustHaveCodeValues(validatable)
+
+	token := values.GetSelector()
+	if len(token) == 0 {
+		token, _ = authboss.GetSession(r, SessionConfirmSelector)
+	}
+
+	selector, ok := authboss.CodeSelector(token)
+	if !ok {
+		logger.Infof("invalid confirm code selector submitted: %s", token)
+		return c.invalidCode(w, r, confirmCodeInvalid)
+	}
+
+	storer := authboss.EnsureCanConfirm(c.Authboss.Config.Storage.Server)
+	user, err := storer.LoadByConfirmSelector(r.Context(), selector)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("confirm code selector was not found in database: %s", selector)
+		return c.invalidCode(w, r, confirmCodeInvalid)
+	} else if err != nil {
+		return err
+	}
+
+	cu := authboss.MustBeConfirmCode(user)
+	if time.Now().UTC().After(cu.GetConfirmExpiry()) {
+		logger.Infof("user %s confirm code has expired", cu.GetPID())
+		return c.invalidCode(w, r, confirmExpiredFlash)
+	}
+
+	if cu.GetConfirmAttempts() >= c.Authboss.Config.Modules.ConfirmCodeAttempts {
+		logger.Infof("user %s has entered too many wrong confirm codes", cu.GetPID())
+		return c.invalidCode(w, r, confirmCodeAttempts)
+	}
+
+	if !authboss.VerifyCode(token, strings.TrimSpace(values.GetCode()), cu.GetConfirmVerifier()) {
+		logger.Infof("user %s entered the wrong confirm code", cu.GetPID())
+		cu.PutConfirmAttempts(cu.GetConfirmAttempts() + 1)
+		if err = c.Authboss.Config.Storage.Server.Save(r.Context(), cu); err != nil {
+			return err
+		}
+		return c.invalidCode(w, r, confirmCodeInvalid)
+	}
+
+	authboss.DelSession(w, SessionConfirmSelector)
+	cu.PutConfirmAttempts(0)
+	return c.confirmUser(w, r, cu)
+}
+
+func (c *Confirm) invalidCode(w http.ResponseWriter, r *http.Request, message string) error {
+	data := authboss.HTMLData{authboss.DataErr: message}
+	return c.Authboss.Config.Core.Responder.Respond(w, r, http.StatusOK, PageConfirmCode, data)
+}
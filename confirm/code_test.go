@@ -0,0 +1,225 @@
+package confirm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/mocks"
+)
+
+func TestInitCode(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	viewRenderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.MailRenderer = renderer
+	ab.Config.Core.ViewRenderer = viewRenderer
+	ab.Config.Core.ErrorHandler = errHandler
+	ab.Config.Modules.ConfirmWithCode = true
+
+	c := &Confirm{}
+	if err := c.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(EmailConfirmHTML, EmailConfirmTxt, EmailConfirmCodeHTML, EmailConfirmCodeTxt); err != nil {
+		t.Error(err)
+	}
+	if err := viewRenderer.HasLoadedViews(PageConfirmCode); err != nil {
+		t.Error(err)
+	}
+
+	if err := router.HasGets("/confirm", "/confirm/code"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/confirm/code"); err != nil {
+		t.Error(err)
+	}
+}
+
+func codeSetup() *testHarness {
+	harness := testSetup()
+	harness.ab.Modules.ConfirmWithCode = true
+
+	return harness
+}
+
+func TestStartConfirmationWebCode(t *testing.T) {
+	t.Parallel()
+
+	harness := codeSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	harness.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := harness.ab.NewResponse(httptest.NewRecorder())
+
+	handled, err := harness.confirm.StartConfirmationWeb(w, r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handled {
+		t.Error("it should always be handled")
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if len(harness.session.ClientValues[SessionConfirmSelector]) == 0 {
+		t.Error("the selector should have been put in the session")
+	}
+	if code, _ := harness.renderer.Data[DataConfirmCode].(string); len(code) != 6 {
+		t.Error("the e-mail should contain a 6 digit code:", code)
+	}
+	if time.Until(user.ConfirmTokenExpiry) <= 0 {
+		t.Error("the code should expire in the future")
+	}
+	if p := harness.redirector.Options.RedirectPath; p != "/auth/confirm/code" {
+		t.Error("redirect path was wrong:", p)
+	}
+}
+
+func TestCodePostSuccess(t *testing.T) {
+	t.Parallel()
+
+	harness := codeSetup()
+
+	selector, verifier, token, code, err := authboss.GenerateCode(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{
+		Email: "test@test.com", ConfirmSelector: selector, ConfirmVerifier: verifier,
+		ConfirmTokenExpiry: time.Now().UTC().Add(time.Hour), ConfirmAttempts: 1,
+	}
+	harness.storer.Users["test@test.com"] = user
+	harness.session.ClientValues[SessionConfirmSelector] = token
+	harness.bodyReader.Return = mocks.Values{Code: code}
+
+	r := mocks.Request("POST")
+	w := harness.ab.NewResponse(httptest.NewRecorder())
+
+	r, err = harness.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := harness.confirm.CodePost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if !user.Confirmed {
+		t.Error("the user should have been confirmed")
+	}
+	if len(user.ConfirmSelector) != 0 || len(user.ConfirmVerifier) != 0 {
+		t.Error("the code should be single use")
+	}
+	if _, ok := harness.session.ClientValues[SessionConfirmSelector]; ok {
+		t.Error("the selector should have been removed from the session")
+	}
+	if p := harness.redirector.Options.RedirectPath; p != harness.ab.Paths.ConfirmOK {
+		t.Error("redirect path was wrong:", p)
+	}
+}
+
+func TestCodePostSelectorInValues(t *testing.T) {
+	t.Parallel()
+
+	harness := codeSetup()
+
+	selector, verifier, token, code, err := authboss.GenerateCode(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{
+		Email: "test@test.com", ConfirmSelector: selector, ConfirmVerifier: verifier,
+		ConfirmTokenExpiry: time.Now().UTC().Add(time.Hour),
+	}
+	harness.storer.Users["test@test.com"] = user
+	harness.bodyReader.Return = mocks.Values{Code: code, Selector: token}
+
+	r := mocks.Request("POST")
+	w := harness.ab.NewResponse(httptest.NewRecorder())
+
+	if err := harness.confirm.CodePost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if !user.Confirmed {
+		t.Error("the user should have been confirmed")
+	}
+}
+
+func TestCodePostFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		Wrong    bool
+		Attempts int
+		Expiry   time.Time
+		Message  string
+	}{
+		{"WrongCode", true, 0, time.Now().UTC().Add(time.Hour), confirmCodeInvalid},
+		{"TooManyAttempts", false, 5, time.Now().UTC().Add(time.Hour), confirmCodeAttempts},
+		{"Expired", false, 0, time.Now().UTC().Add(-time.Hour), confirmExpiredFlash},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			harness := codeSetup()
+
+			selector, verifier, token, code, err := authboss.GenerateCode(6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.Wrong {
+				code = "x" + code
+			}
+
+			user := &mocks.User{
+				Email: "test@test.com", ConfirmSelector: selector, ConfirmVerifier: verifier,
+				ConfirmTokenExpiry: test.Expiry, ConfirmAttempts: test.Attempts,
+			}
+			harness.storer.Users["test@test.com"] = user
+			harness.bodyReader.Return = mocks.Values{Code: code, Selector: token}
+
+			r := mocks.Request("POST")
+			w := harness.ab.NewResponse(httptest.NewRecorder())
+
+			if err := harness.confirm.CodePost(w, r); err != nil {
+				t.Fatal(err)
+			}
+
+			if user.Confirmed {
+				t.Error("the user should not have been confirmed")
+			}
+			if harness.responder.Page != PageConfirmCode {
+				t.Error("page was wrong:", harness.responder.Page)
+			}
+			if msg := harness.responder.Data[authboss.DataErr]; msg != test.Message {
+				t.Error("error message was wrong:", msg)
+			}
+			if test.Wrong && user.ConfirmAttempts != 1 {
+				t.Error("the wrong attempt should have been counted:", user.ConfirmAttempts)
+			}
+		})
+	}
+}
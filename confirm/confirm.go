@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"time"
 
 	"github.com/friendsofgo/errors"
 
@@ -33,6 +34,12 @@ const (
 	// DataConfirmURL is the name of the e-mail template variable
 	// that gives the url to send to the user for confirmation.
 	DataConfirmURL = "url"
+	// DataConfirmGrace is set to true in the HTMLData by Middleware when
+	// the user is unconfirmed but still in the grace period, so that pages
+	// can show a banner asking them to confirm their account.
+	DataConfirmGrace = "confirm_grace"
+
+	confirmExpiredFlash = "Your confirmation link has expired, please ask for a new one to be sent to you."
 
 	confirmTokenSize  = 64
 	confirmTokenSplit = confirmTokenSize / 2
@@ -70,6 +77,22 @@ func (c *Confirm) Init(ab *authboss.Authboss) (err error) {
 	}
 	callbackMethod("/confirm", c.Authboss.Config.Core.ErrorHandler.Wrap(c.Get))
 
+	if c.Authboss.Config.Modules.ConfirmResend {
+		if err = c.Authboss.Config.Core.ViewRenderer.Load(PageConfirmResend); err != nil {
+			return err
+		}
+
+		c.Authboss.Config.Core.Router.Get("/confirm/resend", c.Authboss.Config.Core.ErrorHandler.Wrap(c.ResendGet))
+		c.Authboss.Config.Core.Router.Post("/confirm/resend", c.Authboss.Config.Core.ErrorHandler.Wrap(c.ResendPost))
+		c.Authboss.LimitRoute("/confirm/resend", authboss.RateLimitedRoute{Page: PageConfirmResend})
+	}
+
+	if c.Authboss.Config.Modules.ConfirmWithCode {
+		if err = c.initCode(); err != nil {
+			return err
+		}
+	}
+
 	c.Events.Before(authboss.EventAuth, c.PreventAuth)
 	c.Events.After(authboss.EventRegister, c.StartConfirmationWeb)
 
@@ -93,6 +116,11 @@ func (c *Confirm) PreventAuth(w http.ResponseWriter, r *http.Request, handled bo
 		return false, nil
 	}
 
+	if InGracePeriod(c.Authboss, cuser) {
+		logger.Infof("user %s is not confirmed but is in the grace period, allowing auth", user.GetPID())
+		return false, nil
+	}
+
 	logger.Infof("user %s was not confirmed, preventing auth", user.GetPID())
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
@@ -111,6 +139,27 @@ func (c *Confirm) StartConfirmationWeb(w http.ResponseWriter, r *http.Request, h
 	}
 
 	cuser := authboss.MustBeConfirmable(user)
+	if cuser.GetConfirmed() {
+		// Users can already be confirmed, eg. when they registered with an
+		// invitation that was e-mailed to them
+		return false, nil
+	}
+
+	if c.Authboss.Config.Modules.ConfirmWithCode {
+		token, err := c.StartConfirmationCode(r.Context(), cuser, true)
+		if err != nil {
+			return false, err
+		}
+
+		authboss.PutSession(w, SessionConfirmSelector, token)
+		ro := authboss.RedirectOptions{
+			Code:         http.StatusTemporaryRedirect,
+			RedirectPath: path.Join(c.Authboss.Config.Paths.Mount, "confirm/code"),
+			Success:      "Please verify your account, a code has been e-mailed to you.",
+		}
+		return true, c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+	}
+
 	if err = c.StartConfirmation(r.Context(), cuser, true); err != nil {
 		return false, err
 	}
@@ -125,9 +174,16 @@ func (c *Confirm) StartConfirmationWeb(w http.ResponseWriter, r *http.Request, h
 
 // StartConfirmation begins confirmation on a user by setting them to require
 // confirmation via a created token, and optionally sending them an e-mail.
+// If ConfirmWithCode is set a code is created instead, see
+// StartConfirmationCode.
 func (c *Confirm) StartConfirmation(ctx context.Context, user authboss.ConfirmableUser, sendEmail bool) error {
 	logger := c.Authboss.Logger(ctx)
 
+	if c.Authboss.Config.Modules.ConfirmWithCode {
+		_, err := c.StartConfirmationCode(ctx, user, sendEmail)
+		return err
+	}
+
 	selector, verifier, token, err := GenerateConfirmCreds()
 	if err != nil {
 		return err
@@ -136,6 +192,12 @@ func (c *Confirm) StartConfirmation(ctx context.Context, user authboss.Confirmab
 	user.PutConfirmed(false)
 	user.PutConfirmSelector(selector)
 	user.PutConfirmVerifier(verifier)
+	if eu, ok := user.(authboss.ConfirmExpiringUser); ok {
+		eu.PutConfirmExpiry(time.Now().UTC().Add(c.Authboss.Config.Modules.ConfirmTokenDuration))
+	}
+	if ru, ok := user.(authboss.ConfirmResendableUser); ok {
+		ru.PutConfirmLastSent(time.Now().UTC())
+	}
 
 	logger.Infof("generated new confirm token for user: %s", user.GetPID())
 	if err := c.Authboss.Config.Storage.Server.Save(ctx, user); err != nil {
@@ -228,15 +290,54 @@ func (c *Confirm) Get(w http.ResponseWriter, r *http.Request) error {
 		return c.invalidToken(w, r)
 	}
 
+	eu, isExpiring := user.(authboss.ConfirmExpiringUser)
+	if isExpiring && time.Now().UTC().After(eu.GetConfirmExpiry()) {
+		logger.Infof("user %s confirm token has expired", user.GetPID())
+		ro := authboss.RedirectOptions{
+			Code:         http.StatusTemporaryRedirect,
+			Failure:      confirmExpiredFlash,
+			RedirectPath: c.Authboss.Config.Paths.ConfirmNotOK,
+		}
+		if c.Authboss.Config.Modules.ConfirmResend {
+			ro.RedirectPath = path.Join(c.Authboss.Config.Paths.Mount, "confirm/resend")
+		}
+		return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	return c.confirmUser(w, r, user)
+}
+
+// confirmUser confirms a user that presented a valid token or code
+func (c *Confirm) confirmUser(w http.ResponseWriter, r *http.Request, user authboss.ConfirmableUser) error {
+	logger := c.RequestLogger(r)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	handled, err := c.Authboss.Events.FireBefore(authboss.EventConfirm, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
 	user.PutConfirmSelector("")
 	user.PutConfirmVerifier("")
 	user.PutConfirmed(true)
+	if eu, ok := user.(authboss.ConfirmExpiringUser); ok {
+		eu.PutConfirmExpiry(time.Now().UTC()) // Put current time for those DBs that can't handle 0 time
+	}
 
 	logger.Infof("user %s confirmed their account", user.GetPID())
 	if err = c.Authboss.Config.Storage.Server.Save(r.Context(), user); err != nil {
 		return err
 	}
 
+	handled, err = c.Authboss.Events.FireAfter(authboss.EventConfirm, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
 		Success:      "You have successfully confirmed your account.",
@@ -245,6 +346,22 @@ func (c *Confirm) Get(w http.ResponseWriter, r *http.Request) error {
 	return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
 
+// PurgeUnconfirmed deletes users that haven't confirmed their account and
+// were created more than olderThan ago. It returns how many were deleted.
+// It's meant to be called periodically and requires the server storer to be
+// a ConfirmPurgingServerStorer.
+func (c *Confirm) PurgeUnconfirmed(ctx context.Context, olderThan time.Duration) (int, error) {
+	storer := authboss.EnsureCanPurgeUnconfirmed(c.Authboss.Config.Storage.Server)
+
+	deleted, err := storer.PurgeUnconfirmed(ctx, time.Now().UTC().Add(-olderThan))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge unconfirmed users")
+	}
+
+	c.Authboss.Logger(ctx).Infof("purged %d unconfirmed users", deleted)
+	return deleted, nil
+}
+
 func (c *Confirm) mailURL(token string) string {
 	query := url.Values{FormValueConfirm: []string{token}}
 
@@ -269,10 +386,23 @@ func (c *Confirm) invalidToken(w http.ResponseWriter, r *http.Request) error {
 // request and send them to the confirm page, this will load the user if he's
 // not been loaded yet from the session.
 //
+// Unconfirmed users in the grace period (see ConfirmGracePeriod) are let
+// through with DataConfirmGrace set in the HTMLData.
+//
 // Panics if the user was not able to be loaded in order to allow a panic
 // handler to show a nice error page, also panics if it failed to redirect
 // for whatever reason.
 func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return middleware(ab, false)
+}
+
+// StrictMiddleware is like Middleware but ignores the grace period, it
+// should be used on sensitive routes that always require a confirmed user.
+func StrictMiddleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
+	return middleware(ab, true)
+}
+
+func middleware(ab *authboss.Authboss, strict bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user := ab.LoadCurrentUserP(&r)
@@ -283,6 +413,12 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 				return
 			}
 
+			if !strict && InGracePeriod(ab, cu) {
+				authboss.MergeDataInRequest(&r, authboss.HTMLData{DataConfirmGrace: true})
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			logger := ab.RequestLogger(r)
 			logger.Infof("user %s prevented from accessing %s: not confirmed", user.GetPID(), r.URL.Path)
 			ro := authboss.RedirectOptions{
@@ -297,6 +433,23 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 	}
 }
 
+// InGracePeriod checks if an unconfirmed user registered less than
+// ConfirmGracePeriod ago. It's always false for users that don't
+// implement CreatedAtUser.
+func InGracePeriod(ab *authboss.Authboss, user authboss.ConfirmableUser) bool {
+	grace := ab.Config.Modules.ConfirmGracePeriod
+	if grace <= 0 || user.GetConfirmed() {
+		return false
+	}
+
+	cu, ok := user.(authboss.CreatedAtUser)
+	if !ok {
+		return false
+	}
+
+	return time.Now().UTC().Before(cu.GetCreatedAt().Add(grace))
+}
+
 // GenerateConfirmCreds generates pieces needed for user confirm
 // selector: hash of the first half of a 64 byte value
 // (to be stored in the database and used in SELECT query)
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/p000ic/authboss-echo"
 	"github.com/p000ic/authboss-echo/mocks"
@@ -40,6 +41,38 @@ func TestInit(t *testing.T) {
 	}
 }
 
+func TestInitResend(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	viewRenderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.MailRenderer = renderer
+	ab.Config.Core.ViewRenderer = viewRenderer
+	ab.Config.Core.ErrorHandler = errHandler
+	ab.Config.Modules.ConfirmResend = true
+
+	c := &Confirm{}
+	if err := c.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := viewRenderer.HasLoadedViews(PageConfirmResend); err != nil {
+		t.Error(err)
+	}
+
+	if err := router.HasGets("/confirm", "/confirm/resend"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/confirm/resend"); err != nil {
+		t.Error(err)
+	}
+}
+
 type testHarness struct {
 	confirm *Confirm
 	ab      *authboss.Authboss
@@ -181,12 +214,18 @@ func TestGetSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	user := &mocks.User{Email: "test@test.com", Confirmed: false, ConfirmSelector: selector, ConfirmVerifier: verifier}
+	user := &mocks.User{
+		Email: "test@test.com", Confirmed: false, ConfirmSelector: selector, ConfirmVerifier: verifier,
+		ConfirmTokenExpiry: time.Now().UTC().Add(time.Hour),
+	}
 	harness.storer.Users["test@test.com"] = user
 	harness.bodyReader.Return = mocks.Values{
 		Token: token,
 	}
 
+	after := mocks.NewAfterCallback()
+	harness.ab.Events.After(authboss.EventConfirm, after.Fn)
+
 	r := mocks.Request("GET")
 	w := httptest.NewRecorder()
 
@@ -200,6 +239,9 @@ func TestGetSuccess(t *testing.T) {
 	if p := harness.redirector.Options.RedirectPath; p != harness.ab.Paths.ConfirmOK {
 		t.Error("redir path was wrong:", p)
 	}
+	if !after.HasBeenCalled {
+		t.Error("the after confirm event should have fired")
+	}
 
 	if len(user.ConfirmSelector) != 0 {
 		t.Error("the confirm selector should have been erased")
@@ -212,6 +254,126 @@ func TestGetSuccess(t *testing.T) {
 	}
 }
 
+func TestGetHandledBefore(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	selector, verifier, token, err := GenerateConfirmCreds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{
+		Email: "test@test.com", Confirmed: false, ConfirmSelector: selector, ConfirmVerifier: verifier,
+		ConfirmTokenExpiry: time.Now().UTC().Add(time.Hour),
+	}
+	harness.storer.Users["test@test.com"] = user
+	harness.bodyReader.Return = mocks.Values{
+		Token: token,
+	}
+
+	harness.ab.Events.Before(authboss.EventConfirm, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		w.WriteHeader(http.StatusTeapot)
+		return true, nil
+	})
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := harness.confirm.Get(w, r); err != nil {
+		t.Error(err)
+	}
+
+	if w.Code != http.StatusTeapot {
+		t.Error("expected the before handler to respond, got:", w.Code)
+	}
+	if user.Confirmed {
+		t.Error("the user should not have been confirmed")
+	}
+}
+
+func TestGetExpired(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	selector, verifier, token, err := GenerateConfirmCreds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{
+		Email: "test@test.com", Confirmed: false, ConfirmSelector: selector, ConfirmVerifier: verifier,
+		ConfirmTokenExpiry: time.Now().UTC().Add(-time.Hour),
+	}
+	harness.storer.Users["test@test.com"] = user
+	harness.bodyReader.Return = mocks.Values{
+		Token: token,
+	}
+	harness.ab.Config.Modules.ConfirmResend = true
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := harness.confirm.Get(w, r); err != nil {
+		t.Error(err)
+	}
+
+	if p := harness.redirector.Options.RedirectPath; p != "/auth/confirm/resend" {
+		t.Error("redir path was wrong:", p)
+	}
+	if reason := harness.redirector.Options.Failure; reason != confirmExpiredFlash {
+		t.Error("reason for failure was wrong:", reason)
+	}
+	if user.Confirmed {
+		t.Error("the user should not have been confirmed")
+	}
+}
+
+func TestStartConfirmationExpiry(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	harness.storer.Users["test@test.com"] = user
+
+	if err := harness.confirm.StartConfirmation(context.Background(), user, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := time.Until(user.ConfirmTokenExpiry); d <= 23*time.Hour || d > 24*time.Hour {
+		t.Error("confirm expiry was wrong:", user.ConfirmTokenExpiry)
+	}
+}
+
+func TestPurgeUnconfirmed(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	old := time.Now().UTC().Add(-48 * time.Hour)
+	harness.storer.Users["old"] = &mocks.User{Email: "old", Created: old}
+	harness.storer.Users["new"] = &mocks.User{Email: "new", Created: time.Now().UTC()}
+	harness.storer.Users["confirmed"] = &mocks.User{Email: "confirmed", Created: old, Confirmed: true}
+
+	deleted, err := harness.confirm.PurgeUnconfirmed(context.Background(), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 1 {
+		t.Error("wrong number of users deleted:", deleted)
+	}
+	if _, ok := harness.storer.Users["old"]; ok {
+		t.Error("the old unconfirmed user should have been deleted")
+	}
+	if len(harness.storer.Users) != 2 {
+		t.Error("the other users should not have been deleted")
+	}
+}
+
 func TestGetValidationFailure(t *testing.T) {
 	t.Parallel()
 
@@ -357,6 +519,87 @@ func TestMiddlewareDisallow(t *testing.T) {
 	}
 }
 
+func TestMiddlewareGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name    string
+		Strict  bool
+		Created time.Time
+		Allowed bool
+	}{
+		{"InGrace", false, time.Now().UTC().Add(-time.Hour), true},
+		{"GraceOver", false, time.Now().UTC().Add(-48 * time.Hour), false},
+		{"Strict", true, time.Now().UTC().Add(-time.Hour), false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			ab := authboss.New()
+			redirector := &mocks.Redirector{}
+			ab.Config.Paths.ConfirmNotOK = "/confirm/not/ok"
+			ab.Config.Modules.ConfirmGracePeriod = 24 * time.Hour
+			ab.Config.Core.Logger = mocks.Logger{}
+			ab.Config.Core.Redirector = redirector
+
+			mw := Middleware
+			if test.Strict {
+				mw = StrictMiddleware
+			}
+
+			var data authboss.HTMLData
+			called := false
+			server := mw(ab)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				data, _ = r.Context().Value(authboss.CTXKeyData).(authboss.HTMLData)
+			}))
+
+			user := &mocks.User{Created: test.Created}
+
+			r := mocks.Request("GET")
+			r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+			w := httptest.NewRecorder()
+
+			server.ServeHTTP(w, r)
+
+			if called != test.Allowed {
+				t.Error("allowed through should be:", test.Allowed)
+			}
+			if test.Allowed && data[DataConfirmGrace] != true {
+				t.Error("the grace banner flag should have been set")
+			}
+			if !test.Allowed && redirector.Options.RedirectPath != "/confirm/not/ok" {
+				t.Error("redirect path wrong:", redirector.Options.RedirectPath)
+			}
+		})
+	}
+}
+
+func TestPreventAuthGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Modules.ConfirmGracePeriod = 24 * time.Hour
+
+	user := &mocks.User{Created: time.Now().UTC().Add(-time.Hour)}
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := httptest.NewRecorder()
+
+	handled, err := harness.confirm.PreventAuth(w, r, false)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if handled {
+		t.Error("it should not have been handled during the grace period")
+	}
+}
+
 func TestMailURL(t *testing.T) {
 	t.Parallel()
 
@@ -427,3 +670,28 @@ func TestGenerateRecoverCreds(t *testing.T) {
 		t.Error("expected verifier to match")
 	}
 }
+
+func TestStartConfirmationWebConfirmed(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	user := &mocks.User{Email: "test@test.com", Confirmed: true}
+	harness.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := httptest.NewRecorder()
+
+	handled, err := harness.confirm.StartConfirmationWeb(w, r, false)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if handled {
+		t.Error("confirmed users should not be handled")
+	}
+	if len(harness.mailer.Email.To) != 0 {
+		t.Error("should not have sent an e-mail")
+	}
+}
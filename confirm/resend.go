@@ -0,0 +1,126 @@
+package confirm
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+)
+
+const (
+	// PageConfirmResend is the page for asking for a new confirm e-mail
+	PageConfirmResend = "confirm_resend"
+
+	confirmResendSuccessFlash = "If your account needs to be confirmed an e-mail has been sent to you."
+)
+
+// ResendValuer provides the PID of the user asking for a new
+// confirmation e-mail
+type ResendValuer interface {
+	authboss.Validator
+
+	GetPID() string
+}
+
+// MustHaveResendValues upgrades a validatable set of values
+// to ones specific to a user asking for a new confirmation e-mail.
+func MustHaveResendValues(v authboss.Validator) ResendValuer {
+	if u, ok := v.(ResendValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to ResendValuer: %T", v))
+}
+
+// ResendGet renders a form for a user to ask for a new confirmation e-mail.
+func (c *Confirm) ResendGet(w http.ResponseWriter, r *http.Request) error {
+	return c.Authboss.Config.Core.Responder.Respond(w, r, http.StatusOK, PageConfirmResend, nil)
+}
+
+// ResendPost sends a new confirmation e-mail to the user if they're not
+// confirmed and, when they're a ConfirmResendableUser, haven't been sent
+// one within ConfirmResendCooldown. The response is the same whether or
+// not the user exists or is confirmed.
+func (c *Confirm) ResendPost(w http.ResponseWriter, r *http.Request) error {
+	logger := c.RequestLogger(r)
+
+	validatable, err := c.Authboss.Core.BodyReader.Read(PageConfirmResend, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("confirm resend validation failed")
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return c.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageConfirmResend, data)
+	}
+
+	values := MustHaveResendValues(validatable)
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: c.Authboss.Config.Paths.ConfirmNotOK,
+		Success:      confirmResendSuccessFlash,
+	}
+	if c.Authboss.Config.Modules.ConfirmWithCode {
+		ro.RedirectPath = path.Join(c.Authboss.Config.Paths.Mount, "confirm/code")
+	}
+
+	user, err := c.Authboss.Storage.Server.Load(r.Context(), values.GetPID())
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("user %s asked for a new confirm e-mail, user does not exist, faking successful response", values.GetPID())
+		return c.fakeResend(w, r, ro)
+	} else if err != nil {
+		return err
+	}
+
+	cu := authboss.MustBeConfirmable(user)
+	if cu.GetConfirmed() {
+		logger.Infof("user %s asked for a new confirm e-mail, user is confirmed, faking successful response", cu.GetPID())
+		return c.fakeResend(w, r, ro)
+	}
+
+	if ru, ok := cu.(authboss.ConfirmResendableUser); ok {
+		if next := ru.GetConfirmLastSent().Add(c.Authboss.Config.Modules.ConfirmResendCooldown); time.Now().UTC().Before(next) {
+			logger.Infof("user %s asked for a new confirm e-mail before the cooldown ended, faking successful response", cu.GetPID())
+			return c.fakeResend(w, r, ro)
+		}
+	}
+
+	if c.Authboss.Config.Modules.ConfirmWithCode {
+		token, err := c.StartConfirmationCode(r.Context(), cu, true)
+		if err != nil {
+			return err
+		}
+
+		authboss.PutSession(w, SessionConfirmSelector, token)
+		return c.Authboss.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	if err = c.StartConfirmation(r.Context(), cu, true); err != nil {
+		return err
+	}
+
+	return c.Authboss.Core.Redirector.Redirect(w, r, ro)
+}
+
+// fakeResend responds as if an e-mail was sent. When confirming with codes
+// the session's selector is written again, or one that matches nothing if
+// there isn't one, so the response looks the same.
+func (c *Confirm) fakeResend(w http.ResponseWriter, r *http.Request, ro authboss.RedirectOptions) error {
+	if c.Authboss.Config.Modules.ConfirmWithCode {
+		token, ok := authboss.GetSession(r, SessionConfirmSelector)
+		if !ok {
+			var err error
+			if _, _, token, _, err = authboss.GenerateCode(c.Authboss.Config.Modules.ConfirmCodeLength); err != nil {
+				return err
+			}
+		}
+
+		authboss.PutSession(w, SessionConfirmSelector, token)
+	}
+
+	return c.Authboss.Core.Redirector.Redirect(w, r, ro)
+}
@@ -0,0 +1,158 @@
+package confirm
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/mocks"
+)
+
+func TestResendPostSuccess(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	user := &mocks.User{Email: "test@test.com", ConfirmLastSent: time.Now().UTC().Add(-time.Hour)}
+	harness.storer.Users["test@test.com"] = user
+	harness.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := harness.confirm.ResendPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(user.ConfirmSelector) == 0 || len(user.ConfirmVerifier) == 0 {
+		t.Error("new confirm creds should have been stored")
+	}
+	if time.Since(user.ConfirmLastSent) > time.Minute {
+		t.Error("the time the e-mail was sent should have been stored")
+	}
+	if to := harness.mailer.Email.To; len(to) != 1 || to[0] != "test@test.com" {
+		t.Error("e-mail was not sent to the user:", to)
+	}
+
+	if p := harness.redirector.Options.RedirectPath; p != "/confirm/not/ok" {
+		t.Error("redir path was wrong:", p)
+	}
+	if s := harness.redirector.Options.Success; s != confirmResendSuccessFlash {
+		t.Error("success message was wrong:", s)
+	}
+}
+
+func TestResendPostFakeSuccess(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name string
+		User *mocks.User
+	}{
+		{"NotFound", nil},
+		{"Confirmed", &mocks.User{Email: "test@test.com", Confirmed: true}},
+		{"Cooldown", &mocks.User{Email: "test@test.com", ConfirmLastSent: time.Now().UTC().Add(-time.Minute)}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			harness := testSetup()
+			if test.User != nil {
+				harness.storer.Users["test@test.com"] = test.User
+			}
+			harness.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+
+			r := mocks.Request("POST")
+			w := httptest.NewRecorder()
+
+			if err := harness.confirm.ResendPost(w, r); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.User != nil && len(test.User.ConfirmSelector) != 0 {
+				t.Error("confirm creds should not have been stored")
+			}
+			if len(harness.mailer.Email.To) != 0 {
+				t.Error("no e-mail should have been sent")
+			}
+			if s := harness.redirector.Options.Success; s != confirmResendSuccessFlash {
+				t.Error("response should look successful:", s)
+			}
+		})
+	}
+}
+
+func TestResendPostValidationFailure(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.bodyReader.Return = mocks.Values{Errors: []error{errors.New("fail")}}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := harness.confirm.ResendPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if harness.responder.Page != PageConfirmResend {
+		t.Error("page was wrong:", harness.responder.Page)
+	}
+	if _, ok := harness.responder.Data[authboss.DataValidation]; !ok {
+		t.Error("expected validation errors")
+	}
+}
+
+// confirmOnlyUser hides everything but the ConfirmableUser methods
+type confirmOnlyUser struct {
+	authboss.ConfirmableUser
+}
+
+// confirmOnlyStorer loads users as confirmOnlyUsers
+type confirmOnlyStorer struct {
+	*mocks.ServerStorer
+}
+
+func (c confirmOnlyStorer) Load(ctx context.Context, key string) (authboss.User, error) {
+	user, err := c.ServerStorer.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return confirmOnlyUser{user.(*mocks.User)}, nil
+}
+
+func (c confirmOnlyStorer) Save(ctx context.Context, user authboss.User) error {
+	return c.ServerStorer.Save(ctx, user.(confirmOnlyUser).ConfirmableUser.(*mocks.User))
+}
+
+func TestResendPostNoCooldown(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Storage.Server = confirmOnlyStorer{harness.storer}
+
+	// Sent a moment ago, but without ConfirmResendableUser there's no cooldown
+	user := &mocks.User{Email: "test@test.com", ConfirmLastSent: time.Now().UTC()}
+	harness.storer.Users["test@test.com"] = user
+	harness.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := harness.confirm.ResendPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(user.ConfirmSelector) == 0 {
+		t.Error("new confirm creds should have been stored")
+	}
+	if to := harness.mailer.Email.To; len(to) != 1 || to[0] != "test@test.com" {
+		t.Error("e-mail was not sent to the user:", to)
+	}
+}
@@ -0,0 +1,14 @@
+package authboss
+
+// Rule for accepting the terms of service, shared by the consent module and
+// the default body reader so that both validate it the same way
+const (
+	// FormValueAcceptTerms is the field the consent and register pages post
+	// "true" in when the user accepts the terms of service
+	FormValueAcceptTerms = "accept_terms"
+	// AcceptTermsPattern is what FormValueAcceptTerms must match
+	AcceptTermsPattern = `^true$`
+	// AcceptTermsMatchError is the error when FormValueAcceptTerms doesn't
+	// match AcceptTermsPattern
+	AcceptTermsMatchError = "Must be accepted"
+)
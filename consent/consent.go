@@ -0,0 +1,255 @@
+// Package consent makes users accept the terms of service when they
+// register, and again whenever the version of the terms changes.
+package consent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/defaults"
+)
+
+// Constants for templates etc.
+const (
+	// PageConsent is the page users with an outdated version of the terms
+	// are sent to during login to accept the current one
+	PageConsent = "consent"
+
+	// DataConsentVersion is the version of the terms to accept
+	DataConsentVersion = "consent_version"
+
+	// SessionConsentPendingPID holds the user that is logging in until they
+	// accept the terms
+	SessionConsentPendingPID = "consent_pending"
+	// SessionConsentRemember is set when the pending login asked to be
+	// remembered
+	SessionConsentRemember = "consent_remember"
+
+	pageRegister = "register"
+
+	consentRequiredFlash = "Our terms of service have changed, please accept them to continue."
+	consentExpiredFlash  = "Your login has expired, please log in again."
+)
+
+func init() {
+	authboss.RegisterModule("consent", &Consent{})
+}
+
+// Valuer provides whether the terms of service were accepted, both on the
+// consent page and the register page
+type Valuer interface {
+	// Intentionally omitting validator
+
+	GetAccepted() bool
+}
+
+// Consent module
+type Consent struct {
+	*authboss.Authboss
+}
+
+// Init module
+func (c *Consent) Init(ab *authboss.Authboss) error {
+	c.Authboss = ab
+
+	if len(ab.Config.Modules.ConsentVersion) == 0 {
+		return fmt.Errorf("consent: ConsentVersion must be set")
+	}
+
+	if err := ab.Config.Core.ViewRenderer.Load(PageConsent); err != nil {
+		return err
+	}
+
+	// Registering requires accepting the terms, this can only be added to
+	// the default body reader, others must validate it themselves.
+	if br, ok := ab.Config.Core.BodyReader.(*defaults.HTTPBodyReader); ok {
+		addRegisterFields(br)
+	}
+
+	ab.Config.Core.Router.Get("/consent", ab.Config.Core.ErrorHandler.Wrap(c.Get))
+	ab.Config.Core.Router.Post("/consent", ab.Config.Core.ErrorHandler.Wrap(c.Post))
+
+	// Other modules that prevent logging in (like confirm and lock) go
+	// first so that users aren't asked to accept the terms for nothing.
+	// Logging in with oauth2 needs the terms accepted too, new oauth2 users
+	// have never accepted them so they accept them here before their
+	// first login.
+	ab.Events.BeforePriority(authboss.EventAuth, authboss.PriorityLow, c.BeforeAuth)
+	ab.Events.BeforePriority(authboss.EventOAuth2, authboss.PriorityLow, c.BeforeAuth)
+	ab.Events.AfterPriority(authboss.EventRegister, authboss.PriorityHigh, c.AfterRegister)
+
+	return nil
+}
+
+// addRegisterFields requires accepting the terms to register. The field
+// is not whitelisted since it's read into the values on its own, and
+// whitelisted fields are stored on the user as arbitrary data.
+func addRegisterFields(br *defaults.HTTPBodyReader) {
+	if br.Rulesets == nil {
+		br.Rulesets = make(map[string][]defaults.Rules)
+	}
+
+	br.Rulesets[pageRegister] = append(br.Rulesets[pageRegister], defaults.Rules{
+		FieldName: authboss.FormValueAcceptTerms, Required: true,
+		MatchError: authboss.AcceptTermsMatchError,
+		MustMatch:  regexp.MustCompile(authboss.AcceptTermsPattern),
+	})
+}
+
+// BeforeAuth stops users that haven't accepted the current version of the
+// terms from logging in, with a password or oauth2, and sends them to the
+// consent page instead.
+func (c *Consent) BeforeAuth(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	if handled {
+		return false, nil
+	}
+
+	logger := c.Authboss.RequestLogger(r)
+
+	user, err := c.Authboss.CurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+
+	cuser := authboss.MustBeConsent(user)
+	if cuser.GetConsentVersion() == c.Authboss.Config.Modules.ConsentVersion {
+		return false, nil
+	}
+
+	logger.Infof("user %s has not accepted terms version %s, preventing auth", user.GetPID(), c.Authboss.Config.Modules.ConsentVersion)
+	authboss.PutSession(w, SessionConsentPendingPID, user.GetPID())
+	if rm, ok := r.Context().Value(authboss.CTXKeyValues).(authboss.RememberValuer); ok && rm.GetShouldRemember() {
+		authboss.PutSession(w, SessionConsentRemember, "true")
+	} else {
+		authboss.DelSession(w, SessionConsentRemember)
+	}
+
+	query := c.Authboss.Config.Paths.RedirectPolicy.Query(r)
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: path.Join(c.Authboss.Config.Paths.Mount, "consent") + query,
+		Failure:      consentRequiredFlash,
+	}
+	return true, c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// AfterRegister records the version of the terms users accepted when they
+// registered.
+func (c *Consent) AfterRegister(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+	values, ok := r.Context().Value(authboss.CTXKeyValues).(Valuer)
+	if !ok || !values.GetAccepted() {
+		return false, nil
+	}
+
+	user, err := c.Authboss.CurrentUser(r)
+	if err != nil {
+		return false, err
+	}
+
+	return false, c.accept(r.Context(), authboss.MustBeConsent(user))
+}
+
+// Get renders the terms to accept
+func (c *Consent) Get(w http.ResponseWriter, r *http.Request) error {
+	data := authboss.HTMLData{DataConsentVersion: c.Authboss.Config.Modules.ConsentVersion}
+	return c.Authboss.Config.Core.Responder.Respond(w, r, http.StatusOK, PageConsent, data)
+}
+
+// Post records that the user logging in accepted the current version of
+// the terms, and finishes logging them in.
+func (c *Consent) Post(w http.ResponseWriter, r *http.Request) error {
+	logger := c.Authboss.RequestLogger(r)
+
+	validatable, err := c.Authboss.Config.Core.BodyReader.Read(PageConsent, r)
+	if err != nil {
+		return err
+	}
+
+	values, ok := validatable.(Valuer)
+	if errs := validatable.Validate(); errs != nil || !ok || !values.GetAccepted() {
+		logger.Info("consent validation failed")
+		if errs == nil {
+			errs = []error{authboss.NewFieldError(authboss.FormValueAcceptTerms, fmt.Errorf("the terms of service must be accepted"))}
+		}
+		data := authboss.HTMLData{
+			DataConsentVersion:      c.Authboss.Config.Modules.ConsentVersion,
+			authboss.DataValidation: authboss.ErrorMap(errs),
+		}
+		return c.Authboss.Config.Core.Responder.Respond(w, r, http.StatusOK, PageConsent, data)
+	}
+
+	pid, ok := authboss.GetSession(r, SessionConsentPendingPID)
+	if !ok || len(pid) == 0 {
+		logger.Info("consent posted without a pending login")
+		ro := authboss.RedirectOptions{
+			Code:         http.StatusTemporaryRedirect,
+			RedirectPath: path.Join(c.Authboss.Config.Paths.Mount, "login"),
+			Failure:      consentExpiredFlash,
+		}
+		return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	user, err := c.Authboss.Config.Storage.Server.Load(r.Context(), pid)
+	if err != nil {
+		return err
+	}
+
+	if err = c.accept(r.Context(), authboss.MustBeConsent(user)); err != nil {
+		return err
+	}
+	logger.Infof("user %s accepted terms version %s", pid, c.Authboss.Config.Modules.ConsentVersion)
+
+	remember, _ := authboss.GetSession(r, SessionConsentRemember)
+	authboss.DelSession(w, SessionConsentPendingPID)
+	authboss.DelSession(w, SessionConsentRemember)
+
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	if remember == "true" {
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, rememberValues{}))
+	}
+
+	// Logging in may still need a second factor
+	handled, err := c.Authboss.Events.FireBefore(authboss.EventAuthHijack, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	logger.Infof("user %s logged in", pid)
+	authboss.PutSession(w, authboss.SessionKey, pid)
+	authboss.PutSessionSecurityStamp(w, user)
+	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+
+	handled, err = c.Authboss.Events.FireAfter(authboss.EventAuth, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:             http.StatusTemporaryRedirect,
+		RedirectPath:     c.Authboss.Config.Paths.AuthLoginOK,
+		FollowRedirParam: true,
+	}
+	return c.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+func (c *Consent) accept(ctx context.Context, user authboss.ConsentUser) error {
+	user.PutConsentVersion(c.Authboss.Config.Modules.ConsentVersion)
+	user.PutConsentedAt(time.Now().UTC())
+
+	return c.Authboss.Config.Storage.Server.Save(ctx, user)
+}
+
+// rememberValues tells the remember module to remember a login that was
+// asked to be remembered before it was interrupted
+type rememberValues struct{}
+
+func (rememberValues) GetShouldRemember() bool { return true }
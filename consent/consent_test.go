@@ -0,0 +1,383 @@
+package consent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/defaults"
+	"github.com/p000ic/authboss-echo/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	bodyReader := defaults.NewHTTPBodyReader(false, false)
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.ErrorHandler = errHandler
+	ab.Config.Core.BodyReader = bodyReader
+
+	c := &Consent{}
+	if err := c.Init(ab); err == nil {
+		t.Error("should fail without a consent version")
+	}
+
+	ab.Config.Modules.ConsentVersion = "v1"
+	if err := c.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageConsent); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/consent"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/consent"); err != nil {
+		t.Error(err)
+	}
+
+	var hasRule bool
+	for _, rule := range bodyReader.Rulesets[pageRegister] {
+		hasRule = hasRule || rule.FieldName == authboss.FormValueAcceptTerms
+	}
+	if !hasRule {
+		t.Error("register should require accepting the terms")
+	}
+
+	for _, field := range bodyReader.Whitelist[pageRegister] {
+		if field == authboss.FormValueAcceptTerms {
+			t.Error("the acceptance field should not be stored on the user")
+		}
+	}
+}
+
+type testHarness struct {
+	consent *Consent
+	ab      *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	redirector *mocks.Redirector
+	responder  *mocks.Responder
+	session    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.redirector = &mocks.Redirector{}
+	harness.responder = &mocks.Responder{}
+	harness.session = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Config.Paths.Mount = "/auth"
+	harness.ab.Config.Paths.AuthLoginOK = "/login/ok"
+	harness.ab.Config.Modules.ConsentVersion = "v2"
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Storage.SessionState = harness.session
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.consent = &Consent{harness.ab}
+
+	return harness
+}
+
+func TestBeforeAuth(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Current", func(t *testing.T) {
+		t.Parallel()
+		h := testSetup()
+
+		user := &mocks.User{Email: "test@test.com", ConsentVersion: "v2"}
+		r := mocks.Request("POST")
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		w := httptest.NewRecorder()
+
+		handled, err := h.consent.BeforeAuth(w, r, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if handled {
+			t.Error("users that accepted the current version should log in")
+		}
+	})
+
+	t.Run("Outdated", func(t *testing.T) {
+		t.Parallel()
+		h := testSetup()
+
+		user := &mocks.User{Email: "test@test.com", ConsentVersion: "v1"}
+		r := httptest.NewRequest("POST", "/auth/login?redir=%2Fhome", nil)
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, mocks.Values{Remember: true}))
+		w := h.ab.NewResponse(httptest.NewRecorder())
+
+		handled, err := h.consent.BeforeAuth(w, r, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteHeader(http.StatusOK)
+
+		if !handled {
+			t.Error("users with an outdated version should be stopped")
+		}
+		if p := h.redirector.Options.RedirectPath; p != "/auth/consent?redir=%2Fhome" {
+			t.Error("redirect path was wrong:", p)
+		}
+		if pid := h.session.ClientValues[SessionConsentPendingPID]; pid != "test@test.com" {
+			t.Error("pending pid was wrong:", pid)
+		}
+		if rm := h.session.ClientValues[SessionConsentRemember]; rm != "true" {
+			t.Error("remember should have been kept for later")
+		}
+	})
+
+	t.Run("Handled", func(t *testing.T) {
+		t.Parallel()
+		h := testSetup()
+
+		user := &mocks.User{Email: "test@test.com"}
+		r := mocks.Request("POST")
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		w := httptest.NewRecorder()
+
+		handled, err := h.consent.BeforeAuth(w, r, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if handled {
+			t.Error("should leave requests another handler took over alone")
+		}
+	})
+}
+
+func TestOAuth2(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Core.Router = &mocks.Router{}
+	h.ab.Config.Core.ViewRenderer = &mocks.Renderer{}
+	h.ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	if err := h.consent.Init(h.ab); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new oauth2 user has never accepted the terms
+	user := &mocks.User{Email: "test@test.com", OAuth2Provider: "google", OAuth2UID: "uid"}
+	h.storer.Users["test@test.com"] = user
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+
+	handled, err := h.ab.Events.FireBefore(authboss.EventOAuth2, w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if !handled {
+		t.Fatal("oauth2 logins should need the terms accepted")
+	}
+	if pid := h.session.ClientValues[SessionConsentPendingPID]; pid != "test@test.com" {
+		t.Error("pending pid was wrong:", pid)
+	}
+
+	h.bodyReader.Return = mocks.Values{Accepted: true}
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	r, err = h.ab.LoadClientState(w, mocks.Request("POST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.consent.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if user.ConsentVersion != "v2" || user.ConsentedAt.IsZero() {
+		t.Error("consent was not recorded:", user.ConsentVersion, user.ConsentedAt)
+	}
+	if pid := h.session.ClientValues[authboss.SessionKey]; pid != "test@test.com" {
+		t.Error("user should be logged in:", pid)
+	}
+}
+
+func TestAfterRegister(t *testing.T) {
+	t.Parallel()
+
+	for _, accepted := range []bool{true, false} {
+		h := testSetup()
+
+		user := &mocks.User{Email: "test@test.com"}
+		h.storer.Users["test@test.com"] = user
+
+		r := mocks.Request("POST")
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, mocks.Values{Accepted: accepted}))
+		w := httptest.NewRecorder()
+
+		if handled, err := h.consent.AfterRegister(w, r, false); err != nil {
+			t.Fatal(err)
+		} else if handled {
+			t.Error("should never be handled")
+		}
+
+		if recorded := user.ConsentVersion == "v2"; recorded != accepted {
+			t.Errorf("accepted: %t, version recorded: %t", accepted, recorded)
+		}
+		if recorded := !user.ConsentedAt.IsZero(); recorded != accepted {
+			t.Errorf("accepted: %t, time recorded: %t", accepted, recorded)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	if err := h.consent.Get(httptest.NewRecorder(), mocks.Request("GET")); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageConsent {
+		t.Error("page was wrong:", h.responder.Page)
+	}
+	if v := h.responder.Data[DataConsentVersion]; v != "v2" {
+		t.Error("version was wrong:", v)
+	}
+}
+
+func postSetup(t *testing.T, values mocks.Values) (*testHarness, *mocks.User) {
+	t.Helper()
+
+	h := testSetup()
+
+	user := &mocks.User{Email: "test@test.com", ConsentVersion: "v1"}
+	h.storer.Users["test@test.com"] = user
+	h.session.ClientValues[SessionConsentPendingPID] = "test@test.com"
+	h.bodyReader.Return = values
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r, err := h.ab.LoadClientState(w, mocks.Request("POST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.consent.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	return h, user
+}
+
+func TestPostSuccess(t *testing.T) {
+	t.Parallel()
+
+	h, user := postSetup(t, mocks.Values{Accepted: true})
+
+	if user.ConsentVersion != "v2" || user.ConsentedAt.IsZero() {
+		t.Error("consent was not recorded:", user.ConsentVersion, user.ConsentedAt)
+	}
+	if pid := h.session.ClientValues[authboss.SessionKey]; pid != "test@test.com" {
+		t.Error("user should be logged in:", pid)
+	}
+	if _, ok := h.session.ClientValues[SessionConsentPendingPID]; ok {
+		t.Error("pending pid should have been removed")
+	}
+	if p := h.redirector.Options.RedirectPath; p != "/login/ok" {
+		t.Error("redirect path was wrong:", p)
+	}
+}
+
+func TestPostHijacked(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Events.Before(authboss.EventAuthHijack, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		w.WriteHeader(http.StatusTeapot)
+		return true, nil
+	})
+
+	user := &mocks.User{Email: "test@test.com", ConsentVersion: "v1"}
+	h.storer.Users["test@test.com"] = user
+	h.session.ClientValues[SessionConsentPendingPID] = "test@test.com"
+	h.bodyReader.Return = mocks.Values{Accepted: true}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r, err := h.ab.LoadClientState(w, mocks.Request("POST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.consent.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if user.ConsentVersion != "v2" {
+		t.Error("consent should have been recorded")
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("a second factor should be required before logging in")
+	}
+}
+
+func TestPostNotAccepted(t *testing.T) {
+	t.Parallel()
+
+	h, user := postSetup(t, mocks.Values{Accepted: false})
+
+	if h.responder.Page != PageConsent {
+		t.Error("page was wrong:", h.responder.Page)
+	}
+	if user.ConsentVersion != "v1" {
+		t.Error("consent should not have been recorded")
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("user should not be logged in")
+	}
+
+	h, _ = postSetup(t, mocks.Values{Accepted: true, Errors: []error{errors.New("fail")}})
+	if _, ok := h.responder.Data[authboss.DataValidation]; !ok {
+		t.Error("validation errors should have been rendered")
+	}
+}
+
+func TestPostNoPendingLogin(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.bodyReader.Return = mocks.Values{Accepted: true}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	r, err := h.ab.LoadClientState(w, mocks.Request("POST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.consent.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := h.redirector.Options.RedirectPath; p != "/auth/login" {
+		t.Error("redirect path was wrong:", p)
+	}
+}
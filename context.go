@@ -25,6 +25,10 @@ const (
 	// user information currently is remember so only auth/oauth2 are currently
 	// going to use this.
 	CTXKeyValues contextKey = "values"
+
+	// CTXKeyOAuth2Nonce holds the nonce sent with an oauth2 login for
+	// FindUserDetails to check the ID token against.
+	CTXKeyOAuth2Nonce contextKey = "oauth2_nonce"
 )
 
 func (c contextKey) String() string {
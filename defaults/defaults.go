@@ -22,7 +22,9 @@ func SetCore(config *authboss.Config, readJSON, useUsername bool) {
 	config.Core.Router = NewRouter()
 	config.Core.ErrorHandler = NewErrorHandler(logger)
 	config.Core.Responder = NewResponder(config.Core.ViewRenderer)
-	config.Core.Redirector = NewRedirector(config.Core.ViewRenderer, authboss.FormValueRedirect)
+	redirector := NewRedirector(config.Core.ViewRenderer, authboss.FormValueRedirect)
+	redirector.Policy = &config.Paths.RedirectPolicy
+	config.Core.Redirector = redirector
 	config.Core.BodyReader = NewHTTPBodyReader(readJSON, useUsername)
 	config.Core.Mailer = NewLogMailer(os.Stdout)
 	config.Core.Logger = logger
@@ -26,6 +26,8 @@ func TestSetCore(t *testing.T) {
 	}
 	if config.Core.Redirector == nil {
 		t.Error("redirector should be set")
+	} else if redir := config.Core.Redirector.(*Redirector); redir.Policy != &config.Paths.RedirectPolicy {
+		t.Error("redirector should use the configured redirect policy")
 	}
 	if config.Core.BodyReader == nil {
 		t.Error("bodyreader should be set")
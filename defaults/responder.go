@@ -55,9 +55,15 @@ type Redirector struct {
 	// CoerceRedirectTo200 forces http.StatusTemporaryRedirect and
 	// http.StatusPermanentRedirect to http.StatusOK
 	CorceRedirectTo200 bool
+
+	// Policy decides which redirections from the form value are followed,
+	// when it's nil only paths on this site are. SetCore points it at
+	// Config.Paths.RedirectPolicy.
+	Policy *authboss.RedirectPolicy
 }
 
-// NewRedirector constructor
+// NewRedirector constructor, set Policy to &Config.Paths.RedirectPolicy
+// so that the redirector follows the same policy as the modules
 func NewRedirector(renderer authboss.Renderer, formValueName string) *Redirector {
 	return &Redirector{FormValueName: formValueName, Renderer: renderer}
 }
@@ -76,12 +82,7 @@ func (r *Redirector) Redirect(w http.ResponseWriter, req *http.Request, ro authb
 
 func (r *Redirector) redirectAPI(w http.ResponseWriter, req *http.Request, ro authboss.RedirectOptions) error {
 	path := ro.RedirectPath
-	redir := req.FormValue(r.FormValueName)
-	if strings.Contains(redir, "://") {
-		// Guard against Open Redirect: https://cwe.mitre.org/data/definitions/601.html
-		redir = ""
-	}
-	if len(redir) != 0 && ro.FollowRedirParam {
+	if redir := req.FormValue(r.FormValueName); ro.FollowRedirParam && r.allowed(redir) {
 		path = redir
 	}
 
@@ -124,14 +125,18 @@ func (r *Redirector) redirectAPI(w http.ResponseWriter, req *http.Request, ro au
 	return err
 }
 
+// allowed guards against Open Redirect:
+// https://cwe.mitre.org/data/definitions/601.html
+func (r *Redirector) allowed(redir string) bool {
+	if r.Policy == nil {
+		return authboss.RedirectPolicy{}.Allowed(redir)
+	}
+	return r.Policy.Allowed(redir)
+}
+
 func (r *Redirector) redirectNonAPI(w http.ResponseWriter, req *http.Request, ro authboss.RedirectOptions) error {
 	path := ro.RedirectPath
-	redir := req.FormValue(r.FormValueName)
-	if strings.Contains(redir, "://") {
-		// Guard against Open Redirect: https://cwe.mitre.org/data/definitions/601.html
-		redir = ""
-	}
-	if len(redir) != 0 && ro.FollowRedirParam {
+	if redir := req.FormValue(r.FormValueName); ro.FollowRedirParam && r.allowed(redir) {
 		path = redir
 	}
 
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"testing"
 
@@ -250,3 +251,51 @@ func TestResponseRedirectNonAPIFollowRedir(t *testing.T) {
 		t.Error("redirect location was wrong:", got)
 	}
 }
+
+func TestResponseRedirectPolicy(t *testing.T) {
+	t.Parallel()
+
+	renderer := testRenderer{
+		Callback: func(ctx context.Context, name string, data authboss.HTMLData) ([]byte, string, error) {
+			return nil, "", nil
+		},
+	}
+
+	tests := []struct {
+		Policy   *authboss.RedirectPolicy
+		Redir    string
+		Location string
+	}{
+		{nil, "/pow", "/pow"},
+		{nil, "//evil.com", "/redirect"},
+		{nil, `/\evil.com`, "/redirect"},
+		{nil, "https://evil.com", "/redirect"},
+		{&authboss.RedirectPolicy{AllowedHosts: []string{"app.example.com"}}, "https://app.example.com/pow", "https://app.example.com/pow"},
+		{&authboss.RedirectPolicy{AllowedHosts: []string{"app.example.com"}}, "https://evil.com/pow", "/redirect"},
+		{&authboss.RedirectPolicy{AllowedPaths: []string{"/app"}}, "/pow", "/redirect"},
+	}
+
+	for i, test := range tests {
+		redir := NewRedirector(renderer, "redir")
+		redir.Policy = test.Policy
+
+		r := httptest.NewRequest("POST", "/?"+url.Values{"redir": {test.Redir}}.Encode(), nil)
+		w := httptest.NewRecorder()
+
+		ab := authboss.New()
+		ab.Config.Storage.SessionState = mocks.NewClientRW()
+		ab.Config.Storage.CookieState = mocks.NewClientRW()
+		aw := ab.NewResponse(w)
+
+		ro := authboss.RedirectOptions{
+			RedirectPath: "/redirect", FollowRedirParam: true,
+		}
+		if err := redir.Redirect(aw, r, ro); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := w.Header().Get("Location"); got != test.Location {
+			t.Errorf("%d) %q: redirect location was wrong: %s", i, test.Redir, got)
+		}
+	}
+}
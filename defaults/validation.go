@@ -46,6 +46,11 @@ func (h HTTPFormValidator) Validate() []error {
 	return errList
 }
 
+// GetChallengeResponse from the values
+func (h HTTPFormValidator) GetChallengeResponse() string {
+	return h.Values[FormValueChallenge]
+}
+
 // FieldError represents an error that occurs during validation and is always
 // attached to field on a form.
 type FieldError struct {
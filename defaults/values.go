@@ -13,7 +13,7 @@ import (
 
 // FormValue types
 const (
-	FormValueEmail    = "email"
+	FormValueEmail    = authboss.FormValueEmail
 	FormValuePassword = "password"
 	FormValueUsername = "username"
 
@@ -22,14 +22,21 @@ const (
 	FormValueCode         = "code"
 	FormValueRecoveryCode = "recovery_code"
 	FormValuePhoneNumber  = "phone_number"
+	FormValueChallenge    = "challenge_response"
+	FormValueSelector     = "selector"
+	FormValueChannel      = "channel"
+	FormValueInvite       = authboss.FormValueInvite
+	FormValueAcceptTerms  = authboss.FormValueAcceptTerms
 )
 
 // UserValues from the login form
 type UserValues struct {
 	HTTPFormValidator
 
-	PID      string
-	Password string
+	PID           string
+	Password      string
+	InviteToken   string
+	AcceptedTerms bool
 
 	Arbitrary map[string]string
 }
@@ -49,6 +56,17 @@ func (u UserValues) GetValues() map[string]string {
 	return u.Arbitrary
 }
 
+// GetInviteToken from the values
+func (u UserValues) GetInviteToken() string {
+	return u.InviteToken
+}
+
+// GetAccepted checks the form values for whether the terms of service
+// were accepted, which the consent module requires when registering
+func (u UserValues) GetAccepted() bool {
+	return u.AcceptedTerms
+}
+
 // GetShouldRemember checks the form values for
 func (u UserValues) GetShouldRemember() bool {
 	rm, ok := u.Values[authboss.CookieRemember]
@@ -67,16 +85,55 @@ func (c ConfirmValues) GetToken() string {
 	return c.Token
 }
 
+// ConfirmCodeValues retrieves values on the confirm_code page, and the
+// recover_code page which needs the same ones.
+type ConfirmCodeValues struct {
+	HTTPFormValidator
+
+	Code     string
+	Selector string
+}
+
+// GetCode from the confirm code values
+func (c ConfirmCodeValues) GetCode() string { return c.Code }
+
+// GetSelector from the confirm code values
+func (c ConfirmCodeValues) GetSelector() string { return c.Selector }
+
+// ConsentValues for the consent page
+type ConsentValues struct {
+	HTTPFormValidator
+
+	Accepted bool
+}
+
+// GetAccepted checks if the terms of service were accepted
+func (c ConsentValues) GetAccepted() bool { return c.Accepted }
+
 // RecoverStartValues for recover_start page
 type RecoverStartValues struct {
 	HTTPFormValidator
 
-	PID string
+	PID     string
+	Channel string
 }
 
 // GetPID for recovery
 func (r RecoverStartValues) GetPID() string { return r.PID }
 
+// GetChannel the user chose to recover with
+func (r RecoverStartValues) GetChannel() string { return r.Channel }
+
+// InviteValues for the invite page
+type InviteValues struct {
+	HTTPFormValidator
+
+	Email string
+}
+
+// GetEmail to send the invitation to
+func (i InviteValues) GetEmail() string { return i.Email }
+
 // RecoverMiddleValues for recover_middle page
 type RecoverMiddleValues struct {
 	HTTPFormValidator
@@ -91,8 +148,10 @@ func (r RecoverMiddleValues) GetToken() string { return r.Token }
 type RecoverEndValues struct {
 	HTTPFormValidator
 
-	Token       string
-	NewPassword string
+	Token        string
+	NewPassword  string
+	Code         string
+	RecoveryCode string
 }
 
 // GetToken for recovery
@@ -101,6 +160,12 @@ func (r RecoverEndValues) GetToken() string { return r.Token }
 // GetPassword for recovery
 func (r RecoverEndValues) GetPassword() string { return r.NewPassword }
 
+// GetCode for recovery with two factor authentication
+func (r RecoverEndValues) GetCode() string { return r.Code }
+
+// GetRecoveryCode for recovery with two factor authentication
+func (r RecoverEndValues) GetRecoveryCode() string { return r.RecoveryCode }
+
 // TwoFA for totp2fa_validate page
 type TwoFA struct {
 	HTTPFormValidator
@@ -178,6 +243,12 @@ func NewHTTPBodyReader(readJSON, useUsernameNotEmail bool) *HTTPBodyReader {
 		}
 	}
 
+	emailRule := Rules{
+		FieldName: FormValueEmail, Required: true,
+		MatchError: "Must be a valid e-mail address",
+		MustMatch:  regexp.MustCompile(`.*@.*\.[a-z]+`),
+	}
+
 	passwordRule := Rules{
 		FieldName:  "password",
 		MinLength:  8,
@@ -191,11 +262,22 @@ func NewHTTPBodyReader(readJSON, useUsernameNotEmail bool) *HTTPBodyReader {
 		UseUsername: useUsernameNotEmail,
 		ReadJSON:    readJSON,
 		Rulesets: map[string][]Rules{
-			"login":         {pidRules},
-			"register":      {pidRules, passwordRule},
-			"confirm":       {Rules{FieldName: FormValueConfirm, Required: true}},
-			"recover_start": {pidRules},
-			"recover_end":   {passwordRule},
+			"login":          {pidRules},
+			"register":       {pidRules, passwordRule},
+			"confirm":        {Rules{FieldName: FormValueConfirm, Required: true}},
+			"confirm_resend": {pidRules},
+			"confirm_code":   {Rules{FieldName: FormValueCode, Required: true}},
+			"recover_start":  {pidRules},
+			"recover_code":   {Rules{FieldName: FormValueCode, Required: true}},
+			"recover_end":    {passwordRule},
+			"unlock_start":   {pidRules},
+			"unlock_end":     {Rules{FieldName: FormValueToken, Required: true}},
+			"invite":         {emailRule},
+			"consent": {Rules{
+				FieldName: FormValueAcceptTerms, Required: true,
+				MatchError: authboss.AcceptTermsMatchError,
+				MustMatch:  regexp.MustCompile(authboss.AcceptTermsPattern),
+			}},
 
 			"twofactor_verify_end": {Rules{FieldName: FormValueToken, Required: true}},
 		},
@@ -240,6 +322,12 @@ func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator,
 			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules},
 			Token:             values[FormValueConfirm],
 		}, nil
+	case "confirm_code", "recover_code":
+		return ConfirmCodeValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules},
+			Code:              values[FormValueCode],
+			Selector:          values[FormValueSelector],
+		}, nil
 	case "login":
 		var pid string
 		if h.UseUsername {
@@ -253,7 +341,7 @@ func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator,
 			PID:               pid,
 			Password:          values[FormValuePassword],
 		}, nil
-	case "recover_start":
+	case "recover_start", "unlock_start", "confirm_resend":
 		var pid string
 		if h.UseUsername {
 			pid = values[FormValueUsername]
@@ -264,6 +352,17 @@ func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator,
 		return RecoverStartValues{
 			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
 			PID:               pid,
+			Channel:           values[FormValueChannel],
+		}, nil
+	case "consent":
+		return ConsentValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules},
+			Accepted:          values[FormValueAcceptTerms] == "true",
+		}, nil
+	case "invite":
+		return InviteValues{
+			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules},
+			Email:             values[FormValueEmail],
 		}, nil
 	case "recover_middle":
 		return RecoverMiddleValues{
@@ -275,8 +374,10 @@ func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator,
 			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
 			Token:             values[FormValueToken],
 			NewPassword:       values[FormValuePassword],
+			Code:              values[FormValueCode],
+			RecoveryCode:      values[FormValueRecoveryCode],
 		}, nil
-	case "twofactor_verify_end":
+	case "twofactor_verify_end", "unlock_end":
 		// Reuse ConfirmValues here, it's the same values we need
 		return ConfirmValues{
 			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
@@ -318,6 +419,8 @@ func (h HTTPBodyReader) Read(page string, r *http.Request) (authboss.Validator,
 			HTTPFormValidator: HTTPFormValidator{Values: values, Ruleset: rules, ConfirmFields: confirms},
 			PID:               pid,
 			Password:          values[FormValuePassword],
+			InviteToken:       values[FormValueInvite],
+			AcceptedTerms:     values[FormValueAcceptTerms] == "true",
 			Arbitrary:         arbitrary,
 		}, nil
 	default:
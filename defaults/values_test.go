@@ -104,7 +104,7 @@ func TestHTTPBodyReaderRecoverEnd(t *testing.T) {
 	t.Parallel()
 
 	h := NewHTTPBodyReader(false, false)
-	r := mocks.Request("POST", "token", "token", "password", "password")
+	r := mocks.Request("POST", "token", "token", "password", "password", "code", "123456", "recovery_code", "rec")
 
 	validator, err := h.Read("recover_end", r)
 	if err != nil {
@@ -118,6 +118,14 @@ func TestHTTPBodyReaderRecoverEnd(t *testing.T) {
 	if password := rmv.GetPassword(); password != "password" {
 		t.Error("password was wrong:", password)
 	}
+
+	rev := validator.(RecoverEndValues)
+	if code := rev.GetCode(); code != "123456" {
+		t.Error("code was wrong:", code)
+	}
+	if code := rev.GetRecoveryCode(); code != "rec" {
+		t.Error("recovery code was wrong:", code)
+	}
 }
 
 func TestHTTPBodyReaderRegister(t *testing.T) {
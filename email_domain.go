@@ -0,0 +1,149 @@
+package authboss
+
+import (
+	"bufio"
+	_ "embed"
+	"strings"
+	"sync"
+
+	"github.com/friendsofgo/errors"
+)
+
+const (
+	// FormValueEmail is the field e-mail address errors, such as those
+	// from CheckEmailDomain, are reported on
+	FormValueEmail = "email"
+)
+
+var (
+	// ErrEmailDomainNotAllowed is returned by CheckEmailDomain when
+	// Config.Modules.RegisterAllowedDomains doesn't include the domain
+	ErrEmailDomainNotAllowed = errors.New("e-mail addresses on this domain are not allowed to register")
+	// ErrEmailDomainBlocked is returned by CheckEmailDomain when
+	// Config.Modules.RegisterBlockedDomains includes the domain
+	ErrEmailDomainBlocked = errors.New("e-mail addresses on this domain are blocked from registering")
+	// ErrEmailDisposable is returned by CheckEmailDomain for disposable
+	// e-mail addresses when Config.Modules.RegisterBlockDisposable is set
+	ErrEmailDisposable = errors.New("disposable e-mail addresses are not allowed to register")
+)
+
+//go:embed disposable_domains.txt
+var disposableDomainsFile string
+
+var (
+	disposableDomainsOnce sync.Once
+	disposableDomainsMut  sync.RWMutex
+	disposableDomains     map[string]struct{}
+)
+
+// loadDisposableDomains parses the bundled list the first time it's needed
+func loadDisposableDomains() {
+	disposableDomainsOnce.Do(func() {
+		domains := make(map[string]struct{})
+
+		scanner := bufio.NewScanner(strings.NewReader(disposableDomainsFile))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			domains[strings.ToLower(line)] = struct{}{}
+		}
+
+		disposableDomainsMut.Lock()
+		disposableDomains = domains
+		disposableDomainsMut.Unlock()
+	})
+}
+
+// SetDisposableDomains replaces the bundled list of disposable e-mail
+// providers used by IsDisposableDomain, for example with a list that's
+// kept up to date outside of this package. To add to the list instead
+// use Config.Modules.RegisterDisposableDomains.
+func SetDisposableDomains(domains []string) {
+	loadDisposableDomains()
+
+	replacement := make(map[string]struct{}, len(domains))
+	for _, d := range domains {
+		replacement[strings.ToLower(strings.TrimPrefix(d, "@"))] = struct{}{}
+	}
+
+	disposableDomainsMut.Lock()
+	disposableDomains = replacement
+	disposableDomainsMut.Unlock()
+}
+
+// IsDisposableDomain checks the list of disposable e-mail providers for a
+// domain or any of its parent domains. The list is the bundled one unless
+// it was replaced with SetDisposableDomains.
+func IsDisposableDomain(domain string) bool {
+	loadDisposableDomains()
+
+	disposableDomainsMut.RLock()
+	defer disposableDomainsMut.RUnlock()
+
+	for domain = strings.ToLower(domain); len(domain) != 0; domain = parentDomain(domain) {
+		if _, ok := disposableDomains[domain]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckEmailDomain checks that an e-mail address may be used to register
+// according to Config.Modules.RegisterAllowedDomains,
+// RegisterBlockedDomains, RegisterBlockDisposable and
+// RegisterDisposableDomains. Domains match
+// their subdomains as well. It returns nil if the address is allowed,
+// otherwise an error that can be shown to the user.
+func (a *Authboss) CheckEmailDomain(email string) error {
+	var domain string
+	if i := strings.LastIndexByte(email, '@'); i >= 0 {
+		domain = strings.ToLower(strings.TrimSpace(email[i+1:]))
+	}
+
+	if allowed := a.Config.Modules.RegisterAllowedDomains; len(allowed) != 0 && !matchesDomain(domain, allowed) {
+		return ErrEmailDomainNotAllowed
+	}
+	if len(domain) == 0 {
+		return nil
+	}
+
+	if matchesDomain(domain, a.Config.Modules.RegisterBlockedDomains) {
+		return ErrEmailDomainBlocked
+	}
+	if a.Config.Modules.RegisterBlockDisposable &&
+		(IsDisposableDomain(domain) || matchesDomain(domain, a.Config.Modules.RegisterDisposableDomains)) {
+		return ErrEmailDisposable
+	}
+
+	return nil
+}
+
+// matchesDomain checks if domain is one of the domains, or a subdomain
+// of one of them
+func matchesDomain(domain string, domains []string) bool {
+	if len(domain) == 0 {
+		return false
+	}
+
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "@"))
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parentDomain strips the first label from a domain, or returns empty
+// when there are no more
+func parentDomain(domain string) string {
+	if i := strings.IndexByte(domain, '.'); i >= 0 {
+		return domain[i+1:]
+	}
+
+	return ""
+}
@@ -0,0 +1,82 @@
+package authboss
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestIsDisposableDomain(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bool{
+		"mailinator.com":      true,
+		"MAILINATOR.COM":      true,
+		"eu.mailinator.com":   true,
+		"example.com":         false,
+		"notmailinator.com":   false,
+		"mailinator.com.evil": false,
+	}
+
+	for domain, want := range tests {
+		if got := IsDisposableDomain(domain); got != want {
+			t.Errorf("%s: want: %t, got: %t", domain, want, got)
+		}
+	}
+}
+
+// TestSetDisposableDomains is not parallel since it changes the list the
+// other tests use, it puts the bundled one back when it's done
+func TestSetDisposableDomains(t *testing.T) {
+	defer func() { disposableDomainsOnce = sync.Once{} }()
+
+	SetDisposableDomains([]string{"@Replaced.example"})
+
+	if !IsDisposableDomain("sub.replaced.example") {
+		t.Error("replaced list should be used")
+	}
+	if IsDisposableDomain("mailinator.com") {
+		t.Error("bundled list should have been replaced")
+	}
+}
+
+func TestCheckEmailDomain(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+
+	if err := ab.CheckEmailDomain("test@mailinator.com"); err != nil {
+		t.Error("nothing should be checked by default:", err)
+	}
+
+	ab.Config.Modules.RegisterBlockDisposable = true
+	if err := ab.CheckEmailDomain("test@mailinator.com"); err != ErrEmailDisposable {
+		t.Error("disposable address should have been blocked:", err)
+	}
+
+	ab.Config.Modules.RegisterDisposableDomains = []string{"throwaway.example"}
+	if err := ab.CheckEmailDomain("test@mail.throwaway.example"); err != ErrEmailDisposable {
+		t.Error("added disposable domain should have been blocked:", err)
+	}
+
+	ab.Config.Modules.RegisterBlockedDomains = []string{"blocked.com"}
+	if err := ab.CheckEmailDomain("test@sub.Blocked.com"); err != ErrEmailDomainBlocked {
+		t.Error("blocked subdomain should have been blocked:", err)
+	}
+	if err := ab.CheckEmailDomain("username"); err != nil {
+		t.Error("block lists should not apply without a domain:", err)
+	}
+
+	ab.Config.Modules.RegisterAllowedDomains = []string{"corp.com", "@partner.com"}
+	if err := ab.CheckEmailDomain("test@corp.com"); err != nil {
+		t.Error("allowed domain should be allowed:", err)
+	}
+	if err := ab.CheckEmailDomain("test@eng.partner.com"); err != nil {
+		t.Error("allowed subdomain should be allowed:", err)
+	}
+	if err := ab.CheckEmailDomain("test@notcorp.com"); err != ErrEmailDomainNotAllowed {
+		t.Error("other domains should not be allowed:", err)
+	}
+	if err := ab.CheckEmailDomain("username"); err != ErrEmailDomainNotAllowed {
+		t.Error("addresses without a domain should not be allowed:", err)
+	}
+}
@@ -1,7 +1,10 @@
 package authboss
 
 import (
+	"context"
 	"net/http"
+	"sync"
+	"time"
 )
 
 //go:generate stringer -output stringers.go -type "Event"
@@ -27,11 +30,35 @@ const (
 	EventRecoverEnd
 	EventGetUser
 	EventGetUserSession
-	// Deprecated: EventPasswordReset is used nowhere
+	// Deprecated: EventPasswordReset is used nowhere, see EventPasswordChange
 	EventPasswordReset
 	EventLogout
 	EventTwoFactorAdded
 	EventTwoFactorRemoved
+	// EventConfirm fires when a user confirms their account. Before handlers
+	// can interrupt the confirmation before it is persisted.
+	EventConfirm
+	// EventLock fires after an account has been locked, either by too many
+	// failed attempts or manually.
+	EventLock
+	// EventUnlock fires after an account has been unlocked.
+	EventUnlock
+	// EventPasswordChange fires after a user's password has been changed
+	// and persisted, by password recovery or UpdatePassword.
+	EventPasswordChange
+	// EventRegisterFail fires when a registration is rejected because of
+	// validation errors or because the user already exists. The submitted
+	// values are available under CTXKeyValues.
+	EventRegisterFail
+	// EventRememberTheft fires when a remember me token that has already
+	// been used is presented with a valid series, which means the cookie was
+	// probably stolen. All of the user's remember me tokens have already
+	// been revoked and the user is in the context under CTXKeyUser.
+	EventRememberTheft
+	// EventRecoverThrottled fires when a password recovery e-mail was not
+	// sent because the account or ip address asked for too many. The user
+	// is in the context under CTXKeyUser.
+	EventRecoverThrottled
 )
 
 // EventHandler reacts to events that are fired by Authboss controllers.
@@ -43,32 +70,125 @@ const (
 // Very much a controller level middleware.
 type EventHandler func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error)
 
+// HandlerID identifies a registered EventHandler so that it can later be
+// removed with Events.Off.
+type HandlerID uint64
+
+// Handler priorities. Handlers with a higher priority are called first,
+// handlers with equal priority are called in the order they were registered.
+// Any int may be used, these are simply reference points.
+const (
+	PriorityLow     = -100
+	PriorityDefault = 0
+	PriorityHigh    = 100
+)
+
+type registeredHandler struct {
+	id       HandlerID
+	priority int
+	async    bool
+	fn       EventHandler
+}
+
 // Events is a collection of Events that fire before and after certain methods.
 type Events struct {
-	before map[Event][]EventHandler
-	after  map[Event][]EventHandler
+	mu     sync.RWMutex
+	lastID HandlerID
+	before map[Event][]registeredHandler
+	after  map[Event][]registeredHandler
+
+	pool *asyncPool
 }
 
 // NewEvents creates a new set of before and after Events.
 func NewEvents() *Events {
 	return &Events{
-		before: make(map[Event][]EventHandler),
-		after:  make(map[Event][]EventHandler),
+		before: make(map[Event][]registeredHandler),
+		after:  make(map[Event][]registeredHandler),
 	}
 }
 
 // Before event, call f.
-func (c *Events) Before(e Event, f EventHandler) {
-	events := c.before[e]
-	events = append(events, f)
-	c.before[e] = events
+func (c *Events) Before(e Event, f EventHandler) HandlerID {
+	return c.add(c.before, e, PriorityDefault, false, f)
+}
+
+// BeforePriority is the same as Before but allows control over the order
+// in which the handlers are called, see PriorityDefault.
+func (c *Events) BeforePriority(e Event, priority int, f EventHandler) HandlerID {
+	return c.add(c.before, e, priority, false, f)
 }
 
 // After event, call f.
-func (c *Events) After(e Event, f EventHandler) {
-	events := c.after[e]
-	events = append(events, f)
-	c.after[e] = events
+func (c *Events) After(e Event, f EventHandler) HandlerID {
+	return c.add(c.after, e, PriorityDefault, false, f)
+}
+
+// AfterPriority is the same as After but allows control over the order
+// in which the handlers are called, see PriorityDefault.
+func (c *Events) AfterPriority(e Event, priority int, f EventHandler) HandlerID {
+	return c.add(c.after, e, priority, false, f)
+}
+
+// AfterAsync subscribes f to the event without blocking the request. It's
+// queued onto the worker pool started by StartAsync, and called with a nil
+// http.ResponseWriter and a copy of the request whose context is never
+// cancelled. Its handled return value is ignored, errors and panics are
+// logged.
+//
+// If the worker pool is not running the handler is called synchronously
+// in its place in the chain instead.
+func (c *Events) AfterAsync(e Event, f EventHandler) HandlerID {
+	return c.add(c.after, e, PriorityDefault, true, f)
+}
+
+// Off removes a handler registered with any of the Before or After methods.
+// It returns false if the handler was not found.
+func (c *Events) Off(id HandlerID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, set := range []map[Event][]registeredHandler{c.before, c.after} {
+		for e, handlers := range set {
+			for i, h := range handlers {
+				if h.id != id {
+					continue
+				}
+
+				removed := make([]registeredHandler, 0, len(handlers)-1)
+				removed = append(removed, handlers[:i]...)
+				set[e] = append(removed, handlers[i+1:]...)
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (c *Events) add(set map[Event][]registeredHandler, e Event, priority int, async bool, f EventHandler) HandlerID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastID++
+	h := registeredHandler{id: c.lastID, priority: priority, async: async, fn: f}
+
+	// Copy on write so that a fire in progress keeps its snapshot
+	handlers := set[e]
+	index := len(handlers)
+	for i, existing := range handlers {
+		if existing.priority < priority {
+			index = i
+			break
+		}
+	}
+
+	added := make([]registeredHandler, 0, len(handlers)+1)
+	added = append(added, handlers[:index]...)
+	added = append(added, h)
+	set[e] = append(added, handlers[index:]...)
+
+	return h.id
 }
 
 // FireBefore executes the handlers that were registered to fire before
@@ -81,20 +201,56 @@ func (c *Events) After(e Event, f EventHandler) {
 // to handlers further down the chain (to let them know that w has been used)
 // as well as set w to nil as a precaution.
 func (c *Events) FireBefore(e Event, w http.ResponseWriter, r *http.Request) (bool, error) {
-	return c.call(c.before[e], w, r)
+	return c.call(e, c.handlers(c.before, e), w, r)
 }
 
 // FireAfter event to all the Events with a context. The error can safely be
 // ignored as it is logged.
 func (c *Events) FireAfter(e Event, w http.ResponseWriter, r *http.Request) (bool, error) {
-	return c.call(c.after[e], w, r)
+	return c.call(e, c.handlers(c.after, e), w, r)
+}
+
+// FireAfterContext fires the after handlers for events that happen outside
+// of an http request, like a manual Lock.Unlock or Authboss.UpdatePassword.
+// Handlers are given a request that only carries ctx and a ResponseWriter
+// that discards everything written to it, including client state.
+func (c *Events) FireAfterContext(ctx context.Context, e Event) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		return err
+	}
+
+	w := &ClientStateResponseWriter{ResponseWriter: discardResponseWriter{header: make(http.Header)}}
+	_, err = c.FireAfter(e, w, r)
+	return err
 }
 
-func (c *Events) call(evs []EventHandler, w http.ResponseWriter, r *http.Request) (bool, error) {
+// discardResponseWriter is the ResponseWriter for events fired outside of
+// an http request
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d discardResponseWriter) Header() http.Header         { return d.header }
+func (d discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardResponseWriter) WriteHeader(int)             {}
+
+func (c *Events) handlers(set map[Event][]registeredHandler, e Event) []registeredHandler {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return set[e]
+}
+
+func (c *Events) call(e Event, evs []registeredHandler, w http.ResponseWriter, r *http.Request) (bool, error) {
 	handled := false
 
-	for _, fn := range evs {
-		interrupt, err := fn(w, r, handled)
+	for _, h := range evs {
+		if h.async && c.enqueue(e, h.fn, r) {
+			continue
+		}
+
+		interrupt, err := h.fn(w, r, handled)
 		if err != nil {
 			return false, err
 		}
@@ -105,3 +261,130 @@ func (c *Events) call(evs []EventHandler, w http.ResponseWriter, r *http.Request
 
 	return handled, nil
 }
+
+// StartAsync starts the worker pool that runs handlers registered with
+// AfterAsync. At most workers handlers run at the same time and up to
+// queueSize more may be waiting, beyond that handlers are dropped and the
+// drop is logged. Authboss.Init calls this using the Modules.EventAsync
+// configuration, calling it while the pool is running does nothing.
+func (c *Events) StartAsync(workers, queueSize int, logger Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pool != nil || workers <= 0 {
+		return
+	}
+
+	c.pool = &asyncPool{
+		logger: logger,
+		jobs:   make(chan asyncJob, queueSize),
+	}
+
+	c.pool.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go c.pool.work()
+	}
+}
+
+// Shutdown stops the async worker pool and waits for the handlers that were
+// already queued to finish, or for ctx to be done. Async handlers fired after
+// Shutdown is called are run synchronously.
+func (c *Events) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	pool := c.pool
+	c.pool = nil
+	c.mu.Unlock()
+
+	if pool == nil {
+		return nil
+	}
+
+	close(pool.jobs)
+
+	done := make(chan struct{})
+	go func() {
+		pool.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enqueue hands the handler to the worker pool, returning false if the pool
+// is not running in which case the caller should run it itself.
+func (c *Events) enqueue(e Event, fn EventHandler, r *http.Request) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.pool == nil {
+		return false
+	}
+
+	if r != nil {
+		r = r.WithContext(detachedContext{parent: r.Context()})
+	}
+
+	select {
+	case c.pool.jobs <- asyncJob{event: e, fn: fn, r: r}:
+	default:
+		c.pool.errorf("async event queue is full, dropped a handler for %s", e)
+	}
+
+	return true
+}
+
+type asyncJob struct {
+	event Event
+	fn    EventHandler
+	r     *http.Request
+}
+
+type asyncPool struct {
+	logger Logger
+	jobs   chan asyncJob
+	wg     sync.WaitGroup
+}
+
+func (p *asyncPool) work() {
+	defer p.wg.Done()
+
+	for job := range p.jobs {
+		p.run(job)
+	}
+}
+
+func (p *asyncPool) run(job asyncJob) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			p.errorf("async handler for %s panicked: %v", job.event, rec)
+		}
+	}()
+
+	if _, err := job.fn(nil, job.r, false); err != nil {
+		p.errorf("async handler for %s failed: %+v", job.event, err)
+	}
+}
+
+func (p *asyncPool) errorf(format string, values ...interface{}) {
+	if p.logger == nil {
+		return
+	}
+
+	FmtLogger{p.logger}.Errorf(format, values...)
+}
+
+// detachedContext keeps the values of its parent but is never cancelled,
+// so async handlers can outlive the request that fired them.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
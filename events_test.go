@@ -1,7 +1,11 @@
 package authboss
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/friendsofgo/errors"
@@ -140,6 +144,13 @@ func TestEventString(t *testing.T) {
 		{EventGetUser, "EventGetUser"},
 		{EventGetUserSession, "EventGetUserSession"},
 		{EventPasswordReset, "EventPasswordReset"},
+		{EventConfirm, "EventConfirm"},
+		{EventLock, "EventLock"},
+		{EventUnlock, "EventUnlock"},
+		{EventPasswordChange, "EventPasswordChange"},
+		{EventRegisterFail, "EventRegisterFail"},
+		{EventRememberTheft, "EventRememberTheft"},
+		{EventRecoverThrottled, "EventRecoverThrottled"},
 	}
 
 	for i, test := range tests {
@@ -148,3 +159,175 @@ func TestEventString(t *testing.T) {
 		}
 	}
 }
+
+func TestEventsOff(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	called := false
+
+	id := ab.Events.Before(EventRegister, func(http.ResponseWriter, *http.Request, bool) (bool, error) {
+		called = true
+		return false, nil
+	})
+
+	if !ab.Events.Off(id) {
+		t.Error("expected the handler to be removed")
+	}
+	if ab.Events.Off(id) {
+		t.Error("the handler should not be removed twice")
+	}
+
+	if _, err := ab.Events.FireBefore(EventRegister, nil, nil); err != nil {
+		t.Error(err)
+	}
+	if called {
+		t.Error("removed handler should not have been called")
+	}
+}
+
+func TestEventsPriority(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	var order []string
+
+	record := func(name string) EventHandler {
+		return func(http.ResponseWriter, *http.Request, bool) (bool, error) {
+			order = append(order, name)
+			return false, nil
+		}
+	}
+
+	ab.Events.After(EventAuth, record("default1"))
+	ab.Events.AfterPriority(EventAuth, PriorityLow, record("low"))
+	ab.Events.AfterPriority(EventAuth, PriorityHigh, record("high"))
+	ab.Events.After(EventAuth, record("default2"))
+
+	if _, err := ab.Events.FireAfter(EventAuth, nil, nil); err != nil {
+		t.Error(err)
+	}
+
+	want := []string{"high", "default1", "default2", "low"}
+	if len(order) != len(want) {
+		t.Fatalf("wrong number of calls, want: %v got: %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Errorf("wrong order, want: %v got: %v", want, order)
+			break
+		}
+	}
+}
+
+type asyncTestLogger struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+func (l *asyncTestLogger) Info(string) {}
+func (l *asyncTestLogger) Error(s string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, s)
+}
+
+func TestEventsAsync(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	logger := &asyncTestLogger{}
+	ab.Events.StartAsync(2, 10, logger)
+
+	var calls int32
+	release := make(chan struct{})
+	ab.Events.AfterAsync(EventRegister, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		<-release
+		if w != nil {
+			t.Error("async handlers should not get a response writer")
+		}
+		if r.Context().Value(CTXKeyPID) != "test@test.com" {
+			t.Error("async handlers should keep the request context values")
+		}
+		atomic.AddInt32(&calls, 1)
+		return true, nil
+	})
+	ab.Events.AfterAsync(EventRegister, func(http.ResponseWriter, *http.Request, bool) (bool, error) {
+		panic("boom")
+	})
+	ab.Events.AfterAsync(EventRegister, func(http.ResponseWriter, *http.Request, bool) (bool, error) {
+		return false, errors.New("async failure")
+	})
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), CTXKeyPID, "test@test.com"))
+	r := httptest.NewRequest("POST", "/", nil).WithContext(ctx)
+	handled, err := ab.Events.FireAfter(EventRegister, nil, r)
+	if err != nil {
+		t.Error(err)
+	}
+	if handled {
+		t.Error("async handlers should not be able to handle the request")
+	}
+
+	// The request finishing must not affect the handlers
+	cancel()
+	close(release)
+
+	if err := ab.Events.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Error("async handler should have been called once, got:", n)
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.errors) != 2 {
+		t.Fatal("expected the panic and the error to be logged:", logger.errors)
+	}
+}
+
+func TestEventsAsyncNotRunning(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	called := false
+	ab.Events.AfterAsync(EventRegister, func(http.ResponseWriter, *http.Request, bool) (bool, error) {
+		called = true
+		return false, nil
+	})
+
+	if _, err := ab.Events.FireAfter(EventRegister, nil, nil); err != nil {
+		t.Error(err)
+	}
+	if !called {
+		t.Error("without a worker pool the handler should be called synchronously")
+	}
+}
+
+func TestEventsFireAfterContext(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+
+	var gotUser interface{}
+	ab.Events.After(EventPasswordChange, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		gotUser = r.Context().Value(CTXKeyUser)
+
+		// Handlers written for http requests must not panic
+		PutSession(w, SessionKey, "pid")
+		DelCookie(w, CookieRemember)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return false, nil
+	})
+
+	user := &mockUser{Email: "test@test.com"}
+	if err := ab.Events.FireAfterContext(context.WithValue(context.Background(), CTXKeyUser, user), EventPasswordChange); err != nil {
+		t.Fatal(err)
+	}
+
+	if gotUser != user {
+		t.Error("the handler should have gotten the context:", gotUser)
+	}
+}
@@ -0,0 +1,78 @@
+package authboss
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"time"
+)
+
+const (
+	// FormValueInvite is the query parameter invitation links carry the
+	// token in, and the form field the register page should post it back in
+	FormValueInvite = "invite"
+
+	inviteTokenSize  = 64
+	inviteTokenSplit = inviteTokenSize / 2
+)
+
+// GenerateInvitation creates an invitation for an e-mail address, and the
+// token that must be sent to them. The caller must store the invitation.
+func GenerateInvitation(email, inviter string, metadata map[string]string, expires time.Time) (Invitation, string, error) {
+	rawToken := make([]byte, inviteTokenSize)
+	if _, err := io.ReadFull(rand.Reader, rawToken); err != nil {
+		return Invitation{}, "", err
+	}
+
+	selectorBytes := sha512.Sum512(rawToken[:inviteTokenSplit])
+	verifierBytes := sha512.Sum512(rawToken[inviteTokenSplit:])
+
+	invitation := Invitation{
+		Selector: base64.StdEncoding.EncodeToString(selectorBytes[:]),
+		Verifier: base64.StdEncoding.EncodeToString(verifierBytes[:]),
+		Email:    email,
+		Inviter:  inviter,
+		Metadata: metadata,
+		Expires:  expires,
+	}
+
+	return invitation, base64.URLEncoding.EncodeToString(rawToken), nil
+}
+
+// LoadInvitation finds the invitation for a token. If the token is invalid
+// or the invitation has expired ErrTokenNotFound is returned.
+func (a *Authboss) LoadInvitation(ctx context.Context, token string) (Invitation, error) {
+	rawToken, err := base64.URLEncoding.DecodeString(token)
+	if err != nil || len(rawToken) != inviteTokenSize {
+		return Invitation{}, ErrTokenNotFound
+	}
+
+	selectorBytes := sha512.Sum512(rawToken[:inviteTokenSplit])
+	verifierBytes := sha512.Sum512(rawToken[inviteTokenSplit:])
+	selector := base64.StdEncoding.EncodeToString(selectorBytes[:])
+
+	storer := EnsureCanInvite(a.Config.Storage.Server)
+	invitation, err := storer.LoadInvitation(ctx, selector)
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	dbVerifierBytes, err := base64.StdEncoding.DecodeString(invitation.Verifier)
+	if err != nil {
+		return Invitation{}, ErrTokenNotFound
+	}
+
+	if subtle.ConstantTimeEq(int32(len(verifierBytes)), int32(len(dbVerifierBytes))) != 1 ||
+		subtle.ConstantTimeCompare(verifierBytes[:], dbVerifierBytes) != 1 {
+		return Invitation{}, ErrTokenNotFound
+	}
+
+	if time.Now().UTC().After(invitation.Expires) {
+		return Invitation{}, ErrTokenNotFound
+	}
+
+	return invitation, nil
+}
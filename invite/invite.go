@@ -0,0 +1,196 @@
+// Package invite lets users invite others to register, for use with
+// Config.Modules.RegisterRequireInvite.
+package invite
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+)
+
+// Constants for templates etc.
+const (
+	DataInviteURL     = "invite_url"
+	DataInviteInviter = "invite_inviter"
+
+	EmailInviteHTML = "invite_html"
+	EmailInviteTxt  = "invite_txt"
+
+	PageInvite = "invite"
+
+	inviteSuccessFlash = "Your invitation has been sent."
+)
+
+func init() {
+	authboss.RegisterModule("invite", &Invite{})
+}
+
+// Valuer provides the e-mail address to send an invitation to
+type Valuer interface {
+	authboss.Validator
+
+	GetEmail() string
+}
+
+// MustHaveInviteValues upgrades a validatable set of values
+// to ones specific to sending an invitation.
+func MustHaveInviteValues(v authboss.Validator) Valuer {
+	if u, ok := v.(Valuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to invite.Valuer: %T", v))
+}
+
+// Invite module
+type Invite struct {
+	*authboss.Authboss
+}
+
+// Init module
+func (i *Invite) Init(ab *authboss.Authboss) (err error) {
+	i.Authboss = ab
+
+	if _, ok := ab.Config.Storage.Server.(authboss.InvitingServerStorer); !ok {
+		return fmt.Errorf("invite module activated but storer could not be upgraded to InvitingServerStorer")
+	}
+
+	if err := i.Authboss.Config.Core.ViewRenderer.Load(PageInvite); err != nil {
+		return err
+	}
+
+	if err := i.Authboss.Config.Core.MailRenderer.Load(EmailInviteHTML, EmailInviteTxt); err != nil {
+		return err
+	}
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if i.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = i.Config.Modules.ResponseOnUnauthed
+	} else if i.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	abmw := authboss.MountedMiddleware2(i.Authboss, true, authboss.RequireFullAuth, unauthedResponse)
+
+	i.Authboss.Config.Core.Router.Get("/invite", abmw(i.Core.ErrorHandler.Wrap(i.Get)))
+	i.Authboss.Config.Core.Router.Post("/invite", abmw(i.Core.ErrorHandler.Wrap(i.Post)))
+	i.Authboss.LimitRoute("/invite", authboss.RateLimitedRoute{SessionKey: authboss.SessionKey})
+
+	return nil
+}
+
+// Get renders a form for a logged in user to invite someone
+func (i *Invite) Get(w http.ResponseWriter, req *http.Request) error {
+	return i.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageInvite, nil)
+}
+
+// Post invites the e-mail address that was entered, on behalf of the
+// logged in user.
+func (i *Invite) Post(w http.ResponseWriter, req *http.Request) error {
+	logger := i.RequestLogger(req)
+
+	validatable, err := i.Authboss.Config.Core.BodyReader.Read(PageInvite, req)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("invite validation failed")
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return i.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageInvite, data)
+	}
+
+	user, err := i.Authboss.CurrentUser(req)
+	if err != nil {
+		return err
+	}
+
+	email := MustHaveInviteValues(validatable).GetEmail()
+	if _, err := Send(req.Context(), i.Authboss, email, user.GetPID(), nil, 0); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s invited %s", user.GetPID(), email)
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: i.Authboss.Config.Paths.InviteOK,
+		Success:      inviteSuccessFlash,
+	}
+	return i.Authboss.Core.Redirector.Redirect(w, req, ro)
+}
+
+// Send creates an invitation for an e-mail address, stores it and e-mails
+// a link to the register page to it. inviter is the pid of the user
+// inviting them, or empty when the application is. The metadata is given
+// to the user when they register if it implements authboss.InvitedUser.
+// The invitation is valid for validFor, or Config.Modules.InviteDuration
+// when it's zero.
+//
+// The token is returned for applications that deliver it some other way as
+// well, eg. showing it to an administrator.
+func Send(ctx context.Context, ab *authboss.Authboss, email, inviter string, metadata map[string]string, validFor time.Duration) (string, error) {
+	logger := ab.Logger(ctx)
+
+	if validFor == 0 {
+		validFor = ab.Config.Modules.InviteDuration
+	}
+	expires := time.Now().UTC().Add(validFor)
+	invitation, token, err := authboss.GenerateInvitation(email, inviter, metadata, expires)
+	if err != nil {
+		return "", err
+	}
+
+	storer := authboss.EnsureCanInvite(ab.Config.Storage.Server)
+	if err := storer.PutInvitation(ctx, invitation); err != nil {
+		return "", err
+	}
+
+	if ab.Config.Modules.MailNoGoroutine {
+		sendInviteEmail(ctx, ab, invitation, token)
+	} else {
+		go sendInviteEmail(ctx, ab, invitation, token)
+	}
+
+	logger.Infof("invitation created for: %s", email)
+	return token, nil
+}
+
+func sendInviteEmail(ctx context.Context, ab *authboss.Authboss, invitation authboss.Invitation, token string) {
+	logger := ab.Logger(ctx)
+
+	email := authboss.Email{
+		To:       []string{invitation.Email},
+		From:     ab.Config.Mail.From,
+		FromName: ab.Config.Mail.FromName,
+		Subject:  ab.Config.Mail.SubjectPrefix + "You have been invited",
+	}
+
+	ro := authboss.EmailResponseOptions{
+		HTMLTemplate: EmailInviteHTML,
+		TextTemplate: EmailInviteTxt,
+		Data: authboss.HTMLData{
+			DataInviteURL:     mailURL(ab, token),
+			DataInviteInviter: invitation.Inviter,
+		},
+	}
+
+	logger.Infof("sending invite e-mail to: %s", invitation.Email)
+	if err := ab.Email(ctx, email, ro); err != nil {
+		logger.Errorf("failed to send invite e-mail to %s: %+v", invitation.Email, err)
+	}
+}
+
+func mailURL(ab *authboss.Authboss, token string) string {
+	query := url.Values{authboss.FormValueInvite: []string{token}}
+
+	if len(ab.Config.Mail.RootURL) != 0 {
+		return fmt.Sprintf("%s?%s", ab.Config.Mail.RootURL+"/register", query.Encode())
+	}
+
+	p := path.Join(ab.Config.Paths.Mount, "register")
+	return fmt.Sprintf("%s%s?%s", ab.Config.Paths.RootURL, p, query.Encode())
+}
@@ -0,0 +1,242 @@
+package invite
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/mocks"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	mailRenderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.MailRenderer = mailRenderer
+	ab.Config.Core.ErrorHandler = errHandler
+	ab.Config.Storage.Server = mocks.NewServerStorer()
+
+	i := &Invite{}
+	if err := i.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageInvite); err != nil {
+		t.Error(err)
+	}
+	if err := mailRenderer.HasLoadedViews(EmailInviteHTML, EmailInviteTxt); err != nil {
+		t.Error(err)
+	}
+
+	if err := router.HasGets("/invite"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/invite"); err != nil {
+		t.Error(err)
+	}
+	if limited, ok := ab.LimitedRoute("/invite"); !ok || limited.SessionKey != authboss.SessionKey {
+		t.Error("invite should be rate limited by the logged in user:", limited)
+	}
+}
+
+type testHarness struct {
+	invite *Invite
+	ab     *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	mailer     *mocks.Emailer
+	redirector *mocks.Redirector
+	renderer   *mocks.Renderer
+	responder  *mocks.Responder
+	session    *mocks.ClientStateRW
+	storer     *mocks.ServerStorer
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.mailer = &mocks.Emailer{}
+	harness.redirector = &mocks.Redirector{}
+	harness.renderer = &mocks.Renderer{}
+	harness.responder = &mocks.Responder{}
+	harness.session = mocks.NewClientRW()
+	harness.storer = mocks.NewServerStorer()
+
+	harness.ab.Paths.InviteOK = "/invite/ok"
+	harness.ab.Modules.MailNoGoroutine = true
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Mailer = harness.mailer
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Core.MailRenderer = harness.renderer
+	harness.ab.Config.Core.Responder = harness.responder
+	harness.ab.Config.Storage.SessionState = harness.session
+	harness.ab.Config.Storage.Server = harness.storer
+
+	harness.invite = &Invite{harness.ab}
+
+	return harness
+}
+
+func TestGet(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := h.invite.Get(w, r); err != nil {
+		t.Error(err)
+	}
+
+	if h.responder.Page != PageInvite {
+		t.Error("page was wrong:", h.responder.Page)
+	}
+}
+
+func TestPostSuccess(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	inviter := &mocks.User{Email: "admin@test.com"}
+	h.storer.Users["admin@test.com"] = inviter
+	h.bodyReader.Return = mocks.Values{Email: "test@test.com"}
+
+	r := mocks.Request("POST")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, inviter))
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if err := h.invite.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.storer.Invitations) != 1 {
+		t.Fatal("an invitation should have been stored")
+	}
+	for _, invitation := range h.storer.Invitations {
+		if invitation.Email != "test@test.com" {
+			t.Error("invited e-mail was wrong:", invitation.Email)
+		}
+		if invitation.Inviter != "admin@test.com" {
+			t.Error("inviter was wrong:", invitation.Inviter)
+		}
+	}
+
+	if to := h.mailer.Email.To; len(to) != 1 || to[0] != "test@test.com" {
+		t.Error("invitation e-mailed to the wrong person:", to)
+	}
+	if p := h.redirector.Options.RedirectPath; p != "/invite/ok" {
+		t.Error("redirect path was wrong:", p)
+	}
+	if h.redirector.Options.Success != inviteSuccessFlash {
+		t.Error("success message was wrong:", h.redirector.Options.Success)
+	}
+}
+
+func TestPostValidationFailure(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.bodyReader.Return = mocks.Values{Errors: []error{errors.New("fail")}}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.invite.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Status != http.StatusOK {
+		t.Error("status was wrong:", h.responder.Status)
+	}
+	if h.responder.Page != PageInvite {
+		t.Error("page was wrong:", h.responder.Page)
+	}
+	if len(h.storer.Invitations) != 0 {
+		t.Error("no invitation should have been stored")
+	}
+}
+
+func TestSend(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	metadata := map[string]string{"role": "admin"}
+	token, err := Send(context.Background(), h.ab, "test@test.com", "", metadata, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invitation, err := h.ab.LoadInvitation(context.Background(), token)
+	if err != nil {
+		t.Fatal("the token should load the invitation:", err)
+	}
+	if invitation.Email != "test@test.com" {
+		t.Error("invited e-mail was wrong:", invitation.Email)
+	}
+	if invitation.Metadata["role"] != "admin" {
+		t.Error("metadata was wrong:", invitation.Metadata)
+	}
+	if left := time.Until(invitation.Expires); left <= 0 || left > h.ab.Config.Modules.InviteDuration {
+		t.Error("expiry was wrong:", invitation.Expires)
+	}
+	if len(h.mailer.Email.To) == 0 {
+		t.Error("the invitation should have been e-mailed")
+	}
+}
+
+func TestSendValidFor(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	token, err := Send(context.Background(), h.ab, "test@test.com", "", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invitation, err := h.ab.LoadInvitation(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := time.Until(invitation.Expires); left <= 0 || left > time.Hour {
+		t.Error("expiry should be an hour from now rather than InviteDuration:", invitation.Expires)
+	}
+}
+
+func TestMailURL(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Paths.RootURL = "https://api.test.com:6343"
+	h.ab.Config.Paths.Mount = "/v1/auth"
+
+	want := "https://api.test.com:6343/v1/auth/register?invite=abc"
+	if got := mailURL(h.ab, "abc"); got != want {
+		t.Error("want:", want, "got:", got)
+	}
+
+	h.ab.Config.Mail.RootURL = "https://test.com:3333/testauth"
+
+	want = "https://test.com:3333/testauth/register?invite=abc"
+	if got := mailURL(h.ab, "abc"); got != want {
+		t.Error("want:", want, "got:", got)
+	}
+}
@@ -0,0 +1,20 @@
+package authboss
+
+import (
+	"time"
+)
+
+// LockPolicy decides when a LockableUser is locked and for how long. It's
+// consulted by the lock module on every authentication attempt, see
+// Config.Modules.LockPolicy.
+type LockPolicy interface {
+	// Failed is called after a failed authentication attempt. It should
+	// record the attempt on the user and return the time until which the
+	// user is to be locked, or the zero time to leave the lock alone.
+	Failed(user LockableUser, now time.Time) (lockUntil time.Time)
+	// Succeeded is called after a successful authentication attempt.
+	Succeeded(user LockableUser, now time.Time)
+	// Locked is called when the user is locked manually, it should return
+	// the time until which the user is to be locked.
+	Locked(user LockableUser, now time.Time) (lockUntil time.Time)
+}
@@ -34,6 +34,10 @@ func (l *Lock) Init(ab *authboss.Authboss) error {
 	l.Events.After(authboss.EventAuth, l.AfterAuthSuccess)
 	l.Events.After(authboss.EventAuthFail, l.AfterAuthFail)
 
+	if ab.Config.Modules.LockUnlockByEmail {
+		return l.initUnlock()
+	}
+
 	return nil
 }
 
@@ -49,9 +53,10 @@ func (l *Lock) AfterAuthSuccess(w http.ResponseWriter, r *http.Request, handled
 		return false, err
 	}
 
+	now := time.Now().UTC()
 	lu := authboss.MustBeLockable(user)
-	lu.PutAttemptCount(0)
-	lu.PutLastAttempt(time.Now().UTC())
+	l.policy().Succeeded(lu, now)
+	lu.PutLastAttempt(now)
 
 	return false, l.Authboss.Config.Storage.Server.Save(r.Context(), lu)
 }
@@ -70,42 +75,72 @@ func (l *Lock) updateLockedState(w http.ResponseWriter, r *http.Request, wasCorr
 		return false, err
 	}
 
-	// Fetch things
+	now := time.Now().UTC()
 	lu := authboss.MustBeLockable(user)
-	last := lu.GetLastAttempt()
-	attempts := lu.GetAttemptCount()
-	attempts++
 
+	justLocked := false
 	if !wasCorrectPassword {
-		if time.Now().UTC().Sub(last) <= l.Modules.LockWindow {
-			if attempts >= l.Modules.LockAfter {
-				lu.PutLocked(time.Now().UTC().Add(l.Modules.LockDuration))
-			}
-
-			lu.PutAttemptCount(attempts)
-		} else {
-			lu.PutAttemptCount(1)
+		if until := l.policy().Failed(lu, now); !until.IsZero() {
+			justLocked = !IsLocked(lu)
+			lu.PutLocked(until)
+		}
+	}
+	lu.PutLastAttempt(now)
+
+	// Send an unlock e-mail when the user is first locked, subsequent
+	// attempts while locked shouldn't flood their inbox
+	var unlockToken string
+	if justLocked && l.Authboss.Config.Modules.LockUnlockByEmail {
+		if unlockToken, err = l.putUnlockCreds(authboss.MustBeUnlockable(lu)); err != nil {
+			return false, err
 		}
 	}
-	lu.PutLastAttempt(time.Now().UTC())
 
 	if err := l.Authboss.Config.Storage.Server.Save(r.Context(), lu); err != nil {
 		return false, err
 	}
 
+	if len(unlockToken) != 0 {
+		l.sendUnlockEmail(r.Context(), authboss.MustBeUnlockable(lu).GetEmail(), unlockToken)
+	}
+
 	if !IsLocked(lu) {
 		return false, nil
 	}
 
+	if justLocked {
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, lu))
+		handled, err := l.Authboss.Events.FireAfter(authboss.EventLock, w, r)
+		if err != nil {
+			return false, err
+		} else if handled {
+			return true, nil
+		}
+	}
+
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
-		Failure:      "Your account has been locked, please contact the administrator.",
+		Failure:      lockedMessage(l.Authboss),
 		RedirectPath: l.Authboss.Config.Paths.LockNotOK,
 	}
 	return true, l.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
 
-// Lock a user manually.
+// policy returns the configured LockPolicy or the FixedPolicy built from
+// the LockAfter, LockWindow and LockDuration configuration.
+func (l *Lock) policy() authboss.LockPolicy {
+	if l.Authboss.Config.Modules.LockPolicy != nil {
+		return l.Authboss.Config.Modules.LockPolicy
+	}
+
+	return FixedPolicy{
+		After:    l.Authboss.Config.Modules.LockAfter,
+		Window:   l.Authboss.Config.Modules.LockWindow,
+		Duration: l.Authboss.Config.Modules.LockDuration,
+	}
+}
+
+// Lock a user manually for as long as the lock policy decides.
 func (l *Lock) Lock(ctx context.Context, key string) error {
 	user, err := l.Authboss.Config.Storage.Server.Load(ctx, key)
 	if err != nil {
@@ -113,9 +148,13 @@ func (l *Lock) Lock(ctx context.Context, key string) error {
 	}
 
 	lu := authboss.MustBeLockable(user)
-	lu.PutLocked(time.Now().UTC().Add(l.Authboss.Config.Modules.LockDuration))
+	lu.PutLocked(l.policy().Locked(lu, time.Now().UTC()))
+
+	if err := l.Authboss.Config.Storage.Server.Save(ctx, lu); err != nil {
+		return err
+	}
 
-	return l.Authboss.Config.Storage.Server.Save(ctx, lu)
+	return l.Authboss.Events.FireAfterContext(context.WithValue(ctx, authboss.CTXKeyUser, lu), authboss.EventLock)
 }
 
 // Unlock a user that was locked by this module.
@@ -136,7 +175,11 @@ func (l *Lock) Unlock(ctx context.Context, key string) error {
 	lu.PutLastAttempt(now.Add(-l.Authboss.Config.Modules.LockWindow * 2))
 	lu.PutLocked(now.Add(-l.Authboss.Config.Modules.LockDuration))
 
-	return l.Authboss.Config.Storage.Server.Save(ctx, lu)
+	if err := l.Authboss.Config.Storage.Server.Save(ctx, lu); err != nil {
+		return err
+	}
+
+	return l.Authboss.Events.FireAfterContext(context.WithValue(ctx, authboss.CTXKeyUser, lu), authboss.EventUnlock)
 }
 
 // Middleware ensures that a user is not locked, or else it will intercept
@@ -168,7 +211,7 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 			logger.Infof("user %s prevented from accessing %s: locked", user.GetPID(), r.URL.Path)
 			ro := authboss.RedirectOptions{
 				Code:         http.StatusTemporaryRedirect,
-				Failure:      "Your account has been locked, please contact the administrator.",
+				Failure:      lockedMessage(ab),
 				RedirectPath: ab.Config.Paths.LockNotOK,
 			}
 			if err := ab.Config.Core.Redirector.Redirect(w, r, ro); err != nil {
@@ -178,6 +221,15 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 	}
 }
 
+// lockedMessage is shown to users who are locked
+func lockedMessage(ab *authboss.Authboss) string {
+	if ab.Config.Modules.LockUnlockByEmail {
+		return "Your account has been locked, please check your e-mail or wait to try again."
+	}
+
+	return "Your account has been locked, please contact the administrator."
+}
+
 // IsLocked checks if a user is locked
 func IsLocked(lu authboss.LockableUser) bool {
 	return lu.GetLocked().After(time.Now().UTC())
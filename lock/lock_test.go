@@ -176,6 +176,9 @@ func TestAfterAuthFailure(t *testing.T) {
 		t.Error("should not be locked")
 	}
 
+	lockEvent := mocks.NewAfterCallback()
+	harness.ab.Events.After(authboss.EventLock, lockEvent.Fn)
+
 	r := mocks.Request("GET")
 	w := httptest.NewRecorder()
 
@@ -215,6 +218,9 @@ func TestAfterAuthFailure(t *testing.T) {
 	if !IsLocked(harness.storer.Users["test@test.com"]) {
 		t.Error("should be locked at the end")
 	}
+	if !lockEvent.HasBeenCalled {
+		t.Error("the lock event should have fired")
+	}
 
 	if w.Code != http.StatusTemporaryRedirect {
 		t.Error("code was wrong:", w.Code)
@@ -244,6 +250,9 @@ func TestLock(t *testing.T) {
 		t.Error("should not be locked")
 	}
 
+	lockEvent := mocks.NewAfterCallback()
+	harness.ab.Events.After(authboss.EventLock, lockEvent.Fn)
+
 	if err := harness.lock.Lock(context.Background(), "test@test.com"); err != nil {
 		t.Error(err)
 	}
@@ -251,6 +260,30 @@ func TestLock(t *testing.T) {
 	if !IsLocked(harness.storer.Users["test@test.com"]) {
 		t.Error("should be locked")
 	}
+	if !lockEvent.HasBeenCalled {
+		t.Error("the lock event should have fired")
+	}
+}
+
+func TestLockPolicy(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Config.Modules.LockPolicy = ExponentialPolicy{Duration: time.Hour, Factor: 2}
+
+	user := &mocks.User{Email: "test@test.com", LockCount: 2}
+	harness.storer.Users["test@test.com"] = user
+
+	if err := harness.lock.Lock(context.Background(), "test@test.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if left := time.Until(user.Locked); left <= 3*time.Hour || left > 4*time.Hour {
+		t.Error("the policy's lock duration should have been used:", user.Locked)
+	}
+	if user.LockCount != 3 {
+		t.Error("the lock should have been counted:", user.LockCount)
+	}
 }
 
 func TestUnlock(t *testing.T) {
@@ -268,6 +301,12 @@ func TestUnlock(t *testing.T) {
 		t.Error("should be locked")
 	}
 
+	var unlocked authboss.User
+	harness.ab.Events.After(authboss.EventUnlock, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		unlocked = r.Context().Value(authboss.CTXKeyUser).(authboss.User)
+		return false, nil
+	})
+
 	if err := harness.lock.Unlock(context.Background(), "test@test.com"); err != nil {
 		t.Error(err)
 	}
@@ -275,6 +314,9 @@ func TestUnlock(t *testing.T) {
 	if IsLocked(harness.storer.Users["test@test.com"]) {
 		t.Error("should no longer be locked")
 	}
+	if unlocked == nil || unlocked.GetPID() != "test@test.com" {
+		t.Error("the unlock event should have fired with the user")
+	}
 }
 
 func TestMiddlewareAllow(t *testing.T) {
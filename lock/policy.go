@@ -0,0 +1,131 @@
+package lock
+
+import (
+	"math"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+)
+
+// FixedPolicy locks a user for Duration once they fail to authenticate
+// After times with no more than Window between attempts. It's the policy
+// used when Config.Modules.LockPolicy is not set.
+type FixedPolicy struct {
+	After    int
+	Window   time.Duration
+	Duration time.Duration
+}
+
+// Failed counts the attempt and locks the user if they're beyond limits.
+func (f FixedPolicy) Failed(user authboss.LockableUser, now time.Time) time.Time {
+	if !countAttempt(user, now, f.Window, f.After) {
+		return time.Time{}
+	}
+
+	return now.Add(f.Duration)
+}
+
+// Succeeded resets the attempt count.
+func (f FixedPolicy) Succeeded(user authboss.LockableUser, now time.Time) {
+	user.PutAttemptCount(0)
+}
+
+// Locked locks the user for Duration.
+func (f FixedPolicy) Locked(user authboss.LockableUser, now time.Time) time.Time {
+	return now.Add(f.Duration)
+}
+
+// ExponentialPolicy locks a user once they fail to authenticate After times
+// with no more than Window between attempts, like FixedPolicy. However each
+// successive lock lasts Factor times longer than the last, starting at
+// Duration and never more than MaxDuration. Every successful login forgives
+// one previous lock.
+//
+// Lock counts are kept on users implementing authboss.LockCountingUser,
+// other users are always locked for Duration.
+type ExponentialPolicy struct {
+	After    int
+	Window   time.Duration
+	Duration time.Duration
+
+	// Factor the lock duration grows by, 2 if it's not set.
+	Factor float64
+	// MaxDuration caps the lock duration, 0 means no cap.
+	MaxDuration time.Duration
+}
+
+// Failed counts the attempt and locks the user for an escalating duration
+// if they're beyond limits. Failures while already locked are counted but
+// don't escalate the lock further.
+func (e ExponentialPolicy) Failed(user authboss.LockableUser, now time.Time) time.Time {
+	if user.GetLocked().After(now) {
+		user.PutAttemptCount(user.GetAttemptCount() + 1)
+		return time.Time{}
+	}
+
+	if !countAttempt(user, now, e.Window, e.After) {
+		return time.Time{}
+	}
+
+	var locks int
+	if cu, ok := user.(authboss.LockCountingUser); ok {
+		locks = cu.GetLockCount()
+		cu.PutLockCount(locks + 1)
+	}
+
+	return now.Add(e.LockDuration(locks))
+}
+
+// Succeeded resets the attempt count and forgives one previous lock.
+func (e ExponentialPolicy) Succeeded(user authboss.LockableUser, now time.Time) {
+	user.PutAttemptCount(0)
+
+	if cu, ok := user.(authboss.LockCountingUser); ok && cu.GetLockCount() > 0 {
+		cu.PutLockCount(cu.GetLockCount() - 1)
+	}
+}
+
+// Locked locks the user for an escalating duration and counts the lock,
+// like locks from failed attempts.
+func (e ExponentialPolicy) Locked(user authboss.LockableUser, now time.Time) time.Time {
+	var locks int
+	if cu, ok := user.(authboss.LockCountingUser); ok {
+		locks = cu.GetLockCount()
+		cu.PutLockCount(locks + 1)
+	}
+
+	return now.Add(e.LockDuration(locks))
+}
+
+// LockDuration returns how long a user who has been locked previousLocks
+// times before is locked for.
+func (e ExponentialPolicy) LockDuration(previousLocks int) time.Duration {
+	factor := e.Factor
+	if factor <= 0 {
+		factor = 2
+	}
+
+	duration := float64(e.Duration) * math.Pow(factor, float64(previousLocks))
+	if e.MaxDuration > 0 && duration > float64(e.MaxDuration) {
+		return e.MaxDuration
+	}
+	if duration > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(duration)
+}
+
+// countAttempt records a failed attempt within window and reports
+// whether the user has now reached the limit of attempts.
+func countAttempt(user authboss.LockableUser, now time.Time, window time.Duration, after int) bool {
+	attempts := user.GetAttemptCount() + 1
+
+	if now.Sub(user.GetLastAttempt()) > window {
+		user.PutAttemptCount(1)
+		return false
+	}
+
+	user.PutAttemptCount(attempts)
+	return attempts >= after
+}
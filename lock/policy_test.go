@@ -0,0 +1,119 @@
+package lock
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/mocks"
+)
+
+func TestFixedPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy := FixedPolicy{After: 2, Window: time.Minute, Duration: time.Hour}
+	now := time.Now().UTC()
+	user := &mocks.User{LastAttempt: now.Add(-time.Hour)}
+
+	if until := policy.Failed(user, now); !until.IsZero() {
+		t.Error("first attempt outside the window should not lock")
+	}
+	if user.AttemptCount != 1 {
+		t.Error("attempt count was wrong:", user.AttemptCount)
+	}
+
+	user.LastAttempt = now
+	if until := policy.Failed(user, now); !until.Equal(now.Add(time.Hour)) {
+		t.Error("second attempt should lock for an hour, got:", until)
+	}
+
+	policy.Succeeded(user, now)
+	if user.AttemptCount != 0 {
+		t.Error("attempt count should be reset:", user.AttemptCount)
+	}
+
+	if until := policy.Locked(user, now); !until.Equal(now.Add(time.Hour)) {
+		t.Error("manual locks should last an hour, got:", until)
+	}
+}
+
+func TestExponentialPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy := ExponentialPolicy{After: 1, Window: time.Minute, Duration: time.Minute, MaxDuration: 5 * time.Minute}
+	now := time.Now().UTC()
+	user := &mocks.User{LastAttempt: now}
+
+	wants := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute}
+	for i, want := range wants {
+		user.LastAttempt = now
+		user.Locked = time.Time{}
+
+		until := policy.Failed(user, now)
+		if got := until.Sub(now); got != want {
+			t.Errorf("%d) lock duration wrong, want: %v got: %v", i, want, got)
+		}
+		if user.LockCount != i+1 {
+			t.Errorf("%d) lock count wrong: %d", i, user.LockCount)
+		}
+	}
+
+	user.Locked = now.Add(time.Minute)
+	if until := policy.Failed(user, now); !until.IsZero() {
+		t.Error("failures while locked should not escalate")
+	}
+	if user.LockCount != len(wants) {
+		t.Error("lock count should not change while locked:", user.LockCount)
+	}
+
+	policy.Succeeded(user, now)
+	if user.LockCount != len(wants)-1 {
+		t.Error("a success should forgive one lock:", user.LockCount)
+	}
+	if user.AttemptCount != 0 {
+		t.Error("attempt count should be reset:", user.AttemptCount)
+	}
+}
+
+func TestExponentialPolicyDefaults(t *testing.T) {
+	t.Parallel()
+
+	policy := ExponentialPolicy{Duration: time.Second}
+	if d := policy.LockDuration(3); d != 8*time.Second {
+		t.Error("factor should default to 2, got:", d)
+	}
+	if d := policy.LockDuration(1000); d <= 0 {
+		t.Error("duration should not overflow, got:", d)
+	}
+}
+
+func TestAfterAuthFailurePolicy(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Modules.LockPolicy = ExponentialPolicy{After: 1, Window: time.Minute, Duration: time.Minute}
+
+	user := &mocks.User{Email: "test@test.com", LockCount: 2, LastAttempt: time.Now().UTC()}
+	harness.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := httptest.NewRecorder()
+
+	handled, err := harness.lock.AfterAuthFail(w, r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handled {
+		t.Error("the user should have been locked")
+	}
+
+	if left := time.Until(user.Locked); left < 3*time.Minute || left > 4*time.Minute {
+		t.Error("lock should last about 4 minutes, left:", left)
+	}
+	if user.LockCount != 3 {
+		t.Error("lock count should have increased:", user.LockCount)
+	}
+}
@@ -0,0 +1,325 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+)
+
+// Constants for templates etc.
+const (
+	DataUnlockURL = "unlock_url"
+
+	FormValueToken = "token"
+
+	EmailUnlockHTML = "unlock_html"
+	EmailUnlockTxt  = "unlock_txt"
+
+	PageUnlockStart = "unlock_start"
+	PageUnlockEnd   = "unlock_end"
+
+	unlockStartSuccessFlash = "If your account is locked an e-mail has been sent to you with instructions on how to unlock it."
+
+	unlockTokenSize  = 64
+	unlockTokenSplit = unlockTokenSize / 2
+)
+
+// UnlockStartValuer provides the PID of the user asking for an unlock e-mail
+type UnlockStartValuer interface {
+	authboss.Validator
+
+	GetPID() string
+}
+
+// UnlockEndValuer provides the token from the unlock e-mail's link
+type UnlockEndValuer interface {
+	authboss.Validator
+
+	GetToken() string
+}
+
+// MustHaveUnlockStartValues upgrades a validatable set of values
+// to ones specific to a user asking to be unlocked.
+func MustHaveUnlockStartValues(v authboss.Validator) UnlockStartValuer {
+	if u, ok := v.(UnlockStartValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to UnlockStartValuer: %T", v))
+}
+
+// MustHaveUnlockEndValues upgrades a validatable set of values
+// to ones specific to a user unlocking with a token.
+func MustHaveUnlockEndValues(v authboss.Validator) UnlockEndValuer {
+	if u, ok := v.(UnlockEndValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to UnlockEndValuer: %T", v))
+}
+
+// initUnlock sets up the routes and templates for unlocking by e-mail
+func (l *Lock) initUnlock() error {
+	if err := l.Authboss.Config.Core.ViewRenderer.Load(PageUnlockStart); err != nil {
+		return err
+	}
+
+	if err := l.Authboss.Config.Core.MailRenderer.Load(EmailUnlockHTML, EmailUnlockTxt); err != nil {
+		return err
+	}
+
+	var callbackMethod func(string, http.Handler)
+	switch l.Authboss.Config.Modules.MailRouteMethod {
+	case http.MethodGet:
+		callbackMethod = l.Authboss.Config.Core.Router.Get
+	case http.MethodPost:
+		callbackMethod = l.Authboss.Config.Core.Router.Post
+	default:
+		panic("invalid config for MailRouteMethod")
+	}
+
+	l.Authboss.Config.Core.Router.Get("/unlock", l.Authboss.Core.ErrorHandler.Wrap(l.UnlockStartGet))
+	l.Authboss.Config.Core.Router.Post("/unlock", l.Authboss.Core.ErrorHandler.Wrap(l.UnlockStartPost))
+	l.Authboss.LimitRoute("/unlock", authboss.RateLimitedRoute{Page: PageUnlockStart})
+	callbackMethod("/unlock/end", l.Authboss.Core.ErrorHandler.Wrap(l.UnlockEnd))
+
+	return nil
+}
+
+// UnlockStartGet renders a form for a locked user to ask for an unlock e-mail.
+func (l *Lock) UnlockStartGet(w http.ResponseWriter, r *http.Request) error {
+	return l.Authboss.Config.Core.Responder.Respond(w, r, http.StatusOK, PageUnlockStart, nil)
+}
+
+// UnlockStartPost sends an unlock e-mail to the user if they're locked.
+// The response is the same whether or not the user exists or is locked.
+func (l *Lock) UnlockStartPost(w http.ResponseWriter, r *http.Request) error {
+	logger := l.RequestLogger(r)
+
+	validatable, err := l.Authboss.Core.BodyReader.Read(PageUnlockStart, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("unlock validation failed")
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return l.Authboss.Core.Responder.Respond(w, r, http.StatusOK, PageUnlockStart, data)
+	}
+
+	values := MustHaveUnlockStartValues(validatable)
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: l.Authboss.Config.Paths.UnlockOK,
+		Success:      unlockStartSuccessFlash,
+	}
+
+	user, err := l.Authboss.Storage.Server.Load(r.Context(), values.GetPID())
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("user %s was attempted to be unlocked, user does not exist, faking successful response", values.GetPID())
+		return l.Authboss.Core.Redirector.Redirect(w, r, ro)
+	} else if err != nil {
+		return err
+	}
+
+	uu := authboss.MustBeUnlockable(user)
+	if !IsLocked(uu) {
+		logger.Infof("user %s was attempted to be unlocked, user is not locked, faking successful response", uu.GetPID())
+		return l.Authboss.Core.Redirector.Redirect(w, r, ro)
+	}
+
+	token, err := l.putUnlockCreds(uu)
+	if err != nil {
+		return err
+	}
+
+	if err := l.Authboss.Storage.Server.Save(r.Context(), uu); err != nil {
+		return err
+	}
+
+	l.sendUnlockEmail(r.Context(), uu.GetEmail(), token)
+
+	logger.Infof("user %s unlock e-mail requested", uu.GetPID())
+	return l.Authboss.Core.Redirector.Redirect(w, r, ro)
+}
+
+// UnlockEnd unlocks the user if the token from the unlock e-mail is valid.
+// Tokens can only be used once.
+func (l *Lock) UnlockEnd(w http.ResponseWriter, r *http.Request) error {
+	logger := l.RequestLogger(r)
+
+	validatable, err := l.Authboss.Core.BodyReader.Read(PageUnlockEnd, r)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Infof("validation failed in Lock.UnlockEnd, this typically means a bad token: %+v", errs)
+		return l.invalidUnlockToken(w, r)
+	}
+
+	values := MustHaveUnlockEndValues(validatable)
+
+	rawToken, err := base64.URLEncoding.DecodeString(values.GetToken())
+	if err != nil {
+		logger.Infof("invalid unlock token submitted, base64 decode failed: %+v", err)
+		return l.invalidUnlockToken(w, r)
+	}
+
+	if len(rawToken) != unlockTokenSize {
+		logger.Infof("invalid unlock token submitted, size was wrong: %d", len(rawToken))
+		return l.invalidUnlockToken(w, r)
+	}
+
+	selectorBytes := sha512.Sum512(rawToken[:unlockTokenSplit])
+	verifierBytes := sha512.Sum512(rawToken[unlockTokenSplit:])
+	selector := base64.StdEncoding.EncodeToString(selectorBytes[:])
+
+	storer := authboss.EnsureCanUnlock(l.Authboss.Config.Storage.Server)
+	user, err := storer.LoadByUnlockSelector(r.Context(), selector)
+	if err == authboss.ErrUserNotFound {
+		logger.Info("invalid unlock token submitted, user not found")
+		return l.invalidUnlockToken(w, r)
+	} else if err != nil {
+		return err
+	}
+
+	if time.Now().UTC().After(user.GetUnlockExpiry()) {
+		logger.Info("invalid unlock token submitted, already expired")
+		return l.invalidUnlockToken(w, r)
+	}
+
+	dbVerifierBytes, err := base64.StdEncoding.DecodeString(user.GetUnlockVerifier())
+	if err != nil {
+		logger.Infof("invalid unlock verifier stored in database: %s", user.GetUnlockVerifier())
+		return l.invalidUnlockToken(w, r)
+	}
+
+	if subtle.ConstantTimeEq(int32(len(verifierBytes)), int32(len(dbVerifierBytes))) != 1 ||
+		subtle.ConstantTimeCompare(verifierBytes[:], dbVerifierBytes) != 1 {
+		logger.Info("stored unlock verifier does not match provided one")
+		return l.invalidUnlockToken(w, r)
+	}
+
+	user.PutUnlockSelector("")             // Don't allow another unlock
+	user.PutUnlockVerifier("")             // Don't allow another unlock
+	user.PutUnlockExpiry(time.Now().UTC()) // Put current time for those DBs that can't handle 0 time
+
+	if err := storer.Save(r.Context(), user); err != nil {
+		return err
+	}
+
+	if err := l.Unlock(r.Context(), user.GetPID()); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s unlocked their account by e-mail", user.GetPID())
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: l.Authboss.Config.Paths.UnlockOK,
+		Success:      "Your account has been unlocked.",
+	}
+	return l.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// putUnlockCreds generates a new unlock token and puts its selector,
+// verifier and expiry in the user. The user must still be saved.
+func (l *Lock) putUnlockCreds(user authboss.UnlockableUser) (token string, err error) {
+	selector, verifier, token, err := GenerateUnlockCreds()
+	if err != nil {
+		return "", err
+	}
+
+	user.PutUnlockSelector(selector)
+	user.PutUnlockVerifier(verifier)
+	user.PutUnlockExpiry(time.Now().UTC().Add(l.Authboss.Config.Modules.LockUnlockTokenDuration))
+
+	return token, nil
+}
+
+// sendUnlockEmail sends the e-mail in a goroutine unless MailNoGoroutine
+// is set.
+func (l *Lock) sendUnlockEmail(ctx context.Context, to, token string) {
+	if l.Authboss.Config.Modules.MailNoGoroutine {
+		l.SendUnlockEmail(ctx, to, token)
+	} else {
+		go l.SendUnlockEmail(ctx, to, token)
+	}
+}
+
+// SendUnlockEmail to a specific e-mail address passing along the encodedToken
+// in an escaped URL to the templates.
+func (l *Lock) SendUnlockEmail(ctx context.Context, to, encodedToken string) {
+	logger := l.Authboss.Logger(ctx)
+
+	email := authboss.Email{
+		To:       []string{to},
+		From:     l.Authboss.Config.Mail.From,
+		FromName: l.Authboss.Config.Mail.FromName,
+		Subject:  l.Authboss.Config.Mail.SubjectPrefix + "Unlock Account",
+	}
+
+	ro := authboss.EmailResponseOptions{
+		HTMLTemplate: EmailUnlockHTML,
+		TextTemplate: EmailUnlockTxt,
+		Data: authboss.HTMLData{
+			DataUnlockURL: l.unlockURL(encodedToken),
+		},
+	}
+
+	logger.Infof("sending unlock e-mail to: %s", to)
+	if err := l.Authboss.Email(ctx, email, ro); err != nil {
+		logger.Errorf("failed to send unlock e-mail to %s: %+v", to, err)
+	}
+}
+
+func (l *Lock) invalidUnlockToken(w http.ResponseWriter, r *http.Request) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		Failure:      "unlock token is invalid",
+		RedirectPath: l.Authboss.Config.Paths.LockNotOK,
+	}
+	return l.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+func (l *Lock) unlockURL(token string) string {
+	query := url.Values{FormValueToken: []string{token}}
+
+	if len(l.Config.Mail.RootURL) != 0 {
+		return fmt.Sprintf("%s?%s", l.Config.Mail.RootURL+"/unlock/end", query.Encode())
+	}
+
+	p := path.Join(l.Config.Paths.Mount, "unlock/end")
+	return fmt.Sprintf("%s%s?%s", l.Config.Paths.RootURL, p, query.Encode())
+}
+
+// GenerateUnlockCreds generates pieces needed for unlocking a user
+// selector: hash of the first half of a 64 byte value
+// (to be stored in the database and used in SELECT query)
+// verifier: hash of the second half of a 64 byte value
+// (to be stored in database but never used in SELECT query)
+// token: the user-facing base64 encoded selector+verifier
+func GenerateUnlockCreds() (selector, verifier, token string, err error) {
+	rawToken := make([]byte, unlockTokenSize)
+	if _, err = io.ReadFull(rand.Reader, rawToken); err != nil {
+		return "", "", "", err
+	}
+	selectorBytes := sha512.Sum512(rawToken[:unlockTokenSplit])
+	verifierBytes := sha512.Sum512(rawToken[unlockTokenSplit:])
+
+	return base64.StdEncoding.EncodeToString(selectorBytes[:]),
+		base64.StdEncoding.EncodeToString(verifierBytes[:]),
+		base64.URLEncoding.EncodeToString(rawToken),
+		nil
+}
@@ -0,0 +1,326 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/mocks"
+)
+
+func TestInitUnlock(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	mailRenderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.MailRenderer = mailRenderer
+	ab.Config.Core.ErrorHandler = errHandler
+	ab.Config.Modules.LockUnlockByEmail = true
+
+	l := &Lock{}
+	if err := l.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageUnlockStart); err != nil {
+		t.Error(err)
+	}
+	if err := mailRenderer.HasLoadedViews(EmailUnlockHTML, EmailUnlockTxt); err != nil {
+		t.Error(err)
+	}
+
+	if err := router.HasGets("/unlock", "/unlock/end"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/unlock"); err != nil {
+		t.Error(err)
+	}
+}
+
+func unlockSetup() *testHarness {
+	harness := testSetup()
+	harness.ab.Paths.UnlockOK = "/unlock/ok"
+	harness.ab.Modules.LockUnlockByEmail = true
+	harness.ab.Modules.MailNoGoroutine = true
+
+	return harness
+}
+
+func TestUnlockStartPostSuccess(t *testing.T) {
+	t.Parallel()
+
+	harness := unlockSetup()
+
+	user := &mocks.User{Email: "test@test.com", Locked: time.Now().UTC().Add(time.Hour)}
+	harness.storer.Users["test@test.com"] = user
+	harness.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := harness.lock.UnlockStartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(user.UnlockSelector) == 0 || len(user.UnlockVerifier) == 0 {
+		t.Error("unlock creds should have been stored")
+	}
+	if time.Until(user.UnlockTokenExpiry) <= 0 {
+		t.Error("unlock expiry should be in the future")
+	}
+
+	if to := harness.mailer.Email.To; len(to) != 1 || to[0] != "test@test.com" {
+		t.Error("e-mail was not sent to the user:", to)
+	}
+	if url := harness.renderer.Data[DataUnlockURL].(string); !strings.Contains(url, "/unlock/end?token=") {
+		t.Error("unlock url was wrong:", url)
+	}
+
+	if p := harness.redirector.Options.RedirectPath; p != "/unlock/ok" {
+		t.Error("redir path was wrong:", p)
+	}
+	if s := harness.redirector.Options.Success; s != unlockStartSuccessFlash {
+		t.Error("success message was wrong:", s)
+	}
+}
+
+func TestUnlockStartPostNotLocked(t *testing.T) {
+	t.Parallel()
+
+	harness := unlockSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	harness.storer.Users["test@test.com"] = user
+	harness.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := harness.lock.UnlockStartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(user.UnlockSelector) != 0 {
+		t.Error("unlock creds should not have been stored")
+	}
+	if len(harness.mailer.Email.To) != 0 {
+		t.Error("no e-mail should have been sent")
+	}
+	if s := harness.redirector.Options.Success; s != unlockStartSuccessFlash {
+		t.Error("response should look successful:", s)
+	}
+}
+
+func TestUnlockStartPostUserNotFound(t *testing.T) {
+	t.Parallel()
+
+	harness := unlockSetup()
+	harness.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := harness.lock.UnlockStartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(harness.mailer.Email.To) != 0 {
+		t.Error("no e-mail should have been sent")
+	}
+	if s := harness.redirector.Options.Success; s != unlockStartSuccessFlash {
+		t.Error("response should look successful:", s)
+	}
+}
+
+func TestUnlockStartPostValidationFailure(t *testing.T) {
+	t.Parallel()
+
+	harness := unlockSetup()
+	harness.bodyReader.Return = mocks.Values{Errors: []error{errors.New("fail")}}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := harness.lock.UnlockStartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if harness.responder.Page != PageUnlockStart {
+		t.Error("page was wrong:", harness.responder.Page)
+	}
+	if _, ok := harness.responder.Data[authboss.DataValidation]; !ok {
+		t.Error("expected validation errors")
+	}
+}
+
+func TestUnlockEndSuccess(t *testing.T) {
+	t.Parallel()
+
+	harness := unlockSetup()
+
+	selector, verifier, token, err := GenerateUnlockCreds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{
+		Email:             "test@test.com",
+		AttemptCount:      3,
+		Locked:            time.Now().UTC().Add(time.Hour),
+		UnlockSelector:    selector,
+		UnlockVerifier:    verifier,
+		UnlockTokenExpiry: time.Now().UTC().Add(time.Hour),
+	}
+	harness.storer.Users["test@test.com"] = user
+	harness.bodyReader.Return = mocks.Values{Token: token}
+
+	unlocked := false
+	harness.ab.Events.After(authboss.EventUnlock, func(_ http.ResponseWriter, r *http.Request, _ bool) (bool, error) {
+		unlocked = true
+		return false, nil
+	})
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := harness.lock.UnlockEnd(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if IsLocked(user) {
+		t.Error("user should have been unlocked")
+	}
+	if user.AttemptCount != 0 {
+		t.Error("attempt count should be reset:", user.AttemptCount)
+	}
+	if len(user.UnlockSelector) != 0 || len(user.UnlockVerifier) != 0 {
+		t.Error("unlock token should be single use")
+	}
+	if !unlocked {
+		t.Error("unlock event should have been fired")
+	}
+
+	if p := harness.redirector.Options.RedirectPath; p != "/unlock/ok" {
+		t.Error("redir path was wrong:", p)
+	}
+	if len(harness.redirector.Options.Success) == 0 {
+		t.Error("expected a success message")
+	}
+}
+
+func TestUnlockEndInvalid(t *testing.T) {
+	t.Parallel()
+
+	selector, verifier, token, err := GenerateUnlockCreds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherVerifier, _, err := GenerateUnlockCreds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name     string
+		Token    string
+		Verifier string
+		Expiry   time.Time
+	}{
+		{"BadBase64", "$$$", verifier, time.Now().UTC().Add(time.Hour)},
+		{"BadLength", "dGVzdA==", verifier, time.Now().UTC().Add(time.Hour)},
+		{"Expired", token, verifier, time.Now().UTC().Add(-time.Hour)},
+		{"WrongVerifier", token, otherVerifier, time.Now().UTC().Add(time.Hour)},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			harness := unlockSetup()
+
+			locked := time.Now().UTC().Add(time.Hour)
+			user := &mocks.User{
+				Email:             "test@test.com",
+				Locked:            locked,
+				UnlockSelector:    selector,
+				UnlockVerifier:    test.Verifier,
+				UnlockTokenExpiry: test.Expiry,
+			}
+			harness.storer.Users["test@test.com"] = user
+			harness.bodyReader.Return = mocks.Values{Token: test.Token}
+
+			r := mocks.Request("GET")
+			w := httptest.NewRecorder()
+
+			if err := harness.lock.UnlockEnd(w, r); err != nil {
+				t.Fatal(err)
+			}
+
+			if !user.Locked.Equal(locked) {
+				t.Error("user should still be locked")
+			}
+			if p := harness.redirector.Options.RedirectPath; p != "/lock/not/ok" {
+				t.Error("redir path was wrong:", p)
+			}
+			if f := harness.redirector.Options.Failure; f != "unlock token is invalid" {
+				t.Error("failure message was wrong:", f)
+			}
+		})
+	}
+}
+
+func TestAfterAuthFailSendsUnlockEmail(t *testing.T) {
+	t.Parallel()
+
+	harness := unlockSetup()
+
+	user := &mocks.User{Email: "test@test.com", AttemptCount: 2, LastAttempt: time.Now().UTC()}
+	harness.storer.Users["test@test.com"] = user
+
+	r := mocks.Request("GET")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	w := httptest.NewRecorder()
+
+	handled, err := harness.lock.AfterAuthFail(w, r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !handled {
+		t.Error("the user should have been locked")
+	}
+
+	if len(user.UnlockSelector) == 0 {
+		t.Error("unlock creds should have been stored")
+	}
+	if to := harness.mailer.Email.To; len(to) != 1 || to[0] != "test@test.com" {
+		t.Error("unlock e-mail was not sent:", to)
+	}
+	if !strings.Contains(harness.redirector.Options.Failure, "check your e-mail") {
+		t.Error("locked message should mention the e-mail:", harness.redirector.Options.Failure)
+	}
+
+	// Failing again while locked must not send another e-mail
+	harness.mailer.Email = authboss.Email{}
+	selector := user.UnlockSelector
+	if _, err := harness.lock.AfterAuthFail(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(harness.mailer.Email.To) != 0 {
+		t.Error("no e-mail should be sent while already locked")
+	}
+	if user.UnlockSelector != selector {
+		t.Error("unlock token should not have changed")
+	}
+}
@@ -21,12 +21,23 @@ type User struct {
 	RecoverSelector    string
 	RecoverVerifier    string
 	RecoverTokenExpiry time.Time
+	RecoverLastSent    time.Time
+	RecoverAttempts    int
+	RecoverPhoneOK     bool
 	ConfirmSelector    string
 	ConfirmVerifier    string
 	Confirmed          bool
+	ConfirmLastSent    time.Time
+	ConfirmTokenExpiry time.Time
+	ConfirmAttempts    int
+	Created            time.Time
 	AttemptCount       int
 	LastAttempt        time.Time
 	Locked             time.Time
+	LockCount          int
+	UnlockSelector     string
+	UnlockVerifier     string
+	UnlockTokenExpiry  time.Time
 
 	OAuth2UID      string
 	OAuth2Provider string
@@ -42,6 +53,13 @@ type User struct {
 
 	SMSPhoneNumberSeed string
 
+	SecurityStamp string
+
+	Invitation authboss.Invitation
+
+	ConsentVersion string
+	ConsentedAt    time.Time
+
 	Arbitrary map[string]string
 }
 
@@ -63,6 +81,15 @@ func (u User) GetRecoverSelector() string { return u.RecoverSelector }
 // GetRecoverVerifier from user
 func (u User) GetRecoverVerifier() string { return u.RecoverVerifier }
 
+// GetRecoverLastSent from user
+func (u User) GetRecoverLastSent() time.Time { return u.RecoverLastSent }
+
+// GetRecoverAttempts from user
+func (u User) GetRecoverAttempts() int { return u.RecoverAttempts }
+
+// GetRecoverPhoneVerified from user
+func (u User) GetRecoverPhoneVerified() bool { return u.RecoverPhoneOK }
+
 // GetRecoverExpiry from user
 func (u User) GetRecoverExpiry() time.Time { return u.RecoverTokenExpiry }
 
@@ -75,6 +102,18 @@ func (u User) GetConfirmVerifier() string { return u.ConfirmVerifier }
 // GetConfirmed from user
 func (u User) GetConfirmed() bool { return u.Confirmed }
 
+// GetCreatedAt from user
+func (u User) GetCreatedAt() time.Time { return u.Created }
+
+// GetConfirmExpiry from user
+func (u User) GetConfirmExpiry() time.Time { return u.ConfirmTokenExpiry }
+
+// GetConfirmAttempts from user
+func (u User) GetConfirmAttempts() int { return u.ConfirmAttempts }
+
+// GetConfirmLastSent from user
+func (u User) GetConfirmLastSent() time.Time { return u.ConfirmLastSent }
+
 // GetAttemptCount from user
 func (u User) GetAttemptCount() int { return u.AttemptCount }
 
@@ -84,6 +123,18 @@ func (u User) GetLastAttempt() time.Time { return u.LastAttempt }
 // GetLocked from user
 func (u User) GetLocked() time.Time { return u.Locked }
 
+// GetLockCount from user
+func (u User) GetLockCount() int { return u.LockCount }
+
+// GetUnlockSelector from user
+func (u User) GetUnlockSelector() string { return u.UnlockSelector }
+
+// GetUnlockVerifier from user
+func (u User) GetUnlockVerifier() string { return u.UnlockVerifier }
+
+// GetUnlockExpiry from user
+func (u User) GetUnlockExpiry() time.Time { return u.UnlockTokenExpiry }
+
 // IsOAuth2User returns true if the user is an oauth2 user
 func (u User) IsOAuth2User() bool { return len(u.OAuth2Provider) != 0 }
 
@@ -105,6 +156,15 @@ func (u User) GetOAuth2Expiry() time.Time { return u.OAuth2Expiry }
 // GetArbitrary from user
 func (u User) GetArbitrary() map[string]string { return u.Arbitrary }
 
+// GetSecurityStamp from user
+func (u User) GetSecurityStamp() string { return u.SecurityStamp }
+
+// GetConsentVersion from user
+func (u User) GetConsentVersion() string { return u.ConsentVersion }
+
+// GetConsentedAt from user
+func (u User) GetConsentedAt() time.Time { return u.ConsentedAt }
+
 // GetOTPs from user
 func (u User) GetOTPs() string { return u.OTPs }
 
@@ -141,6 +201,15 @@ func (u *User) PutRecoverSelector(recoverSelector string) { u.RecoverSelector =
 // PutRecoverVerifier into user
 func (u *User) PutRecoverVerifier(recoverVerifier string) { u.RecoverVerifier = recoverVerifier }
 
+// PutRecoverLastSent into user
+func (u *User) PutRecoverLastSent(sent time.Time) { u.RecoverLastSent = sent }
+
+// PutRecoverAttempts into user
+func (u *User) PutRecoverAttempts(attempts int) { u.RecoverAttempts = attempts }
+
+// PutRecoverPhoneVerified into user
+func (u *User) PutRecoverPhoneVerified(verified bool) { u.RecoverPhoneOK = verified }
+
 // PutRecoverExpiry into user
 func (u *User) PutRecoverExpiry(recoverTokenExpiry time.Time) {
 	u.RecoverTokenExpiry = recoverTokenExpiry
@@ -155,6 +224,18 @@ func (u *User) PutConfirmVerifier(confirmVerifier string) { u.ConfirmVerifier =
 // PutConfirmed into user
 func (u *User) PutConfirmed(confirmed bool) { u.Confirmed = confirmed }
 
+// PutCreatedAt into user
+func (u *User) PutCreatedAt(created time.Time) { u.Created = created }
+
+// PutConfirmExpiry into user
+func (u *User) PutConfirmExpiry(expiry time.Time) { u.ConfirmTokenExpiry = expiry }
+
+// PutConfirmAttempts into user
+func (u *User) PutConfirmAttempts(attempts int) { u.ConfirmAttempts = attempts }
+
+// PutConfirmLastSent into user
+func (u *User) PutConfirmLastSent(sent time.Time) { u.ConfirmLastSent = sent }
+
 // PutAttemptCount into user
 func (u *User) PutAttemptCount(attemptCount int) { u.AttemptCount = attemptCount }
 
@@ -164,6 +245,18 @@ func (u *User) PutLastAttempt(attemptTime time.Time) { u.LastAttempt = attemptTi
 // PutLocked into user
 func (u *User) PutLocked(locked time.Time) { u.Locked = locked }
 
+// PutLockCount into user
+func (u *User) PutLockCount(count int) { u.LockCount = count }
+
+// PutUnlockSelector into user
+func (u *User) PutUnlockSelector(unlockSelector string) { u.UnlockSelector = unlockSelector }
+
+// PutUnlockVerifier into user
+func (u *User) PutUnlockVerifier(unlockVerifier string) { u.UnlockVerifier = unlockVerifier }
+
+// PutUnlockExpiry into user
+func (u *User) PutUnlockExpiry(unlockTokenExpiry time.Time) { u.UnlockTokenExpiry = unlockTokenExpiry }
+
 // PutOAuth2UID into user
 func (u *User) PutOAuth2UID(uid string) { u.OAuth2UID = uid }
 
@@ -182,6 +275,18 @@ func (u *User) PutOAuth2Expiry(expiry time.Time) { u.OAuth2Expiry = expiry }
 // PutArbitrary into user
 func (u *User) PutArbitrary(arb map[string]string) { u.Arbitrary = arb }
 
+// PutSecurityStamp into user
+func (u *User) PutSecurityStamp(stamp string) { u.SecurityStamp = stamp }
+
+// PutInvitation into user
+func (u *User) PutInvitation(invitation authboss.Invitation) { u.Invitation = invitation }
+
+// PutConsentVersion into user
+func (u *User) PutConsentVersion(version string) { u.ConsentVersion = version }
+
+// PutConsentedAt into user
+func (u *User) PutConsentedAt(consentedAt time.Time) { u.ConsentedAt = consentedAt }
+
 // PutOTPs into user
 func (u *User) PutOTPs(otps string) { u.OTPs = otps }
 
@@ -199,15 +304,19 @@ func (u *User) PutRecoveryCodes(codes string) { u.RecoveryCodes = codes }
 
 // ServerStorer should be valid for any module storer defined in authboss.
 type ServerStorer struct {
-	Users    map[string]*User
-	RMTokens map[string][]string
+	Users       map[string]*User
+	RMTokens    map[string][]string
+	Invitations map[string]authboss.Invitation
+	Identities  map[string]authboss.OAuth2Identity
 }
 
 // NewServerStorer constructor
 func NewServerStorer() *ServerStorer {
 	return &ServerStorer{
-		Users:    make(map[string]*User),
-		RMTokens: make(map[string][]string),
+		Users:       make(map[string]*User),
+		RMTokens:    make(map[string][]string),
+		Invitations: make(map[string]authboss.Invitation),
+		Identities:  make(map[string]authboss.OAuth2Identity),
 	}
 }
 
@@ -290,6 +399,19 @@ func (s *ServerStorer) LoadByConfirmSelector(ctx context.Context, selector strin
 	return nil, authboss.ErrUserNotFound
 }
 
+// PurgeUnconfirmed users created before the given time
+func (s *ServerStorer) PurgeUnconfirmed(ctx context.Context, before time.Time) (int, error) {
+	deleted := 0
+	for key, u := range s.Users {
+		if !u.Confirmed && u.Created.Before(before) {
+			delete(s.Users, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
 // LoadByRecoverSelector finds a user by his recover token
 func (s *ServerStorer) LoadByRecoverSelector(ctx context.Context, selector string) (authboss.RecoverableUser, error) {
 	for _, v := range s.Users {
@@ -301,6 +423,17 @@ func (s *ServerStorer) LoadByRecoverSelector(ctx context.Context, selector strin
 	return nil, authboss.ErrUserNotFound
 }
 
+// LoadByUnlockSelector finds a user by his unlock token
+func (s *ServerStorer) LoadByUnlockSelector(ctx context.Context, selector string) (authboss.UnlockableUser, error) {
+	for _, v := range s.Users {
+		if v.UnlockSelector == selector {
+			return v, nil
+		}
+	}
+
+	return nil, authboss.ErrUserNotFound
+}
+
 // AddRememberToken for remember me
 func (s *ServerStorer) AddRememberToken(ctx context.Context, key, token string) error {
 	arr := s.RMTokens[key]
@@ -337,6 +470,112 @@ func (s *ServerStorer) UseRememberToken(ctx context.Context, givenKey, token str
 	return authboss.ErrTokenNotFound
 }
 
+// PutInvitation by its selector
+func (s *ServerStorer) PutInvitation(ctx context.Context, invitation authboss.Invitation) error {
+	s.Invitations[invitation.Selector] = invitation
+	return nil
+}
+
+// LoadInvitation by its selector
+func (s *ServerStorer) LoadInvitation(ctx context.Context, selector string) (authboss.Invitation, error) {
+	invitation, ok := s.Invitations[selector]
+	if !ok {
+		return authboss.Invitation{}, authboss.ErrTokenNotFound
+	}
+
+	return invitation, nil
+}
+
+// DelInvitation by its selector
+func (s *ServerStorer) DelInvitation(ctx context.Context, selector string) error {
+	if _, ok := s.Invitations[selector]; !ok {
+		return authboss.ErrTokenNotFound
+	}
+	delete(s.Invitations, selector)
+	return nil
+}
+
+// PutOAuth2Identity by its provider and uid
+func (s *ServerStorer) PutOAuth2Identity(ctx context.Context, identity authboss.OAuth2Identity) error {
+	s.Identities[identity.Provider+":"+identity.UID] = identity
+	return nil
+}
+
+// LoadOAuth2Identity by its provider and uid
+func (s *ServerStorer) LoadOAuth2Identity(ctx context.Context, provider, uid string) (authboss.OAuth2Identity, error) {
+	identity, ok := s.Identities[provider+":"+uid]
+	if !ok {
+		return authboss.OAuth2Identity{}, authboss.ErrUserNotFound
+	}
+
+	return identity, nil
+}
+
+// ListOAuth2Identities linked to the user
+func (s *ServerStorer) ListOAuth2Identities(ctx context.Context, pid string) ([]authboss.OAuth2Identity, error) {
+	var identities []authboss.OAuth2Identity
+	for _, identity := range s.Identities {
+		if identity.PID == pid {
+			identities = append(identities, identity)
+		}
+	}
+
+	return identities, nil
+}
+
+// DelOAuth2Identity by its provider and uid
+func (s *ServerStorer) DelOAuth2Identity(ctx context.Context, provider, uid string) error {
+	delete(s.Identities, provider+":"+uid)
+	return nil
+}
+
+// SeriesServerStorer is a ServerStorer that also stores remember me series
+type SeriesServerStorer struct {
+	*ServerStorer
+	RMSeries map[string]authboss.RememberSeries
+}
+
+// NewSeriesServerStorer constructor
+func NewSeriesServerStorer() *SeriesServerStorer {
+	return &SeriesServerStorer{
+		ServerStorer: NewServerStorer(),
+		RMSeries:     make(map[string]authboss.RememberSeries),
+	}
+}
+
+// PutRememberSeries creates or replaces a series
+func (s *SeriesServerStorer) PutRememberSeries(ctx context.Context, series authboss.RememberSeries) error {
+	s.RMSeries[series.PID+";"+series.Series] = series
+	return nil
+}
+
+// LoadRememberSeries if it exists
+func (s *SeriesServerStorer) LoadRememberSeries(ctx context.Context, pid, series string) (authboss.RememberSeries, error) {
+	stored, ok := s.RMSeries[pid+";"+series]
+	if !ok {
+		return authboss.RememberSeries{}, authboss.ErrTokenNotFound
+	}
+
+	return stored, nil
+}
+
+// DelRememberSeries deletes a single series
+func (s *SeriesServerStorer) DelRememberSeries(ctx context.Context, pid, series string) error {
+	delete(s.RMSeries, pid+";"+series)
+	return nil
+}
+
+// DelRememberTokens for a user, including their series
+func (s *SeriesServerStorer) DelRememberTokens(ctx context.Context, key string) error {
+	for k, series := range s.RMSeries {
+		if series.PID == key {
+			delete(s.RMSeries, k)
+		}
+	}
+
+	return s.ServerStorer.DelRememberTokens(ctx, key)
+}
+
 // FailStorer is used for testing module initialize functions that
 // recover more than the base storer
 type FailStorer struct {
@@ -571,6 +810,25 @@ func (r *Redirector) Redirect(w http.ResponseWriter, req *http.Request, ro authb
 	return nil
 }
 
+// ChallengeVerifier issues Challenge and accepts Response
+type ChallengeVerifier struct {
+	Challenge string
+	Response  string
+
+	Verified []string
+}
+
+// NewChallenge returns Challenge
+func (c *ChallengeVerifier) NewChallenge(ctx context.Context) (string, error) {
+	return c.Challenge, nil
+}
+
+// Verify records the response and checks it matches Response
+func (c *ChallengeVerifier) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	c.Verified = append(c.Verified, response)
+	return response == c.Response, nil
+}
+
 // Emailer that holds the options it was given
 type Emailer struct {
 	Email authboss.Email
@@ -600,6 +858,12 @@ type Values struct {
 	Code        string
 	Recovery    string
 	PhoneNumber string
+	Challenge   string
+	Selector    string
+	Channel     string
+	Email       string
+	InviteToken string
+	Accepted    bool
 	Remember    bool
 
 	Errors []error
@@ -610,6 +874,16 @@ func (v Values) GetPID() string {
 	return v.PID
 }
 
+// GetSelector from values
+func (v Values) GetSelector() string {
+	return v.Selector
+}
+
+// GetChannel from values
+func (v Values) GetChannel() string {
+	return v.Channel
+}
+
 // GetPassword from values
 func (v Values) GetPassword() string {
 	return v.Password
@@ -635,6 +909,26 @@ func (v Values) GetRecoveryCode() string {
 	return v.Recovery
 }
 
+// GetChallengeResponse from values
+func (v Values) GetChallengeResponse() string {
+	return v.Challenge
+}
+
+// GetEmail from values
+func (v Values) GetEmail() string {
+	return v.Email
+}
+
+// GetInviteToken from values
+func (v Values) GetInviteToken() string {
+	return v.InviteToken
+}
+
+// GetAccepted from values
+func (v Values) GetAccepted() bool {
+	return v.Accepted
+}
+
 // GetShouldRemember gets the value that tells
 // the remember module if it should remember the user
 func (v Values) GetShouldRemember() bool {
@@ -31,18 +31,22 @@ type mockUser struct {
 	OAuth2Expiry   time.Time
 
 	Arbitrary map[string]string
+
+	SecurityStamp string
 }
 
 func newMockServerStorer() *mockServerStorer {
 	return &mockServerStorer{
-		Users:  make(map[string]*mockUser),
-		Tokens: make(map[string][]string),
+		Users:      make(map[string]*mockUser),
+		Tokens:     make(map[string][]string),
+		Identities: make(map[string]OAuth2Identity),
 	}
 }
 
 type mockServerStorer struct {
-	Users  map[string]*mockUser
-	Tokens map[string][]string
+	Users      map[string]*mockUser
+	Tokens     map[string][]string
+	Identities map[string]OAuth2Identity
 }
 
 func (m *mockServerStorer) Load(ctx context.Context, key string) (User, error) {
@@ -100,7 +104,29 @@ func (m *mockServerStorer) LoadByConfirmSelector(ctx context.Context, selector s
 func (m *mockServerStorer) LoadByRecoverSelector(ctx context.Context, selector string) (RecoverableUser, error) {
 	panic("not impl")
 }
-func (m *mockServerStorer) SaveOAuth2(ctx context.Context, user OAuth2User) error { panic("not impl") }
+func (m *mockServerStorer) SaveOAuth2(ctx context.Context, user OAuth2User) error {
+	u := user.(*mockUser)
+	m.Users[MakeOAuth2PID(u.OAuth2Provider, u.OAuth2UID)] = u
+
+	return nil
+}
+func (m *mockServerStorer) PutOAuth2Identity(ctx context.Context, identity OAuth2Identity) error {
+	m.Identities[identity.Provider+":"+identity.UID] = identity
+	return nil
+}
+func (m *mockServerStorer) LoadOAuth2Identity(ctx context.Context, provider, uid string) (OAuth2Identity, error) {
+	identity, ok := m.Identities[provider+":"+uid]
+	if !ok {
+		return OAuth2Identity{}, ErrUserNotFound
+	}
+	return identity, nil
+}
+func (m *mockServerStorer) ListOAuth2Identities(ctx context.Context, pid string) ([]OAuth2Identity, error) {
+	panic("not impl")
+}
+func (m *mockServerStorer) DelOAuth2Identity(ctx context.Context, provider, uid string) error {
+	panic("not impl")
+}
 
 func (m mockUser) GetPID() string                             { return m.Email }
 func (m mockUser) GetEmail() string                           { return m.Email }
@@ -122,6 +148,7 @@ func (m mockUser) GetOAuth2AccessToken() string               { return m.OAuth2T
 func (m mockUser) GetOAuth2RefreshToken() string              { return m.OAuth2Refresh }
 func (m mockUser) GetOAuth2Expiry() time.Time                 { return m.OAuth2Expiry }
 func (m mockUser) GetArbitrary() map[string]string            { return m.Arbitrary }
+func (m mockUser) GetSecurityStamp() string                   { return m.SecurityStamp }
 func (m *mockUser) PutPID(email string)                       { m.Email = email }
 func (m *mockUser) PutUsername(username string)               { m.Username = username }
 func (m *mockUser) PutEmail(email string)                     { m.Email = email }
@@ -141,6 +168,7 @@ func (m *mockUser) PutOAuth2AccessToken(token string)         { m.OAuth2Token =
 func (m *mockUser) PutOAuth2RefreshToken(refresh string)      { m.OAuth2Refresh = refresh }
 func (m *mockUser) PutOAuth2Expiry(expiry time.Time)          { m.OAuth2Expiry = expiry }
 func (m *mockUser) PutArbitrary(arb map[string]string)        { m.Arbitrary = arb }
+func (m *mockUser) PutSecurityStamp(stamp string)             { m.SecurityStamp = stamp }
 
 type mockClientStateReadWriter struct {
 	state mockClientState
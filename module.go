@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"reflect"
+	"sort"
 )
 
 var registeredModules = make(map[string]Moduler)
@@ -20,7 +21,9 @@ func RegisterModule(name string, m Moduler) {
 	registeredModules[name] = m
 }
 
-// RegisteredModules returns a list of modules that are currently registered.
+// RegisteredModules returns a sorted list of modules that are currently
+// registered. Init loads modules in this order, to order the event handlers
+// of different modules see Events.BeforePriority and Events.AfterPriority.
 func RegisteredModules() []string {
 	mods := make([]string, len(registeredModules))
 	i := 0
@@ -29,6 +32,7 @@ func RegisteredModules() []string {
 		i++
 	}
 
+	sort.Strings(mods)
 	return mods
 }
 
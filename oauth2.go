@@ -2,8 +2,12 @@ package authboss
 
 import (
 	"context"
+	"hash/fnv"
+	"net/http"
 	"net/url"
+	"sync"
 
+	"github.com/friendsofgo/errors"
 	"golang.org/x/oauth2"
 )
 
@@ -23,9 +27,191 @@ authenticated token to be created, a call is then made to a known endpoint that
 return details about the user we've retrieved the token for. Those details are returned
 as a map[string]string and subsequently passed into OAuth2ServerStorer.NewFromOAuth2.
 API this must be handled for each provider separately.
+
+PKCE (RFC 7636) is used for every provider with an S256 code challenge, set
+DisablePKCE for providers that reject the extra parameters.
+
+Nonce sends a random nonce in the initial request which FindUserDetails can
+retrieve from the context with CTXKeyOAuth2Nonce in order to check it against
+the one in the ID token. It's always sent when the scopes include openid.
 */
 type OAuth2Provider struct {
 	OAuth2Config     *oauth2.Config
 	AdditionalParams url.Values
 	FindUserDetails  func(context.Context, oauth2.Config, *oauth2.Token) (map[string]string, error)
+
+	DisablePKCE bool
+	Nonce       bool
+}
+
+// OAuth2Client creates an http client that makes requests with the user's
+// access token for the provider they logged in with, see OAuth2TokenSource.
+func (a *Authboss) OAuth2Client(ctx context.Context, user OAuth2User) (*http.Client, error) {
+	source, err := a.OAuth2TokenSource(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return oauth2.NewClient(ctx, source), nil
+}
+
+// OAuth2TokenSource returns the user's token for the provider they logged in
+// with. Once it expires it's refreshed with the provider's config in
+// Modules.OAuth2Providers and the new token is saved with SaveOAuth2.
+//
+// Only one refresh at a time is made for a user. The user is loaded again
+// by their pid before refreshing, so that a token another request refreshed
+// in the meantime is used rather than refreshed again. This only holds
+// within this process, the storer must take care of it across processes.
+func (a *Authboss) OAuth2TokenSource(ctx context.Context, user OAuth2User) (oauth2.TokenSource, error) {
+	provider := user.GetOAuth2Provider()
+	cfg, ok := a.Config.Modules.OAuth2Providers[provider]
+	if !ok {
+		return nil, errors.Errorf("oauth2 provider %q not found", provider)
+	}
+
+	pid := user.GetPID()
+	source := &oauth2TokenSource{
+		ctx:     ctx,
+		config:  cfg.OAuth2Config,
+		lockKey: pid,
+		load: func() (*oauth2.Token, error) {
+			stored, err := a.Config.Storage.Server.Load(ctx, pid)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to load oauth2 user to refresh their token")
+			}
+			if storedUser, ok := stored.(OAuth2User); ok {
+				user = storedUser
+			}
+			return oauth2UserToken(user), nil
+		},
+		save: func(token *oauth2.Token) error {
+			user.PutOAuth2AccessToken(token.AccessToken)
+			user.PutOAuth2Expiry(token.Expiry)
+			if len(token.RefreshToken) != 0 {
+				user.PutOAuth2RefreshToken(token.RefreshToken)
+			}
+			return EnsureCanOAuth2(a.Config.Storage.Server).SaveOAuth2(ctx, user)
+		},
+	}
+	return oauth2.ReuseTokenSource(oauth2UserToken(user), source), nil
+}
+
+// OAuth2IdentityClient is OAuth2Client for an identity linked to an
+// account, see OAuth2IdentityTokenSource.
+func (a *Authboss) OAuth2IdentityClient(ctx context.Context, identity OAuth2Identity) (*http.Client, error) {
+	source, err := a.OAuth2IdentityTokenSource(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return oauth2.NewClient(ctx, source), nil
+}
+
+// OAuth2IdentityTokenSource is OAuth2TokenSource for an identity linked to
+// an account. The tokens of linked identities are kept on the identity
+// rather than the account's user, so it's loaded again with
+// LoadOAuth2Identity before refreshing and the new token is saved with
+// PutOAuth2Identity.
+func (a *Authboss) OAuth2IdentityTokenSource(ctx context.Context, identity OAuth2Identity) (oauth2.TokenSource, error) {
+	cfg, ok := a.Config.Modules.OAuth2Providers[identity.Provider]
+	if !ok {
+		return nil, errors.Errorf("oauth2 provider %q not found", identity.Provider)
+	}
+
+	storer := EnsureCanLinkOAuth2(a.Config.Storage.Server)
+	source := &oauth2TokenSource{
+		ctx:     ctx,
+		config:  cfg.OAuth2Config,
+		lockKey: MakeOAuth2PID(identity.Provider, identity.UID),
+		load: func() (*oauth2.Token, error) {
+			stored, err := storer.LoadOAuth2Identity(ctx, identity.Provider, identity.UID)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to load oauth2 identity to refresh its token")
+			}
+			identity = stored
+			return identity.Token(), nil
+		},
+		save: func(token *oauth2.Token) error {
+			identity.PutToken(token)
+			return storer.PutOAuth2Identity(ctx, identity)
+		},
+	}
+	return oauth2.ReuseTokenSource(identity.Token(), source), nil
+}
+
+// oauth2RefreshLocks serialize refreshing tokens, users and identities are
+// spread over them by their pid
+var oauth2RefreshLocks [64]sync.Mutex
+
+func oauth2RefreshLock(pid string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(pid))
+	return &oauth2RefreshLocks[h.Sum32()%uint32(len(oauth2RefreshLocks))]
+}
+
+// oauth2TokenSource refreshes a token that's loaded and saved through the
+// storer, load is called again before each refresh
+type oauth2TokenSource struct {
+	ctx     context.Context
+	config  *oauth2.Config
+	lockKey string
+
+	load func() (*oauth2.Token, error)
+	save func(*oauth2.Token) error
+}
+
+// Token is only called by the oauth2.ReuseTokenSource once the token it has
+// is no longer valid
+func (o *oauth2TokenSource) Token() (*oauth2.Token, error) {
+	lock := oauth2RefreshLock(o.lockKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Another request may have refreshed it already
+	token, err := o.load()
+	if err != nil {
+		return nil, err
+	}
+	if token.Valid() {
+		return token, nil
+	}
+
+	token, err = o.config.TokenSource(o.ctx, token).Token()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to refresh oauth2 token")
+	}
+
+	if err := o.save(token); err != nil {
+		return nil, errors.Wrap(err, "failed to save refreshed oauth2 token")
+	}
+
+	return token, nil
+}
+
+// Token returns the identity's token at the provider
+func (o OAuth2Identity) Token() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  o.AccessToken,
+		RefreshToken: o.RefreshToken,
+		Expiry:       o.Expiry,
+	}
+}
+
+// PutToken stores a token from the provider on the identity, the refresh
+// token is kept if the new token doesn't have one
+func (o *OAuth2Identity) PutToken(token *oauth2.Token) {
+	o.AccessToken = token.AccessToken
+	o.Expiry = token.Expiry
+	if len(token.RefreshToken) != 0 {
+		o.RefreshToken = token.RefreshToken
+	}
+}
+
+func oauth2UserToken(user OAuth2User) *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  user.GetOAuth2AccessToken(),
+		RefreshToken: user.GetOAuth2RefreshToken(),
+		Expiry:       user.GetOAuth2Expiry(),
+	}
 }
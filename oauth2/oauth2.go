@@ -38,6 +38,7 @@ package oauth2
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -48,6 +49,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/friendsofgo/errors"
 	"golang.org/x/oauth2"
@@ -63,6 +65,8 @@ const (
 
 var (
 	errOAuthStateValidation = errors.New("could not validate oauth2 state param")
+	errOAuthVerifierMissing = errors.New("oauth2 endpoint hit without pkce verifier")
+	errOAuthLinkUser        = errors.New("oauth2 link finished by a different user than started it")
 )
 
 // OAuth2 module
@@ -87,6 +91,17 @@ func (o *OAuth2) Init(ab *authboss.Authboss) error {
 	}
 	sort.Strings(keys)
 
+	// Linking is only possible when identities can be stored
+	_, canLink := o.Authboss.Config.Storage.Server.(authboss.OAuth2IdentityStorer)
+
+	var unauthedResponse authboss.MWRespondOnFailure
+	if o.Authboss.Config.Modules.ResponseOnUnauthed != 0 {
+		unauthedResponse = o.Authboss.Config.Modules.ResponseOnUnauthed
+	} else if o.Authboss.Config.Modules.RoutesRedirectOnUnauthed {
+		unauthedResponse = authboss.RespondRedirect
+	}
+	abmw := authboss.MountedMiddleware2(o.Authboss, true, authboss.RequireFullAuth, unauthedResponse)
+
 	for _, provider := range keys {
 		cfg := o.Authboss.Config.Modules.OAuth2Providers[provider]
 		provider = strings.ToLower(provider)
@@ -97,6 +112,14 @@ func (o *OAuth2) Init(ab *authboss.Authboss) error {
 		o.Authboss.Config.Core.Router.Get(init, o.Authboss.Core.ErrorHandler.Wrap(o.Start))
 		o.Authboss.Config.Core.Router.Get(callback, o.Authboss.Core.ErrorHandler.Wrap(o.End))
 
+		if canLink {
+			link := fmt.Sprintf("/oauth2/link/%s", provider)
+			unlink := fmt.Sprintf("/oauth2/unlink/%s", provider)
+
+			o.Authboss.Config.Core.Router.Get(link, abmw(o.Authboss.Core.ErrorHandler.Wrap(o.Link)))
+			o.Authboss.Config.Core.Router.Post(unlink, abmw(o.Authboss.Core.ErrorHandler.Wrap(o.Unlink)))
+		}
+
 		if mount := o.Authboss.Config.Paths.Mount; len(mount) > 0 {
 			callback = path.Join(mount, callback)
 		}
@@ -109,6 +132,25 @@ func (o *OAuth2) Init(ab *authboss.Authboss) error {
 
 // Start the oauth2 process
 func (o *OAuth2) Start(w http.ResponseWriter, r *http.Request) error {
+	authboss.DelSession(w, authboss.SessionOAuth2Link)
+	return o.start(w, r)
+}
+
+// Link starts the oauth2 process for the logged in user to link the
+// provider to their account, the callback links it instead of logging in.
+func (o *OAuth2) Link(w http.ResponseWriter, r *http.Request) error {
+	pid, err := o.Authboss.CurrentUserID(r)
+	if err != nil {
+		return err
+	} else if len(pid) == 0 {
+		return authboss.ErrUserNotFound
+	}
+
+	authboss.PutSession(w, authboss.SessionOAuth2Link, pid)
+	return o.start(w, r)
+}
+
+func (o *OAuth2) start(w http.ResponseWriter, r *http.Request) error {
 	logger := o.Authboss.RequestLogger(r)
 
 	provider := strings.ToLower(filepath.Base(r.URL.Path))
@@ -118,13 +160,10 @@ func (o *OAuth2) Start(w http.ResponseWriter, r *http.Request) error {
 		return errors.Errorf("oauth2 provider %q not found", provider)
 	}
 
-	// Create nonce
-	nonce := make([]byte, 32)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return errors.Wrap(err, "failed to create nonce")
+	state, err := randomString()
+	if err != nil {
+		return errors.Wrap(err, "failed to create state")
 	}
-
-	state := base64.URLEncoding.EncodeToString(nonce)
 	authboss.PutSession(w, authboss.SessionOAuth2State, state)
 
 	// This clearly ignores the fact that query parameters can have multiple
@@ -146,7 +185,35 @@ func (o *OAuth2) Start(w http.ResponseWriter, r *http.Request) error {
 		authboss.DelSession(w, authboss.SessionOAuth2Params)
 	}
 
-	authCodeUrl := cfg.OAuth2Config.AuthCodeURL(state)
+	var opts []oauth2.AuthCodeOption
+	if cfg.DisablePKCE {
+		authboss.DelSession(w, authboss.SessionOAuth2Verifier)
+	} else {
+		verifier, err := randomString()
+		if err != nil {
+			return errors.Wrap(err, "failed to create pkce verifier")
+		}
+
+		authboss.PutSession(w, authboss.SessionOAuth2Verifier, verifier)
+		opts = append(opts,
+			oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+	}
+
+	if cfg.Nonce || hasString(cfg.OAuth2Config.Scopes, ScopeOpenID) {
+		nonce, err := randomString()
+		if err != nil {
+			return errors.Wrap(err, "failed to create nonce")
+		}
+
+		authboss.PutSession(w, authboss.SessionOAuth2Nonce, nonce)
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	} else {
+		authboss.DelSession(w, authboss.SessionOAuth2Nonce)
+	}
+
+	authCodeUrl := cfg.OAuth2Config.AuthCodeURL(state, opts...)
 
 	extraParams := cfg.AdditionalParams.Encode()
 	if len(extraParams) > 0 {
@@ -196,41 +263,79 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	nonce, _ := authboss.GetSession(r, authboss.SessionOAuth2Nonce)
+	verifier, _ := authboss.GetSession(r, authboss.SessionOAuth2Verifier)
+	linkPID, linking := authboss.GetSession(r, authboss.SessionOAuth2Link)
+
 	authboss.DelSession(w, authboss.SessionOAuth2State)
 	authboss.DelSession(w, authboss.SessionOAuth2Params)
+	authboss.DelSession(w, authboss.SessionOAuth2Nonce)
+	authboss.DelSession(w, authboss.SessionOAuth2Verifier)
+	authboss.DelSession(w, authboss.SessionOAuth2Link)
 
 	hasErr := r.FormValue("error")
 	if len(hasErr) > 0 {
 		reason := r.FormValue("error_reason")
 		logger.Infof("oauth2 login failed: %s, reason: %s", hasErr, reason)
-
-		handled, err := o.Authboss.Events.FireAfter(authboss.EventOAuth2Fail, w, r)
-		if err != nil {
-			return err
-		} else if handled {
-			return nil
-		}
-
-		ro := authboss.RedirectOptions{
-			Code:         http.StatusTemporaryRedirect,
-			RedirectPath: o.Authboss.Config.Paths.OAuth2LoginNotOK,
-			Failure:      fmt.Sprintf("%s login cancelled or failed", strings.Title(provider)),
-		}
-		return o.Authboss.Core.Redirector.Redirect(w, r, ro)
+		return o.fail(w, r, fmt.Sprintf("%s login cancelled or failed", strings.Title(provider)))
 	}
 
 	// Get the code which we can use to make an access token
 	code := r.FormValue("code")
-	token, err := exchanger(cfg.OAuth2Config, r.Context(), code)
+	var opts []oauth2.AuthCodeOption
+	if !cfg.DisablePKCE {
+		if len(verifier) == 0 {
+			return errOAuthVerifierMissing
+		}
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", verifier))
+	}
+
+	token, err := exchanger(cfg.OAuth2Config, r.Context(), code, opts...)
 	if err != nil {
 		return errors.Wrap(err, "could not validate oauth2 code")
 	}
 
-	details, err := cfg.FindUserDetails(r.Context(), *cfg.OAuth2Config, token)
+	ctx := r.Context()
+	if len(nonce) != 0 {
+		ctx = context.WithValue(ctx, authboss.CTXKeyOAuth2Nonce, nonce)
+	}
+
+	details, err := cfg.FindUserDetails(ctx, *cfg.OAuth2Config, token)
 	if err != nil {
 		return err
 	}
 
+	if linking {
+		return o.link(w, r, provider, linkPID, details, token, params)
+	}
+
+	// Identities linked to an account log in to that account, their token
+	// is kept on the identity rather than the account's user
+	if identities, ok := o.Authboss.Config.Storage.Server.(authboss.OAuth2IdentityStorer); ok {
+		identity, err := identities.LoadOAuth2Identity(r.Context(), provider, details[OAuth2UID])
+		if err == nil {
+			user, err := o.Authboss.Config.Storage.Server.Load(r.Context(), identity.PID)
+			if err != nil {
+				return errors.Wrap(err, "failed to load the user an oauth2 identity is linked to")
+			}
+
+			identity.PutToken(token)
+			if err := identities.PutOAuth2Identity(r.Context(), identity); err != nil {
+				return err
+			}
+
+			logger.Infof("oauth2 identity %s:%s is linked to user %s", provider, identity.UID, identity.PID)
+			return o.login(w, r, provider, identity.PID, user, params)
+		} else if err != authboss.ErrUserNotFound {
+			return err
+		}
+	}
+
+	if err := o.Authboss.CheckEmailDomain(details[OAuth2Email]); err != nil {
+		logger.Infof("oauth2 login with disallowed e-mail address %q: %v", details[OAuth2Email], err)
+		return o.fail(w, r, err.Error())
+	}
+
 	storer := authboss.EnsureCanOAuth2(o.Authboss.Config.Storage.Server)
 	user, err := storer.NewFromOAuth2(r.Context(), provider, details)
 	if err != nil {
@@ -248,21 +353,18 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
-
-	handled, err := o.Authboss.Events.FireBefore(authboss.EventOAuth2, w, r)
-	if err != nil {
-		return err
-	} else if handled {
-		return nil
-	}
+	return o.login(w, r, provider, authboss.MakeOAuth2PID(provider, user.GetOAuth2UID()), user, params)
+}
 
-	// Fully log user in
-	authboss.PutSession(w, authboss.SessionKey, authboss.MakeOAuth2PID(provider, user.GetOAuth2UID()))
-	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+// login logs the user in with the pid and redirects, params are those
+// passed along from the start of the oauth2 process
+func (o *OAuth2) login(w http.ResponseWriter, r *http.Request, provider, pid string, user authboss.User, params map[string]string) error {
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
 
 	// Create a query string from all the pieces we've received
-	// as passthru from the original request.
+	// as passthru from the original request. Remember is put in the
+	// context first so handlers that interrupt the login (like consent's)
+	// can keep it for later.
 	redirect := o.Authboss.Config.Paths.OAuth2LoginOK
 	query := make(url.Values)
 	for k, v := range params {
@@ -272,12 +374,49 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 				r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, RMTrue{}))
 			}
 		case FormValueOAuth2Redir:
-			redirect = v
+			if o.Authboss.Config.Paths.RedirectPolicy.Allowed(v) {
+				redirect = v
+			}
 		default:
 			query.Set(k, v)
 		}
 	}
 
+	handled, err := o.Authboss.Events.FireBefore(authboss.EventOAuth2, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	// Identities linked to an account with a password log in to it, so it
+	// still needs its second factor like logging in with the password does.
+	// The second factor is passed the query of the login's redirect instead
+	// of the callback's, which has the code and state in it.
+	if _, _, err := authboss.ParseOAuth2PID(pid); err != nil {
+		hijackQuery := make(url.Values)
+		for k, v := range query {
+			hijackQuery[k] = v
+		}
+		if redirect != o.Authboss.Config.Paths.OAuth2LoginOK {
+			hijackQuery.Set(authboss.FormValueRedirect, redirect)
+		}
+
+		hr := r.Clone(r.Context())
+		hr.URL.RawQuery = hijackQuery.Encode()
+		handled, err = o.Authboss.Events.FireBefore(authboss.EventAuthHijack, w, hr)
+		if err != nil {
+			return err
+		} else if handled {
+			return nil
+		}
+	}
+
+	// Fully log user in
+	authboss.PutSession(w, authboss.SessionKey, pid)
+	authboss.PutSessionSecurityStamp(w, user)
+	authboss.DelSession(w, authboss.SessionHalfAuthKey)
+
 	handled, err = o.Authboss.Events.FireAfter(authboss.EventOAuth2, w, r)
 	if err != nil {
 		return err
@@ -297,6 +436,143 @@ func (o *OAuth2) End(w http.ResponseWriter, r *http.Request) error {
 	return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
 }
 
+// link links the identity at the provider to the user that started linking
+func (o *OAuth2) link(w http.ResponseWriter, r *http.Request, provider, pid string, details map[string]string, token *oauth2.Token, params map[string]string) error {
+	logger := o.Authboss.RequestLogger(r)
+
+	// Someone else may have logged in since linking started
+	if current, err := o.Authboss.CurrentUserID(r); err != nil {
+		return err
+	} else if current != pid {
+		return errOAuthLinkUser
+	}
+
+	redirect := o.Authboss.Config.Paths.OAuth2LinkOK
+	if redir := params[FormValueOAuth2Redir]; o.Authboss.Config.Paths.RedirectPolicy.Allowed(redir) {
+		redirect = redir
+	}
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: redirect,
+	}
+
+	uid := details[OAuth2UID]
+	storer := authboss.EnsureCanLinkOAuth2(o.Authboss.Config.Storage.Server)
+
+	identity, err := storer.LoadOAuth2Identity(r.Context(), provider, uid)
+	switch {
+	case err == nil && identity.PID == pid:
+		ro.Success = fmt.Sprintf("Your %s account is already linked.", strings.Title(provider))
+		return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+	case err == nil:
+		logger.Infof("user %s tried to link oauth2 identity %s:%s of user %s", pid, provider, uid, identity.PID)
+		ro.Failure = fmt.Sprintf("That %s account is already linked to another user.", strings.Title(provider))
+		return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+	case err != authboss.ErrUserNotFound:
+		return err
+	}
+
+	// Linking an identity that has its own account would lock them out of it
+	_, err = storer.Load(r.Context(), authboss.MakeOAuth2PID(provider, uid))
+	switch {
+	case err == nil:
+		logger.Infof("user %s tried to link oauth2 identity %s:%s which has its own account", pid, provider, uid)
+		ro.Failure = fmt.Sprintf("That %s account is already used by another user.", strings.Title(provider))
+		return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+	case err != authboss.ErrUserNotFound:
+		return err
+	}
+
+	identity = authboss.OAuth2Identity{
+		Provider: provider,
+		UID:      uid,
+		PID:      pid,
+		Email:    details[OAuth2Email],
+		LinkedAt: time.Now().UTC(),
+	}
+	identity.PutToken(token)
+	if err := storer.PutOAuth2Identity(r.Context(), identity); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s linked oauth2 identity %s:%s", pid, provider, uid)
+	ro.Success = fmt.Sprintf("Your %s account has been linked.", strings.Title(provider))
+	return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// Unlink removes the logged in user's linked identities at the provider
+func (o *OAuth2) Unlink(w http.ResponseWriter, r *http.Request) error {
+	logger := o.Authboss.RequestLogger(r)
+	provider := strings.ToLower(filepath.Base(r.URL.Path))
+
+	pid, err := o.Authboss.CurrentUserID(r)
+	if err != nil {
+		return err
+	} else if len(pid) == 0 {
+		return authboss.ErrUserNotFound
+	}
+
+	storer := authboss.EnsureCanLinkOAuth2(o.Authboss.Config.Storage.Server)
+	identities, err := storer.ListOAuth2Identities(r.Context(), pid)
+	if err != nil {
+		return err
+	}
+
+	for _, identity := range identities {
+		if identity.Provider != provider {
+			continue
+		}
+
+		if err := storer.DelOAuth2Identity(r.Context(), identity.Provider, identity.UID); err != nil {
+			return err
+		}
+		logger.Infof("user %s unlinked oauth2 identity %s:%s", pid, provider, identity.UID)
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:             http.StatusTemporaryRedirect,
+		RedirectPath:     o.Authboss.Config.Paths.OAuth2LinkOK,
+		FollowRedirParam: true,
+		Success:          fmt.Sprintf("Your %s account has been unlinked.", strings.Title(provider)),
+	}
+	return o.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// fail fires EventOAuth2Fail and, unless a handler took over the request,
+// redirects to OAuth2LoginNotOK with the failure message.
+func (o *OAuth2) fail(w http.ResponseWriter, r *http.Request, failure string) error {
+	handled, err := o.Authboss.Events.FireAfter(authboss.EventOAuth2Fail, w, r)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: o.Authboss.Config.Paths.OAuth2LoginNotOK,
+		Failure:      failure,
+	}
+	return o.Authboss.Core.Redirector.Redirect(w, r, ro)
+}
+
+// randomString creates 32 random bytes encoded with unpadded base64url, which
+// also makes it a valid PKCE code verifier
+func randomString() (string, error) {
+	byt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, byt); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(byt), nil
+}
+
+// pkceChallenge is the S256 code challenge for the verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
 // RMTrue is a dummy struct implementing authboss.RememberValuer
 // in order to tell the remember me module to remember them.
 type RMTrue struct{}
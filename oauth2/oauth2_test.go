@@ -2,6 +2,9 @@ package oauth2
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -11,13 +14,18 @@ import (
 
 	"github.com/p000ic/authboss-echo"
 	"github.com/p000ic/authboss-echo/mocks"
+	"github.com/p000ic/authboss-echo/otp/twofactor/totp2fa"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/facebook"
 	"golang.org/x/oauth2/google"
 )
 
 func init() {
-	exchanger = func(_ *oauth2.Config, _ context.Context, _ string, _ ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	exchanger = func(cfg *oauth2.Config, ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+		// Providers pointed at a test server get a real exchange
+		if strings.HasPrefix(cfg.Endpoint.TokenURL, "http://127.0.0.1") {
+			return cfg.Exchange(ctx, code, opts...)
+		}
 		return testToken, nil
 	}
 }
@@ -181,6 +189,7 @@ func TestEnd(t *testing.T) {
 	w := h.ab.NewResponse(rec)
 
 	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Verifier] = "verifier"
 	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
 	if err != nil {
 		t.Fatal(err)
@@ -252,6 +261,7 @@ func TestEndErrors(t *testing.T) {
 	w := h.ab.NewResponse(rec)
 
 	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Verifier] = "verifier"
 	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state&error=badtimes&error_reason=reason", nil))
 	if err != nil {
 		t.Fatal(err)
@@ -270,6 +280,43 @@ func TestEndErrors(t *testing.T) {
 	}
 }
 
+func TestEndEmailDomain(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.RegisterAllowedDomains = []string{"test.com"}
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Verifier] = "verifier"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Error(err)
+	}
+
+	w.WriteHeader(http.StatusOK) // Flush headers
+
+	opts := h.redirector.Options
+	if opts.RedirectPath != "/auth/oauth2/not/ok" {
+		t.Error("path was wrong:", opts.RedirectPath)
+	}
+	if opts.Failure != authboss.ErrEmailDomainNotAllowed.Error() {
+		t.Error("failure was wrong:", opts.Failure)
+	}
+	if s, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("should not have been logged in:", s)
+	}
+	if len(h.storer.Users) != 0 {
+		t.Error("no user should have been created")
+	}
+}
+
 func TestEndHandling(t *testing.T) {
 	t.Parallel()
 
@@ -280,6 +327,7 @@ func TestEndHandling(t *testing.T) {
 		w := h.ab.NewResponse(rec)
 
 		h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+		h.session.ClientValues[authboss.SessionOAuth2Verifier] = "verifier"
 		r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state&error=badtimes&error_reason=reason", nil))
 		if err != nil {
 			t.Fatal(err)
@@ -309,6 +357,7 @@ func TestEndHandling(t *testing.T) {
 		w := h.ab.NewResponse(rec)
 
 		h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+		h.session.ClientValues[authboss.SessionOAuth2Verifier] = "verifier"
 		r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
 		if err != nil {
 			t.Fatal(err)
@@ -344,6 +393,7 @@ func TestEndHandling(t *testing.T) {
 		w := h.ab.NewResponse(rec)
 
 		h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+		h.session.ClientValues[authboss.SessionOAuth2Verifier] = "verifier"
 		r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
 		if err != nil {
 			t.Fatal(err)
@@ -372,3 +422,457 @@ func TestEndHandling(t *testing.T) {
 		}
 	})
 }
+
+func TestStartPKCE(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.oauth.Start(w, httptest.NewRequest("GET", "/oauth2/google", nil)); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	verifier := h.session.ClientValues[authboss.SessionOAuth2Verifier]
+	if len(verifier) < 43 {
+		t.Fatal("the verifier should be in the session:", verifier)
+	}
+
+	redirect, err := url.Parse(h.redirector.Options.RedirectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := redirect.Query()
+
+	sum := sha256.Sum256([]byte(verifier))
+	if got := query.Get("code_challenge"); got != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Error("code challenge was wrong:", got)
+	}
+	if got := query.Get("code_challenge_method"); got != "S256" {
+		t.Error("code challenge method was wrong:", got)
+	}
+	if query.Get("nonce") != "" {
+		t.Error("a nonce should not be sent unless asked for")
+	}
+}
+
+func TestStartPKCEDisabledNonce(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{
+		"test": {
+			OAuth2Config: &oauth2.Config{
+				ClientID: "client",
+				Endpoint: oauth2.Endpoint{AuthURL: "https://test.com/authorize"},
+			},
+			DisablePKCE: true,
+			Nonce:       true,
+		},
+	}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.oauth.Start(w, httptest.NewRequest("GET", "/oauth2/test", nil)); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	redirect, err := url.Parse(h.redirector.Options.RedirectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := redirect.Query()
+
+	if _, ok := h.session.ClientValues[authboss.SessionOAuth2Verifier]; ok {
+		t.Error("the verifier should not be in the session")
+	}
+	if query.Get("code_challenge") != "" || query.Get("code_challenge_method") != "" {
+		t.Error("pkce should have been disabled:", query)
+	}
+
+	nonce := h.session.ClientValues[authboss.SessionOAuth2Nonce]
+	if len(nonce) == 0 || query.Get("nonce") != nonce {
+		t.Error("the nonce should have been sent:", nonce, query.Get("nonce"))
+	}
+}
+
+func TestEndPKCE(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		disable  bool
+		verifier string
+		want     string
+	}{
+		"Enabled":  {verifier: "verifier", want: "verifier"},
+		"Disabled": {disable: true, verifier: "verifier", want: ""},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotVerifier string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotVerifier = r.FormValue("code_verifier")
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"token","token_type":"Bearer","expires_in":3600}`))
+			}))
+			defer server.Close()
+
+			h := testSetup()
+			h.ab.Config.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{
+				"test": {
+					OAuth2Config: &oauth2.Config{
+						ClientID: "client",
+						Endpoint: oauth2.Endpoint{TokenURL: server.URL + "/token"},
+					},
+					FindUserDetails: func(context.Context, oauth2.Config, *oauth2.Token) (map[string]string, error) {
+						return map[string]string{OAuth2UID: "id", OAuth2Email: "test@test.com"}, nil
+					},
+					DisablePKCE: test.disable,
+				},
+			}
+
+			w := h.ab.NewResponse(httptest.NewRecorder())
+			h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+			h.session.ClientValues[authboss.SessionOAuth2Verifier] = test.verifier
+			r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/test?state=state&code=code", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := h.oauth.End(w, r); err != nil {
+				t.Fatal(err)
+			}
+			w.WriteHeader(http.StatusOK)
+
+			if gotVerifier != test.want {
+				t.Error("verifier sent was wrong:", gotVerifier)
+			}
+			if _, ok := h.session.ClientValues[authboss.SessionOAuth2Verifier]; ok {
+				t.Error("the verifier should have been removed from the session")
+			}
+		})
+	}
+}
+
+func TestEndPKCEMissingVerifier(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != errOAuthVerifierMissing {
+		t.Error("error was wrong:", err)
+	}
+}
+
+func TestInitLink(t *testing.T) {
+	// No t.Parallel() since the cfg.RedirectURL is set in Init()
+
+	ab := authboss.New()
+	oauth := &OAuth2{}
+
+	router := &mocks.Router{}
+	ab.Config.Modules.OAuth2Providers = testProviders
+	ab.Config.Core.Router = router
+	ab.Config.Core.ErrorHandler = &mocks.ErrorHandler{}
+	ab.Config.Storage.Server = mocks.NewServerStorer()
+
+	ab.Config.Paths.Mount = "/auth"
+	ab.Config.Paths.RootURL = "https://www.example.com"
+
+	if err := oauth.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	gets := []string{
+		"/oauth2/facebook", "/oauth2/callback/facebook", "/oauth2/link/facebook",
+		"/oauth2/google", "/oauth2/callback/google", "/oauth2/link/google",
+	}
+	if err := router.HasGets(gets...); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/oauth2/unlink/facebook", "/oauth2/unlink/google"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLink(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/link/google", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.Link(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if pid := h.session.ClientValues[authboss.SessionOAuth2Link]; pid != "test@test.com" {
+		t.Error("the user linking should be in the session:", pid)
+	}
+	if len(h.session.ClientValues[authboss.SessionOAuth2State]) == 0 {
+		t.Error("the oauth2 process should have been started")
+	}
+
+	// Logging in afterwards must not link
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	r, err = h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/google", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.oauth.Start(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if _, ok := h.session.ClientValues[authboss.SessionOAuth2Link]; ok {
+		t.Error("logging in should have removed the user linking from the session")
+	}
+}
+
+// linkEnd finishes linking the test user's google account, the google
+// user details always have the uid "id"
+func linkEnd(t *testing.T, h *testHarness, pid string) error {
+	t.Helper()
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.session.ClientValues[authboss.SessionKey] = pid
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Verifier] = "verifier"
+	h.session.ClientValues[authboss.SessionOAuth2Link] = "test@test.com"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = h.oauth.End(w, r)
+	w.WriteHeader(http.StatusOK)
+	return err
+}
+
+func TestEndLink(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Linked", func(t *testing.T) {
+		t.Parallel()
+		h := testSetup()
+		h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+
+		if err := linkEnd(t, h, "test@test.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		identity, ok := h.storer.Identities["google:id"]
+		if !ok || identity.PID != "test@test.com" || identity.Email != "email" || identity.LinkedAt.IsZero() {
+			t.Error("the identity should have been linked:", identity)
+		}
+		if identity.AccessToken != "token" || identity.RefreshToken != "refresh" {
+			t.Error("the identity should have its token:", identity.AccessToken, identity.RefreshToken)
+		}
+		if h.redirector.Options.Success == "" || h.redirector.Options.RedirectPath != "/" {
+			t.Error("should have redirected with success:", h.redirector.Options)
+		}
+		if s := h.session.ClientValues[authboss.SessionKey]; s != "test@test.com" {
+			t.Error("the user should still be logged in as themselves:", s)
+		}
+		if _, ok := h.storer.Users[authboss.MakeOAuth2PID("google", "id")]; ok {
+			t.Error("linking should not create an oauth2 user")
+		}
+	})
+
+	t.Run("LinkedToOther", func(t *testing.T) {
+		t.Parallel()
+		h := testSetup()
+		h.storer.Identities["google:id"] = authboss.OAuth2Identity{Provider: "google", UID: "id", PID: "other@test.com"}
+
+		if err := linkEnd(t, h, "test@test.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		if h.storer.Identities["google:id"].PID != "other@test.com" {
+			t.Error("the identity should not have been taken over")
+		}
+		if h.redirector.Options.Failure == "" {
+			t.Error("should have redirected with a failure")
+		}
+	})
+
+	t.Run("OwnAccount", func(t *testing.T) {
+		t.Parallel()
+		h := testSetup()
+		h.storer.Users[authboss.MakeOAuth2PID("google", "id")] = &mocks.User{OAuth2Provider: "google", OAuth2UID: "id"}
+
+		if err := linkEnd(t, h, "test@test.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := h.storer.Identities["google:id"]; ok {
+			t.Error("an identity with its own account should not be linked")
+		}
+		if h.redirector.Options.Failure == "" {
+			t.Error("should have redirected with a failure")
+		}
+	})
+
+	t.Run("DifferentUser", func(t *testing.T) {
+		t.Parallel()
+		h := testSetup()
+
+		if err := linkEnd(t, h, "other@test.com"); err != errOAuthLinkUser {
+			t.Error("error was wrong:", err)
+		}
+		if _, ok := h.storer.Identities["google:id"]; ok {
+			t.Error("the identity should not have been linked")
+		}
+	})
+}
+
+func TestEndLinkedIdentity(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+	h.storer.Identities["google:id"] = authboss.OAuth2Identity{Provider: "google", UID: "id", PID: "test@test.com"}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Verifier] = "verifier"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if s := h.session.ClientValues[authboss.SessionKey]; s != "test@test.com" {
+		t.Error("should have logged in as the linked user:", s)
+	}
+	if _, ok := h.storer.Users[authboss.MakeOAuth2PID("google", "id")]; ok {
+		t.Error("should not have created a separate oauth2 user")
+	}
+	if identity := h.storer.Identities["google:id"]; identity.AccessToken != "token" || identity.RefreshToken != "refresh" {
+		t.Error("the identity's token should have been stored:", identity.AccessToken, identity.RefreshToken)
+	}
+	if h.redirector.Options.RedirectPath != "/auth/oauth2/ok" {
+		t.Error("redirect path was wrong:", h.redirector.Options.RedirectPath)
+	}
+}
+
+func TestEndLinkedIdentity2FA(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Paths.Mount = "/auth"
+	totp := &totp2fa.TOTP{Authboss: h.ab}
+	h.ab.Events.Before(authboss.EventAuthHijack, totp.HijackAuth)
+
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com", TOTPSecretKey: "secret"}
+	h.storer.Identities["google:id"] = authboss.OAuth2Identity{Provider: "google", UID: "id", PID: "test@test.com"}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Verifier] = "verifier"
+	h.session.ClientValues[authboss.SessionOAuth2Params] = `{"redir":"/settings"}`
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if s, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+		t.Error("should not be logged in before the second factor:", s)
+	}
+	if pid := h.session.ClientValues[totp2fa.SessionTOTPPendingPID]; pid != "test@test.com" {
+		t.Error("the second factor should be pending for the linked user:", pid)
+	}
+	if h.redirector.Options.RedirectPath != "/auth/2fa/totp/validate?redir=%2Fsettings" {
+		t.Error("redirect path was wrong:", h.redirector.Options.RedirectPath)
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.storer.Identities["google:id"] = authboss.OAuth2Identity{Provider: "google", UID: "id", PID: "test@test.com"}
+	h.storer.Identities["facebook:id"] = authboss.OAuth2Identity{Provider: "facebook", UID: "id", PID: "test@test.com"}
+	h.storer.Identities["google:other"] = authboss.OAuth2Identity{Provider: "google", UID: "other", PID: "other@test.com"}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.session.ClientValues[authboss.SessionKey] = "test@test.com"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("POST", "/oauth2/unlink/google", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.Unlink(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := h.storer.Identities["google:id"]; ok {
+		t.Error("the google identity should have been unlinked")
+	}
+	if _, ok := h.storer.Identities["facebook:id"]; !ok {
+		t.Error("other providers should stay linked")
+	}
+	if _, ok := h.storer.Identities["google:other"]; !ok {
+		t.Error("other users' identities should stay linked")
+	}
+	if h.redirector.Options.RedirectPath != "/" {
+		t.Error("redirect path was wrong:", h.redirector.Options.RedirectPath)
+	}
+}
+
+func TestEndUnsafeRedirect(t *testing.T) {
+	t.Parallel()
+
+	for _, redir := range []string{"/home", "//evil.com", "https://evil.com"} {
+		h := testSetup()
+
+		w := h.ab.NewResponse(httptest.NewRecorder())
+		h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+		h.session.ClientValues[authboss.SessionOAuth2Verifier] = "verifier"
+		h.session.ClientValues[authboss.SessionOAuth2Params] = fmt.Sprintf(`{"redir":%q}`, redir)
+		r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/google?state=state", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := h.oauth.End(w, r); err != nil {
+			t.Fatal(err)
+		}
+		w.WriteHeader(http.StatusOK)
+
+		want := "/auth/oauth2/ok"
+		if redir == "/home" {
+			want = "/home"
+		}
+		if p := h.redirector.Options.RedirectPath; p != want {
+			t.Errorf("%s: redirect path was wrong: %s", redir, p)
+		}
+	}
+}
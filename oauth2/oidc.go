@@ -0,0 +1,407 @@
+package oauth2
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/friendsofgo/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/p000ic/authboss-echo"
+)
+
+// Constants for OpenID Connect
+const (
+	// ScopeOpenID must be requested for a provider to send an ID token, a
+	// nonce is sent with logins to providers that request it
+	ScopeOpenID = "openid"
+
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	// oidcLeeway allows for clock skew between us and the issuer
+	oidcLeeway = time.Minute
+)
+
+var (
+	errOIDCNoIDToken    = errors.New("oidc: token response did not include an id_token")
+	errOIDCMalformed    = errors.New("oidc: malformed id token")
+	errOIDCSignature    = errors.New("oidc: id token signature is invalid")
+	errOIDCUnknownKey   = errors.New("oidc: id token was signed with an unknown key")
+	errOIDCAlgorithm    = errors.New("oidc: id token algorithm is not supported")
+	errOIDCIssuer       = errors.New("oidc: id token was not issued by the provider")
+	errOIDCAudience     = errors.New("oidc: id token was not issued for this client")
+	errOIDCExpired      = errors.New("oidc: id token has expired")
+	errOIDCNonce        = errors.New("oidc: id token nonce does not match")
+	errOIDCMissingNonce = errors.New("oidc: no nonce was sent with the login")
+)
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDCProvider creates a provider for an OpenID Connect issuer using
+// its discovery document at /.well-known/openid-configuration. It requests
+// the openid, email and profile scopes, and its FindUserDetails verifies
+// the ID token from the token response rather than calling an endpoint.
+//
+// The ID token's signature (RS256 or ES256) is checked against the
+// issuer's JWKS, which is fetched again when it's signed by a key that
+// isn't known yet so keys can be rotated. Its iss, aud, exp and nonce
+// claims are checked too. The sub, email and name claims become the uid,
+// email and name details.
+//
+// An *http.Client can be given for discovery and fetching keys the same
+// way as for golang.org/x/oauth2, with the oauth2.HTTPClient context key.
+func NewOIDCProvider(ctx context.Context, issuer, clientID, clientSecret string) (authboss.OAuth2Provider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	client := oidcClient(ctx)
+
+	var discovery oidcDiscovery
+	if err := getJSON(ctx, client, issuer+oidcDiscoveryPath, &discovery); err != nil {
+		return authboss.OAuth2Provider{}, errors.Wrap(err, "oidc: failed to discover provider")
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return authboss.OAuth2Provider{}, errors.Errorf("oidc: discovered issuer %q does not match %q", discovery.Issuer, issuer)
+	}
+	if len(discovery.AuthorizationEndpoint) == 0 || len(discovery.TokenEndpoint) == 0 || len(discovery.JWKSURI) == 0 {
+		return authboss.OAuth2Provider{}, errors.New("oidc: discovery document is missing endpoints")
+	}
+
+	verifier := &idTokenVerifier{
+		issuer:   discovery.Issuer,
+		clientID: clientID,
+		keys:     &jwks{uri: discovery.JWKSURI, client: client},
+	}
+
+	return authboss.OAuth2Provider{
+		OAuth2Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  discovery.AuthorizationEndpoint,
+				TokenURL: discovery.TokenEndpoint,
+			},
+			Scopes: []string{ScopeOpenID, "email", "profile"},
+		},
+		FindUserDetails: verifier.findUserDetails,
+	}, nil
+}
+
+func oidcClient(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && client != nil {
+		return client
+	}
+
+	return http.DefaultClient
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	byt, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+
+	return json.Unmarshal(byt, v)
+}
+
+type idTokenVerifier struct {
+	issuer   string
+	clientID string
+	keys     *jwks
+}
+
+type idTokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// audience is the aud claim, which can be a string or an array of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+type idTokenClaims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+	Azp      string   `json:"azp"`
+	Expiry   int64    `json:"exp"`
+	Nonce    string   `json:"nonce"`
+
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+func (v *idTokenVerifier) findUserDetails(ctx context.Context, _ oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || len(rawIDToken) == 0 {
+		return nil, errOIDCNoIDToken
+	}
+
+	nonce, _ := ctx.Value(authboss.CTXKeyOAuth2Nonce).(string)
+	if len(nonce) == 0 {
+		return nil, errOIDCMissingNonce
+	}
+
+	claims, err := v.verify(ctx, rawIDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]string{
+		OAuth2UID:   claims.Subject,
+		OAuth2Email: claims.Email,
+		OAuth2Name:  claims.Name,
+	}
+	if claims.EmailVerified != nil {
+		if *claims.EmailVerified {
+			details[OAuth2EmailVerified] = "true"
+		} else {
+			details[OAuth2EmailVerified] = "false"
+		}
+	}
+
+	return details, nil
+}
+
+// verify checks an ID token's signature and claims
+func (v *idTokenVerifier) verify(ctx context.Context, rawIDToken, nonce string) (idTokenClaims, error) {
+	var claims idTokenClaims
+
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return claims, errOIDCMalformed
+	}
+
+	var header idTokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, errOIDCMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errOIDCMalformed
+	}
+
+	key, err := v.keys.key(ctx, header.Kid)
+	if err != nil {
+		return claims, err
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch header.Alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return claims, errOIDCSignature
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], signature); err != nil {
+			return claims, errOIDCSignature
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return claims, errOIDCSignature
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, hash[:], r, s) {
+			return claims, errOIDCSignature
+		}
+	default:
+		return claims, errOIDCAlgorithm
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, errOIDCMalformed
+	}
+
+	if claims.Issuer != v.issuer {
+		return claims, errOIDCIssuer
+	}
+	if !hasString(claims.Audience, v.clientID) {
+		return claims, errOIDCAudience
+	}
+	if len(claims.Audience) > 1 && len(claims.Azp) != 0 && claims.Azp != v.clientID {
+		return claims, errOIDCAudience
+	}
+	if time.Now().Add(-oidcLeeway).After(time.Unix(claims.Expiry, 0)) {
+		return claims, errOIDCExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return claims, errOIDCNonce
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	byt, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(byt, v)
+}
+
+func hasString(arr []string, s string) bool {
+	for _, a := range arr {
+		if a == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jwks caches an issuer's signing keys by their key id
+type jwks struct {
+	uri    string
+	client *http.Client
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// key finds the key with the given id, fetching the keys again if it isn't
+// known in case the issuer rotated them
+func (j *jwks) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+
+	if err := j.fetch(ctx); err != nil {
+		return nil, err
+	}
+
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errOIDCUnknownKey
+}
+
+func (j *jwks) fetch(ctx context.Context) error {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, j.client, j.uri, &set); err != nil {
+		return errors.Wrap(err, "oidc: failed to fetch keys")
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if len(k.Use) != 0 && k.Use != "sig" {
+			continue
+		}
+
+		// Keys that can't be parsed are skipped, they may use algorithms
+		// we don't support
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	j.keys = keys
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent is too large")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("ec point is not on the curve")
+		}
+		return pub, nil
+	default:
+		return nil, errors.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,409 @@
+package oauth2
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/p000ic/authboss-echo"
+)
+
+// testIssuer is an OpenID Connect issuer that signs ID tokens with the
+// keys it publishes
+type testIssuer struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	keys       map[string]crypto.Signer
+	jwksServed int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	issuer := &testIssuer{keys: make(map[string]crypto.Signer)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+
+		issuer.jwksServed++
+
+		var keys []map[string]string
+		for kid, key := range issuer.keys {
+			switch pub := key.Public().(type) {
+			case *rsa.PublicKey:
+				keys = append(keys, map[string]string{
+					"kty": "RSA", "kid": kid, "use": "sig",
+					"n": base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+					"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+				})
+			case *ecdsa.PublicKey:
+				keys = append(keys, map[string]string{
+					"kty": "EC", "kid": kid, "crv": "P-256",
+					"x": base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+					"y": base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+				})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+func (i *testIssuer) addRSAKey(t *testing.T, kid string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i.mu.Lock()
+	i.keys[kid] = key
+	i.mu.Unlock()
+}
+
+func (i *testIssuer) addECKey(t *testing.T, kid string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i.mu.Lock()
+	i.keys[kid] = key
+	i.mu.Unlock()
+}
+
+// sign creates an ID token signed by the key with the given kid
+func (i *testIssuer) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	i.mu.Lock()
+	key := i.keys[kid]
+	i.mu.Unlock()
+
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (i *testIssuer) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            i.server.URL,
+		"sub":            "subject",
+		"aud":            "client",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          "nonce",
+		"email":          "test@test.com",
+		"email_verified": true,
+		"name":           "Test",
+	}
+}
+
+func findDetails(provider authboss.OAuth2Provider, nonce, idToken string) (map[string]string, error) {
+	ctx := context.Background()
+	if len(nonce) != 0 {
+		ctx = context.WithValue(ctx, authboss.CTXKeyOAuth2Nonce, nonce)
+	}
+
+	token := (&oauth2.Token{AccessToken: "token"}).WithExtra(map[string]interface{}{"id_token": idToken})
+	return provider.FindUserDetails(ctx, *provider.OAuth2Config, token)
+}
+
+func TestNewOIDCProvider(t *testing.T) {
+	t.Parallel()
+
+	issuer := newTestIssuer(t)
+
+	provider, err := NewOIDCProvider(context.Background(), issuer.server.URL+"/", "client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := provider.OAuth2Config
+	if cfg.Endpoint.AuthURL != issuer.server.URL+"/authorize" {
+		t.Error("auth url was wrong:", cfg.Endpoint.AuthURL)
+	}
+	if cfg.Endpoint.TokenURL != issuer.server.URL+"/token" {
+		t.Error("token url was wrong:", cfg.Endpoint.TokenURL)
+	}
+	if cfg.ClientID != "client" || cfg.ClientSecret != "secret" {
+		t.Error("client credentials were wrong:", cfg.ClientID, cfg.ClientSecret)
+	}
+	if scopes := strings.Join(cfg.Scopes, " "); scopes != "openid email profile" {
+		t.Error("scopes were wrong:", scopes)
+	}
+
+	if _, err := NewOIDCProvider(context.Background(), issuer.server.URL+"/other", "client", "secret"); err == nil {
+		t.Error("discovery should fail for an issuer that doesn't exist")
+	}
+}
+
+func TestOIDCFindUserDetails(t *testing.T) {
+	t.Parallel()
+
+	issuer := newTestIssuer(t)
+	issuer.addRSAKey(t, "rsa")
+	issuer.addECKey(t, "ec")
+
+	provider, err := NewOIDCProvider(context.Background(), issuer.server.URL, "client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kid := range []string{"rsa", "ec"} {
+		details, err := findDetails(provider, "nonce", issuer.sign(t, kid, issuer.claims()))
+		if err != nil {
+			t.Fatalf("%s: %v", kid, err)
+		}
+
+		if details[OAuth2UID] != "subject" {
+			t.Errorf("%s: uid was wrong: %s", kid, details[OAuth2UID])
+		}
+		if details[OAuth2Email] != "test@test.com" {
+			t.Errorf("%s: email was wrong: %s", kid, details[OAuth2Email])
+		}
+		if details[OAuth2Name] != "Test" {
+			t.Errorf("%s: name was wrong: %s", kid, details[OAuth2Name])
+		}
+		if details[OAuth2EmailVerified] != "true" {
+			t.Errorf("%s: email verified was wrong: %s", kid, details[OAuth2EmailVerified])
+		}
+	}
+}
+
+func TestOIDCFindUserDetailsInvalid(t *testing.T) {
+	t.Parallel()
+
+	issuer := newTestIssuer(t)
+	issuer.addRSAKey(t, "rsa")
+
+	provider, err := NewOIDCProvider(context.Background(), issuer.server.URL, "client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tamper := func(token string) string {
+		parts := strings.Split(token, ".")
+		claims := issuer.claims()
+		claims["sub"] = "someone-else"
+		payload, _ := json.Marshal(claims)
+		return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+	}
+
+	unsigned := func() string {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa"}`))
+		payload, _ := json.Marshal(issuer.claims())
+		return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+	}
+
+	tests := map[string]struct {
+		nonce   string
+		token   string
+		wantErr error
+	}{
+		"WrongIssuer":          {"nonce", issuer.sign(t, "rsa", with(issuer.claims(), "iss", "https://evil.com")), errOIDCIssuer},
+		"WrongAudience":        {"nonce", issuer.sign(t, "rsa", with(issuer.claims(), "aud", "other")), errOIDCAudience},
+		"WrongAuthorizedParty": {"nonce", issuer.sign(t, "rsa", with(with(issuer.claims(), "aud", []string{"client", "other"}), "azp", "other")), errOIDCAudience},
+		"Expired":              {"nonce", issuer.sign(t, "rsa", with(issuer.claims(), "exp", time.Now().Add(-time.Hour).Unix())), errOIDCExpired},
+		"WrongNonce":           {"other", issuer.sign(t, "rsa", issuer.claims()), errOIDCNonce},
+		"NoNonce":              {"", issuer.sign(t, "rsa", issuer.claims()), errOIDCMissingNonce},
+		"Tampered":             {"nonce", tamper(issuer.sign(t, "rsa", issuer.claims())), errOIDCSignature},
+		"Unsigned":             {"nonce", unsigned(), errOIDCAlgorithm},
+		"Malformed":            {"nonce", "not.a-token", errOIDCMalformed},
+		"NoIDToken":            {"nonce", "", errOIDCNoIDToken},
+	}
+
+	for name, test := range tests {
+		if _, err := findDetails(provider, test.nonce, test.token); err != test.wantErr {
+			t.Errorf("%s: want: %v, got: %v", name, test.wantErr, err)
+		}
+	}
+}
+
+func with(claims map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	claims[key] = value
+	return claims
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	t.Parallel()
+
+	issuer := newTestIssuer(t)
+	issuer.addRSAKey(t, "old")
+
+	provider, err := NewOIDCProvider(context.Background(), issuer.server.URL, "client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := findDetails(provider, "nonce", issuer.sign(t, "old", issuer.claims())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findDetails(provider, "nonce", issuer.sign(t, "old", issuer.claims())); err != nil {
+		t.Fatal(err)
+	}
+	if issuer.jwksServed != 1 {
+		t.Error("known keys should be cached, fetched:", issuer.jwksServed)
+	}
+
+	issuer.addRSAKey(t, "new")
+	if _, err := findDetails(provider, "nonce", issuer.sign(t, "new", issuer.claims())); err != nil {
+		t.Fatal("a rotated key should be fetched:", err)
+	}
+	if issuer.jwksServed != 2 {
+		t.Error("keys should have been fetched again, fetched:", issuer.jwksServed)
+	}
+
+	if _, err := findDetails(provider, "nonce", issuer.sign(t, "new", issuer.claims())[:10]+".x.y"); err == nil {
+		t.Error("garbage should fail")
+	}
+
+	// Tokens signed with keys the issuer doesn't publish are rejected
+	other := newTestIssuer(t)
+	other.addRSAKey(t, "unknown")
+	if _, err := findDetails(provider, "nonce", other.sign(t, "unknown", issuer.claims())); err != errOIDCUnknownKey {
+		t.Error("error was wrong:", err)
+	}
+}
+
+func TestStartOIDCNonce(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{
+		"oidc": {
+			OAuth2Config: &oauth2.Config{
+				ClientID: "client",
+				Endpoint: oauth2.Endpoint{AuthURL: "https://issuer.com/authorize"},
+				Scopes:   []string{ScopeOpenID, "email"},
+			},
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+	r := httptest.NewRequest("GET", "/oauth2/oidc", nil)
+
+	if err := h.oauth.Start(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	nonce := h.session.ClientValues[authboss.SessionOAuth2Nonce]
+	if len(nonce) == 0 {
+		t.Fatal("the nonce should be in the session")
+	}
+
+	redirect, err := url.Parse(h.redirector.Options.RedirectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := redirect.Query().Get("nonce"); got != nonce {
+		t.Error("the nonce should have been sent, got:", got)
+	}
+}
+
+func TestEndOIDCNonce(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+
+	var gotNonce interface{}
+	h.ab.Config.Modules.OAuth2Providers = map[string]authboss.OAuth2Provider{
+		"oidc": {
+			OAuth2Config: &oauth2.Config{Scopes: []string{ScopeOpenID}},
+			FindUserDetails: func(ctx context.Context, _ oauth2.Config, _ *oauth2.Token) (map[string]string, error) {
+				gotNonce = ctx.Value(authboss.CTXKeyOAuth2Nonce)
+				return map[string]string{OAuth2UID: "id", OAuth2Email: "test@test.com"}, nil
+			},
+		},
+	}
+
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	h.session.ClientValues[authboss.SessionOAuth2State] = "state"
+	h.session.ClientValues[authboss.SessionOAuth2Verifier] = "verifier"
+	h.session.ClientValues[authboss.SessionOAuth2Nonce] = "nonce"
+	r, err := h.ab.LoadClientState(w, httptest.NewRequest("GET", "/oauth2/callback/oidc?state=state", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.oauth.End(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if gotNonce != "nonce" {
+		t.Error("the nonce should have been given to FindUserDetails, got:", gotNonce)
+	}
+	if _, ok := h.session.ClientValues[authboss.SessionOAuth2Nonce]; ok {
+		t.Error("the nonce should have been removed from the session")
+	}
+	if _, ok := h.storer.Users[authboss.MakeOAuth2PID("oidc", "id")]; !ok {
+		t.Error("the user should have been created")
+	}
+}
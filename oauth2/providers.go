@@ -5,9 +5,15 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/friendsofgo/errors"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+	"golang.org/x/oauth2/microsoft"
+
+	"github.com/p000ic/authboss-echo"
 )
 
 // Constants for returning in the FindUserDetails call
@@ -15,11 +21,28 @@ const (
 	OAuth2UID   = "uid"
 	OAuth2Email = "email"
 	OAuth2Name  = "name"
+
+	// OAuth2EmailVerified is "true" or "false" for providers that say
+	// whether the e-mail address was verified
+	OAuth2EmailVerified = "email_verified"
 )
 
 const (
 	googleInfoEndpoint   = `https://www.googleapis.com/userinfo/v2/me`
 	facebookInfoEndpoint = `https://graph.facebook.com/me?fields=name,email`
+
+	githubAPI      = `https://api.github.com`
+	gitlabURL      = `https://gitlab.com`
+	microsoftGraph = `https://graph.microsoft.com`
+	discordAPI     = `https://discord.com/api`
+)
+
+// Endpoints for the providers the golang.org/x/oauth2 package doesn't have
+var (
+	DiscordEndpoint = oauth2.Endpoint{
+		AuthURL:  "https://discord.com/oauth2/authorize",
+		TokenURL: "https://discord.com/api/oauth2/token",
+	}
 )
 
 type googleMeResponse struct {
@@ -88,3 +111,231 @@ func FacebookUserDetails(ctx context.Context, cfg oauth2.Config, token *oauth2.T
 		OAuth2Name:  response.Name,
 	}, nil
 }
+
+// NewGitHubProvider creates a provider for GitHub that asks for the read:user
+// and user:email scopes
+func NewGitHubProvider(clientID, clientSecret string) authboss.OAuth2Provider {
+	return authboss.OAuth2Provider{
+		OAuth2Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     github.Endpoint,
+			Scopes:       []string{"read:user", "user:email"},
+		},
+		FindUserDetails: GitHubUserDetails,
+	}
+}
+
+type githubUserResponse struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type githubEmailResponse struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// GitHubUserDetails can be used as a FindUserDetails function
+// for an authboss.OAuth2Provider
+//
+// Users that keep their e-mail address private don't have one on their
+// profile, the primary address is then looked up in their list of
+// addresses which requires the user:email scope.
+func GitHubUserDetails(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+	return githubUserDetails(ctx, cfg.Client(ctx, token), githubAPI)
+}
+
+func githubUserDetails(ctx context.Context, client *http.Client, api string) (map[string]string, error) {
+	var user githubUserResponse
+	if err := getJSON(ctx, client, api+"/user", &user); err != nil {
+		return nil, errors.Wrap(err, "failed to get user from github")
+	}
+
+	name := user.Name
+	if len(name) == 0 {
+		name = user.Login
+	}
+
+	details := map[string]string{
+		OAuth2UID:  strconv.FormatInt(user.ID, 10),
+		OAuth2Name: name,
+	}
+
+	// Only verified addresses can be made public on a profile
+	if len(user.Email) != 0 {
+		details[OAuth2Email] = user.Email
+		details[OAuth2EmailVerified] = "true"
+		return details, nil
+	}
+
+	var emails []githubEmailResponse
+	if err := getJSON(ctx, client, api+"/user/emails", &emails); err != nil {
+		return nil, errors.Wrap(err, "failed to get e-mail addresses from github")
+	}
+
+	details[OAuth2EmailVerified] = "false"
+	for _, email := range emails {
+		if email.Primary {
+			details[OAuth2Email] = email.Email
+			details[OAuth2EmailVerified] = strconv.FormatBool(email.Verified)
+			break
+		}
+	}
+
+	return details, nil
+}
+
+// NewGitLabProvider creates a provider for GitLab that asks for the
+// read_user scope. The baseURL is the address of a self-hosted instance,
+// gitlab.com is used when it's empty.
+func NewGitLabProvider(baseURL, clientID, clientSecret string) authboss.OAuth2Provider {
+	if len(baseURL) == 0 {
+		baseURL = gitlabURL
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	return authboss.OAuth2Provider{
+		OAuth2Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  baseURL + "/oauth/authorize",
+				TokenURL: baseURL + "/oauth/token",
+			},
+			Scopes: []string{"read_user"},
+		},
+		FindUserDetails: func(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+			return gitlabUserDetails(ctx, cfg.Client(ctx, token), baseURL)
+		},
+	}
+}
+
+type gitlabUserResponse struct {
+	ID          int64   `json:"id"`
+	Username    string  `json:"username"`
+	Name        string  `json:"name"`
+	Email       string  `json:"email"`
+	ConfirmedAt *string `json:"confirmed_at"`
+}
+
+// GitLabUserDetails can be used as a FindUserDetails function
+// for an authboss.OAuth2Provider using gitlab.com, see NewGitLabProvider
+// for self-hosted instances
+func GitLabUserDetails(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+	return gitlabUserDetails(ctx, cfg.Client(ctx, token), gitlabURL)
+}
+
+func gitlabUserDetails(ctx context.Context, client *http.Client, baseURL string) (map[string]string, error) {
+	var user gitlabUserResponse
+	if err := getJSON(ctx, client, baseURL+"/api/v4/user", &user); err != nil {
+		return nil, errors.Wrap(err, "failed to get user from gitlab")
+	}
+
+	name := user.Name
+	if len(name) == 0 {
+		name = user.Username
+	}
+
+	return map[string]string{
+		OAuth2UID:           strconv.FormatInt(user.ID, 10),
+		OAuth2Email:         user.Email,
+		OAuth2Name:          name,
+		OAuth2EmailVerified: strconv.FormatBool(user.ConfirmedAt != nil && len(*user.ConfirmedAt) != 0),
+	}, nil
+}
+
+// NewMicrosoftProvider creates a provider for the Microsoft identity platform
+// that asks for the User.Read scope of Microsoft Graph. The tenant is a
+// directory's ID or domain, or one of common, organizations and consumers,
+// common is used when it's empty.
+func NewMicrosoftProvider(tenant, clientID, clientSecret string) authboss.OAuth2Provider {
+	return authboss.OAuth2Provider{
+		OAuth2Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     microsoft.AzureADEndpoint(tenant),
+			Scopes:       []string{"User.Read"},
+		},
+		FindUserDetails: MicrosoftUserDetails,
+	}
+}
+
+type microsoftUserResponse struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	Mail        string `json:"mail"`
+}
+
+// MicrosoftUserDetails can be used as a FindUserDetails function
+// for an authboss.OAuth2Provider
+//
+// The e-mail address is never reported as verified because the
+// administrators of a directory can set it to anything.
+func MicrosoftUserDetails(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+	return microsoftUserDetails(ctx, cfg.Client(ctx, token), microsoftGraph)
+}
+
+func microsoftUserDetails(ctx context.Context, client *http.Client, graph string) (map[string]string, error) {
+	var user microsoftUserResponse
+	if err := getJSON(ctx, client, graph+"/v1.0/me", &user); err != nil {
+		return nil, errors.Wrap(err, "failed to get user from microsoft graph")
+	}
+
+	return map[string]string{
+		OAuth2UID:           user.ID,
+		OAuth2Email:         user.Mail,
+		OAuth2Name:          user.DisplayName,
+		OAuth2EmailVerified: "false",
+	}, nil
+}
+
+// NewDiscordProvider creates a provider for Discord that asks for the
+// identify and email scopes
+func NewDiscordProvider(clientID, clientSecret string) authboss.OAuth2Provider {
+	return authboss.OAuth2Provider{
+		OAuth2Config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     DiscordEndpoint,
+			Scopes:       []string{"identify", "email"},
+		},
+		FindUserDetails: DiscordUserDetails,
+	}
+}
+
+type discordUserResponse struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+	Email      string `json:"email"`
+	Verified   bool   `json:"verified"`
+}
+
+// DiscordUserDetails can be used as a FindUserDetails function
+// for an authboss.OAuth2Provider
+func DiscordUserDetails(ctx context.Context, cfg oauth2.Config, token *oauth2.Token) (map[string]string, error) {
+	return discordUserDetails(ctx, cfg.Client(ctx, token), discordAPI)
+}
+
+func discordUserDetails(ctx context.Context, client *http.Client, api string) (map[string]string, error) {
+	var user discordUserResponse
+	if err := getJSON(ctx, client, api+"/users/@me", &user); err != nil {
+		return nil, errors.Wrap(err, "failed to get user from discord")
+	}
+
+	name := user.GlobalName
+	if len(name) == 0 {
+		name = user.Username
+	}
+
+	return map[string]string{
+		OAuth2UID:           user.ID,
+		OAuth2Email:         user.Email,
+		OAuth2Name:          name,
+		OAuth2EmailVerified: strconv.FormatBool(user.Verified),
+	}, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -71,3 +72,168 @@ func TestFacebook(t *testing.T) {
 		t.Error("Name wrong:", name)
 	}
 }
+
+// newAPIServer serves the json responses by path to requests with the
+// test token
+func newAPIServer(t *testing.T, responses map[string]string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func apiClient() *http.Client {
+	return oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}))
+}
+
+func checkDetails(t *testing.T, details map[string]string, want map[string]string) {
+	t.Helper()
+
+	for k, v := range want {
+		if details[k] != v {
+			t.Errorf("%s was wrong: %q", k, details[k])
+		}
+	}
+}
+
+func TestGitHub(t *testing.T) {
+	t.Parallel()
+
+	t.Run("PublicEmail", func(t *testing.T) {
+		t.Parallel()
+
+		server := newAPIServer(t, map[string]string{
+			"/user": `{"id":5,"login":"octocat","name":"Octo Cat","email":"octo@test.com"}`,
+		})
+
+		details, err := githubUserDetails(context.Background(), apiClient(), server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkDetails(t, details, map[string]string{
+			OAuth2UID: "5", OAuth2Email: "octo@test.com", OAuth2Name: "Octo Cat", OAuth2EmailVerified: "true",
+		})
+	})
+
+	t.Run("PrivateEmail", func(t *testing.T) {
+		t.Parallel()
+
+		server := newAPIServer(t, map[string]string{
+			"/user": `{"id":5,"login":"octocat","name":null,"email":null}`,
+			"/user/emails": `[
+				{"email":"old@test.com","primary":false,"verified":true},
+				{"email":"octo@test.com","primary":true,"verified":false}
+			]`,
+		})
+
+		details, err := githubUserDetails(context.Background(), apiClient(), server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkDetails(t, details, map[string]string{
+			OAuth2UID: "5", OAuth2Email: "octo@test.com", OAuth2Name: "octocat", OAuth2EmailVerified: "false",
+		})
+	})
+
+	t.Run("NoEmailScope", func(t *testing.T) {
+		t.Parallel()
+
+		server := newAPIServer(t, map[string]string{
+			"/user": `{"id":5,"login":"octocat"}`,
+		})
+
+		if _, err := githubUserDetails(context.Background(), apiClient(), server.URL); err == nil {
+			t.Error("it should fail when the e-mail addresses can't be listed")
+		}
+	})
+}
+
+func TestGitLab(t *testing.T) {
+	t.Parallel()
+
+	server := newAPIServer(t, map[string]string{
+		"/api/v4/user": `{"id":7,"username":"tanuki","name":"Tanuki","email":"tanuki@test.com","confirmed_at":"2020-01-01T00:00:00Z"}`,
+	})
+
+	provider := NewGitLabProvider(server.URL+"/", "client", "secret")
+	if url := provider.OAuth2Config.Endpoint.AuthURL; url != server.URL+"/oauth/authorize" {
+		t.Error("auth url was wrong:", url)
+	}
+	if url := provider.OAuth2Config.Endpoint.TokenURL; url != server.URL+"/oauth/token" {
+		t.Error("token url was wrong:", url)
+	}
+
+	details, err := provider.FindUserDetails(context.Background(), *provider.OAuth2Config, &oauth2.Token{AccessToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDetails(t, details, map[string]string{
+		OAuth2UID: "7", OAuth2Email: "tanuki@test.com", OAuth2Name: "Tanuki", OAuth2EmailVerified: "true",
+	})
+
+	if url := NewGitLabProvider("", "client", "secret").OAuth2Config.Endpoint.AuthURL; url != "https://gitlab.com/oauth/authorize" {
+		t.Error("gitlab.com should be the default:", url)
+	}
+
+	unconfirmed := newAPIServer(t, map[string]string{
+		"/api/v4/user": `{"id":7,"username":"tanuki","email":"tanuki@test.com","confirmed_at":null}`,
+	})
+	details, err = gitlabUserDetails(context.Background(), apiClient(), unconfirmed.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDetails(t, details, map[string]string{OAuth2Name: "tanuki", OAuth2EmailVerified: "false"})
+}
+
+func TestMicrosoft(t *testing.T) {
+	t.Parallel()
+
+	provider := NewMicrosoftProvider("contoso.com", "client", "secret")
+	if url := provider.OAuth2Config.Endpoint.AuthURL; url != "https://login.microsoftonline.com/contoso.com/oauth2/v2.0/authorize" {
+		t.Error("auth url should be for the tenant:", url)
+	}
+
+	server := newAPIServer(t, map[string]string{
+		"/v1.0/me": `{"id":"3f2504e0","displayName":"Adele Vance","mail":"adele@contoso.com","userPrincipalName":"adele@contoso.com"}`,
+	})
+
+	details, err := microsoftUserDetails(context.Background(), apiClient(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDetails(t, details, map[string]string{
+		OAuth2UID: "3f2504e0", OAuth2Email: "adele@contoso.com", OAuth2Name: "Adele Vance", OAuth2EmailVerified: "false",
+	})
+}
+
+func TestDiscord(t *testing.T) {
+	t.Parallel()
+
+	server := newAPIServer(t, map[string]string{
+		"/users/@me": `{"id":"80351110224678912","username":"nelly","global_name":null,"email":"nelly@test.com","verified":true}`,
+	})
+
+	details, err := discordUserDetails(context.Background(), apiClient(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDetails(t, details, map[string]string{
+		OAuth2UID: "80351110224678912", OAuth2Email: "nelly@test.com", OAuth2Name: "nelly", OAuth2EmailVerified: "true",
+	})
+}
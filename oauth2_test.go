@@ -0,0 +1,226 @@
+package authboss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+type oauth2TestServers struct {
+	refreshes int32
+
+	token *httptest.Server
+	api   *httptest.Server
+}
+
+// newOAuth2TestServers creates a token endpoint that hands out the access
+// token "new" and an api that echoes the access token it was called with
+func newOAuth2TestServers(t *testing.T) *oauth2TestServers {
+	t.Helper()
+
+	servers := &oauth2TestServers{}
+	servers.token = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		atomic.AddInt32(&servers.refreshes, 1)
+		// Slow enough for other requests to queue up behind the refresh
+		time.Sleep(20 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"new","refresh_token":"newrefresh","token_type":"Bearer","expires_in":3600}`))
+	}))
+	servers.api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+
+	t.Cleanup(servers.token.Close)
+	t.Cleanup(servers.api.Close)
+
+	return servers
+}
+
+func oauth2TestSetup(servers *oauth2TestServers, expiry time.Time) (*Authboss, *mockServerStorer, *mockUser) {
+	ab := New()
+	storer := newMockServerStorer()
+	ab.Config.Storage.Server = storer
+	ab.Config.Modules.OAuth2Providers = map[string]OAuth2Provider{
+		"test": {
+			OAuth2Config: &oauth2.Config{
+				ClientID: "client",
+				Endpoint: oauth2.Endpoint{TokenURL: servers.token.URL},
+			},
+		},
+	}
+
+	user := &mockUser{
+		Email:          MakeOAuth2PID("test", "uid"),
+		OAuth2Provider: "test",
+		OAuth2UID:      "uid",
+		OAuth2Token:    "token",
+		OAuth2Refresh:  "refresh",
+		OAuth2Expiry:   expiry,
+	}
+	storer.Users[MakeOAuth2PID("test", "uid")] = user
+
+	return ab, storer, user
+}
+
+func get(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body [64]byte
+	n, _ := resp.Body.Read(body[:])
+	return string(body[:n])
+}
+
+func TestOAuth2Client(t *testing.T) {
+	t.Parallel()
+
+	servers := newOAuth2TestServers(t)
+	ab, _, user := oauth2TestSetup(servers, time.Now().Add(time.Hour))
+
+	client, err := ab.OAuth2Client(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if auth := get(t, client, servers.api.URL); auth != "Bearer token" {
+		t.Error("authorization was wrong:", auth)
+	}
+	if atomic.LoadInt32(&servers.refreshes) != 0 {
+		t.Error("a valid token should not be refreshed")
+	}
+}
+
+func TestOAuth2ClientRefresh(t *testing.T) {
+	t.Parallel()
+
+	servers := newOAuth2TestServers(t)
+	ab, storer, user := oauth2TestSetup(servers, time.Now().Add(-time.Minute))
+
+	client, err := ab.OAuth2Client(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if auth := get(t, client, servers.api.URL); auth != "Bearer new" {
+			t.Error("authorization was wrong:", auth)
+		}
+	}
+	if n := atomic.LoadInt32(&servers.refreshes); n != 1 {
+		t.Error("the token should have been refreshed once:", n)
+	}
+
+	saved := storer.Users[MakeOAuth2PID("test", "uid")]
+	if saved.OAuth2Token != "new" || saved.OAuth2Refresh != "newrefresh" || !saved.OAuth2Expiry.After(time.Now()) {
+		t.Error("the refreshed token should have been saved:", saved.OAuth2Token, saved.OAuth2Refresh, saved.OAuth2Expiry)
+	}
+}
+
+func TestOAuth2TokenSourceConcurrent(t *testing.T) {
+	t.Parallel()
+
+	servers := newOAuth2TestServers(t)
+	ab, _, user := oauth2TestSetup(servers, time.Now().Add(-time.Minute))
+
+	// Every request loads its own copy of the user
+	sources := make([]oauth2.TokenSource, 10)
+	for i := range sources {
+		copied := *user
+		source, err := ab.OAuth2TokenSource(context.Background(), &copied)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[i] = source
+	}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, len(sources))
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source oauth2.TokenSource) {
+			defer wg.Done()
+
+			token, err := source.Token()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			tokens[i] = token.AccessToken
+		}(i, source)
+	}
+	wg.Wait()
+
+	for i, token := range tokens {
+		if token != "new" {
+			t.Errorf("%d: token was wrong: %s", i, token)
+		}
+	}
+	if n := atomic.LoadInt32(&servers.refreshes); n != 1 {
+		t.Error("the token should have been refreshed once:", n)
+	}
+}
+
+func TestOAuth2IdentityClientRefresh(t *testing.T) {
+	t.Parallel()
+
+	servers := newOAuth2TestServers(t)
+	ab, storer, _ := oauth2TestSetup(servers, time.Now().Add(-time.Minute))
+
+	// The identity is linked to a password account, which has no token
+	storer.Users["test@test.com"] = &mockUser{Email: "test@test.com"}
+	identity := OAuth2Identity{
+		Provider:     "test",
+		UID:          "linked",
+		PID:          "test@test.com",
+		AccessToken:  "token",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(-time.Minute),
+	}
+	storer.Identities["test:linked"] = identity
+
+	client, err := ab.OAuth2IdentityClient(context.Background(), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if auth := get(t, client, servers.api.URL); auth != "Bearer new" {
+		t.Error("authorization was wrong:", auth)
+	}
+
+	saved := storer.Identities["test:linked"]
+	if saved.AccessToken != "new" || saved.RefreshToken != "newrefresh" || !saved.Expiry.After(time.Now()) {
+		t.Error("the refreshed token should have been saved on the identity:", saved.AccessToken, saved.RefreshToken, saved.Expiry)
+	}
+	if saved.PID != "test@test.com" {
+		t.Error("the identity should still be linked to its account:", saved.PID)
+	}
+	if user := storer.Users["test@test.com"]; len(user.OAuth2Token) != 0 {
+		t.Error("the account's user should be left alone:", user.OAuth2Token)
+	}
+}
+
+func TestOAuth2ClientUnknownProvider(t *testing.T) {
+	t.Parallel()
+
+	ab := New()
+	if _, err := ab.OAuth2Client(context.Background(), &mockUser{OAuth2Provider: "unknown"}); err == nil {
+		t.Error("should fail for a provider that's not configured")
+	}
+}
@@ -74,6 +74,7 @@ func (o *OTP) Init(ab *authboss.Authboss) (err error) {
 
 	o.Authboss.Config.Core.Router.Get("/otp/login", o.Authboss.Core.ErrorHandler.Wrap(o.LoginGet))
 	o.Authboss.Config.Core.Router.Post("/otp/login", o.Authboss.Core.ErrorHandler.Wrap(o.LoginPost))
+	o.Authboss.LimitRoute("/otp/login", authboss.RateLimitedRoute{Page: PageLogin})
 
 	var unauthedResponse authboss.MWRespondOnFailure
 	if ab.Config.Modules.ResponseOnUnauthed != 0 {
@@ -94,7 +95,7 @@ func (o *OTP) Init(ab *authboss.Authboss) (err error) {
 // LoginGet simply displays the login form
 func (o *OTP) LoginGet(w http.ResponseWriter, r *http.Request) error {
 	var data authboss.HTMLData
-	if redir := r.URL.Query().Get(authboss.FormValueRedirect); len(redir) != 0 {
+	if redir := r.URL.Query().Get(authboss.FormValueRedirect); o.Authboss.Config.Paths.RedirectPolicy.Allowed(redir) {
 		data = authboss.HTMLData{authboss.FormValueRedirect: redir}
 	}
 	return o.Core.Responder.Respond(w, r, http.StatusOK, PageLogin, data)
@@ -183,6 +184,7 @@ func (o *OTP) LoginPost(w http.ResponseWriter, r *http.Request) error {
 
 	logger.Infof("user %s logged in via otp", pid)
 	authboss.PutSession(w, authboss.SessionKey, pid)
+	authboss.PutSessionSecurityStamp(w, pidUser)
 	authboss.DelSession(w, authboss.SessionHalfAuthKey)
 
 	handled, err = o.Authboss.Events.FireAfter(authboss.EventAuth, w, r)
@@ -76,9 +76,7 @@ type SMSNumberProvider interface {
 }
 
 // SMSSender sends SMS messages to a phone number
-type SMSSender interface {
-	Send(ctx context.Context, number, text string) error
-}
+type SMSSender = authboss.SMSSender
 
 // SMS implements time based one time passwords
 type SMS struct {
@@ -138,6 +136,7 @@ func (s *SMS) Setup() error {
 	validate := &SMSValidator{SMS: s, Page: PageSMSValidate}
 	s.Authboss.Core.Router.Get("/2fa/sms/validate", s.Core.ErrorHandler.Wrap(validate.Get))
 	s.Authboss.Core.Router.Post("/2fa/sms/validate", s.Core.ErrorHandler.Wrap(validate.Post))
+	s.Authboss.LimitRoute("/2fa/sms/validate", authboss.RateLimitedRoute{SessionKey: SessionSMSPendingPID})
 
 	s.Authboss.Events.Before(authboss.EventAuthHijack, s.HijackAuth)
 
@@ -171,10 +170,7 @@ func (s *SMS) HijackAuth(w http.ResponseWriter, r *http.Request, handled bool) (
 		return false, err
 	}
 
-	var query string
-	if len(r.URL.RawQuery) != 0 {
-		query = "?" + r.URL.RawQuery
-	}
+	query := s.Authboss.Config.Paths.RedirectPolicy.Query(r)
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
 		RedirectPath: s.Paths.Mount + "/2fa/sms/validate" + query,
@@ -464,6 +460,7 @@ func (s *SMSValidator) validateCode(w http.ResponseWriter, r *http.Request, user
 		logger.Infof("user %s disabled sms 2fa", user.GetPID())
 	case PageSMSValidate:
 		authboss.PutSession(w, authboss.SessionKey, user.GetPID())
+		authboss.PutSessionSecurityStamp(w, user)
 		authboss.PutSession(w, authboss.Session2FA, "sms")
 
 		authboss.DelSession(w, authboss.SessionHalfAuthKey)
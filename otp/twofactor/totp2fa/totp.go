@@ -122,6 +122,7 @@ func (t *TOTP) Setup() error {
 
 	t.Authboss.Core.Router.Get("/2fa/totp/validate", t.Core.ErrorHandler.Wrap(t.GetValidate))
 	t.Authboss.Core.Router.Post("/2fa/totp/validate", t.Core.ErrorHandler.Wrap(t.PostValidate))
+	t.Authboss.LimitRoute("/2fa/totp/validate", authboss.RateLimitedRoute{SessionKey: SessionTOTPPendingPID})
 
 	t.Authboss.Events.Before(authboss.EventAuthHijack, t.HijackAuth)
 
@@ -150,10 +151,7 @@ func (t *TOTP) HijackAuth(w http.ResponseWriter, r *http.Request, handled bool)
 
 	authboss.PutSession(w, SessionTOTPPendingPID, user.GetPID())
 
-	var query string
-	if len(r.URL.RawQuery) != 0 {
-		query = "?" + r.URL.RawQuery
-	}
+	query := t.Authboss.Config.Paths.RedirectPolicy.Query(r)
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
 		RedirectPath: t.Paths.Mount + "/2fa/totp/validate" + query,
@@ -406,6 +404,7 @@ func (t *TOTP) PostValidate(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	authboss.PutSession(w, authboss.SessionKey, user.GetPID())
+	authboss.PutSessionSecurityStamp(w, user)
 	authboss.PutSession(w, authboss.Session2FA, "totp")
 
 	authboss.DelSession(w, authboss.SessionHalfAuthKey)
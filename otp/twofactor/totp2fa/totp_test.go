@@ -174,6 +174,25 @@ func TestHijackAuth(t *testing.T) {
 			t.Error("redir path wrong:", opts.RedirectPath)
 		}
 	})
+
+	t.Run("UnsafeRedirect", func(t *testing.T) {
+		harness := testSetup()
+
+		r, w, _ := harness.newHTTP("POST")
+		r.URL.RawQuery = "test=query&redir=%2F%2Fevil.com"
+
+		user := &mocks.User{Email: "test@test.com", TOTPSecretKey: "secret"}
+		harness.putUserInCtx(user, &r)
+		harness.loadClientState(w, &r)
+
+		if _, err := harness.totp.HijackAuth(w, r, false); err != nil {
+			t.Fatal(err)
+		}
+
+		if p := harness.redirector.Options.RedirectPath; p != "/auth/2fa/totp/validate?test=query" {
+			t.Error("the redirect should not have been passed along:", p)
+		}
+	})
 }
 
 func TestGetSetup(t *testing.T) {
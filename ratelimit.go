@@ -0,0 +1,78 @@
+package authboss
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Rate is a number of attempts that are allowed every Per. Attempts
+// refill continuously, so a Rate of 5 per minute allows a burst of 5
+// followed by one every 12 seconds.
+type Rate struct {
+	Attempts int
+	Per      time.Duration
+}
+
+// RateLimiter keeps track of the attempts made for a key (an ip address for
+// example) and decides whether another is allowed. See the ratelimit module.
+type RateLimiter interface {
+	// Allow records an attempt for key and reports whether it was within
+	// rate. If it was not, retryAfter is how long the client should wait
+	// before the next attempt will be allowed.
+	Allow(ctx context.Context, key string, rate Rate) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// RateLimitedRoute describes how the ratelimit module finds the pid that a
+// POST to a route is an attempt for. When both fields are empty the route is
+// only throttled by ip address.
+type RateLimitedRoute struct {
+	// Page is passed to the BodyReader to read the pid from the request
+	// body, the values must be a UserValuer or a RecoverStartValuer.
+	Page string
+	// SessionKey is the session key that holds the pid, for routes that
+	// users reach part way through logging in.
+	SessionKey string
+}
+
+// LimitRoute marks a POST route, as registered on the Router, as one that
+// can be used to guess credentials or send e-mails. Modules call it when they
+// register their routes so the ratelimit module doesn't need to know about
+// them. It does nothing unless the ratelimit module is loaded.
+func (a *Authboss) LimitRoute(route string, limited RateLimitedRoute) {
+	if a.rateLimitedRoutes == nil {
+		a.rateLimitedRoutes = make(map[string]RateLimitedRoute)
+	}
+	a.rateLimitedRoutes[route] = limited
+}
+
+// LimitedRoute returns how to throttle route if it was marked with
+// LimitRoute.
+func (a *Authboss) LimitedRoute(route string) (RateLimitedRoute, bool) {
+	limited, ok := a.rateLimitedRoutes[route]
+	return limited, ok
+}
+
+// ClientIP returns the ip address of the client that made the request.
+//
+// If Modules.TrustedProxyHeader is set and present in the request the
+// right-most address in it is used, this is the address the reverse proxy
+// closest to the web application saw. Otherwise the host part of
+// the request's RemoteAddr is used.
+func (a *Authboss) ClientIP(r *http.Request) string {
+	if header := a.Config.Modules.TrustedProxyHeader; len(header) != 0 {
+		if val := r.Header.Get(header); len(val) != 0 {
+			addrs := strings.Split(val, ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+)
+
+// sweepInterval is how often idle buckets are removed from memory
+const sweepInterval = time.Minute
+
+// MemoryLimiter is an in-memory token bucket authboss.RateLimiter. It's not
+// shared between processes, so a load balanced application should use an
+// implementation backed by shared storage instead.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	// now is for testing
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	per    time.Duration
+}
+
+// NewMemoryLimiter constructor
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the key's bucket if there is one.
+func (m *MemoryLimiter) Allow(ctx context.Context, key string, rate authboss.Rate) (bool, time.Duration, error) {
+	if rate.Attempts <= 0 || rate.Per <= 0 {
+		return true, 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rate.Attempts), last: now}
+		m.buckets[key] = b
+	}
+	b.per = rate.Per
+
+	refill := float64(now.Sub(b.last)) / float64(rate.Per) * float64(rate.Attempts)
+	b.tokens += refill
+	if b.tokens > float64(rate.Attempts) {
+		b.tokens = float64(rate.Attempts)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	wait := time.Duration((1 - b.tokens) / float64(rate.Attempts) * float64(rate.Per))
+	return false, wait, nil
+}
+
+// sweep removes buckets that have had time to refill completely since they
+// were last used, they're the same as a new bucket.
+func (m *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for key, b := range m.buckets {
+		if now.Sub(b.last) >= b.per {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	m := NewMemoryLimiter()
+	m.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	rate := authboss.Rate{Attempts: 2, Per: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		allowed, _, err := m.Allow(ctx, "key", rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !allowed {
+			t.Errorf("%d) attempt should have been allowed", i)
+		}
+	}
+
+	allowed, retryAfter, err := m.Allow(ctx, "key", rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Error("attempt over the limit should not have been allowed")
+	}
+	if retryAfter != 30*time.Second {
+		t.Error("retry after was wrong:", retryAfter)
+	}
+
+	if allowed, _, _ = m.Allow(ctx, "other", rate); !allowed {
+		t.Error("other keys should not be affected")
+	}
+
+	now = now.Add(30 * time.Second)
+	if allowed, _, _ = m.Allow(ctx, "key", rate); !allowed {
+		t.Error("a token should have been refilled")
+	}
+	if allowed, _, _ = m.Allow(ctx, "key", rate); allowed {
+		t.Error("only one token should have been refilled")
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	m := NewMemoryLimiter()
+	m.now = func() time.Time { return now }
+
+	rate := authboss.Rate{Attempts: 1, Per: time.Minute}
+	if _, _, err := m.Allow(context.Background(), "key", rate); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, _, err := m.Allow(context.Background(), "other", rate); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.buckets["key"]; ok {
+		t.Error("idle bucket should have been swept")
+	}
+	if _, ok := m.buckets["other"]; !ok {
+		t.Error("new bucket should be present")
+	}
+}
+
+func TestMemoryLimiterUnlimited(t *testing.T) {
+	t.Parallel()
+
+	m := NewMemoryLimiter()
+	for i := 0; i < 10; i++ {
+		if allowed, _, _ := m.Allow(context.Background(), "key", authboss.Rate{}); !allowed {
+			t.Error("a zero rate should not limit")
+		}
+	}
+}
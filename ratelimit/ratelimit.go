@@ -0,0 +1,176 @@
+// Package ratelimit throttles the authboss routes that can be used to guess
+// credentials or send e-mails, by ip address and by ip address and account.
+//
+// When loaded it wraps Config.Core.Router so the routes the other modules
+// mark with Authboss.LimitRoute are throttled regardless of the order modules
+// are loaded in, which means the router should be mounted after Authboss.Init
+// has been called. Attempts are counted in Config.Storage.RateLimiter.
+//
+// Routes are looked up by the path they were registered on the Router with,
+// so they only match if the Router is mounted with the Config.Paths.Mount
+// prefix stripped (see http.StripPrefix) as the rest of authboss expects.
+package ratelimit
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+)
+
+const (
+	// throttledFlash is shown to users who have been throttled
+	throttledFlash = "Too many attempts, please wait a moment and try again."
+
+	// maxBodySize is the most that's read from a request body to find the
+	// pid, larger bodies are only throttled by ip address
+	maxBodySize = 1 << 20
+)
+
+func init() {
+	authboss.RegisterModule("ratelimit", &RateLimit{})
+}
+
+// RateLimit module
+type RateLimit struct {
+	*authboss.Authboss
+}
+
+// Init module
+func (rl *RateLimit) Init(ab *authboss.Authboss) error {
+	rl.Authboss = ab
+
+	if ab.Config.Storage.RateLimiter == nil {
+		ab.Config.Storage.RateLimiter = NewMemoryLimiter()
+	}
+
+	ab.Config.Core.Router = router{Router: ab.Config.Core.Router, rl: rl}
+
+	return nil
+}
+
+// router throttles requests before handing them to the real router
+type router struct {
+	authboss.Router
+	rl *RateLimit
+}
+
+// ServeHTTP throttles the request if it's for one of the throttled routes
+func (rt router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if limited, ok := rt.rl.Authboss.LimitedRoute(r.URL.Path); ok && r.Method == http.MethodPost {
+		throttled, err := rt.rl.Throttle(w, r, rt.rl.pid(r, limited))
+		if err != nil {
+			logger := rt.rl.RequestLogger(r)
+			logger.Errorf("failed to rate limit request: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if throttled {
+			return
+		}
+	}
+
+	rt.Router.ServeHTTP(w, r)
+}
+
+// Throttle counts an attempt for the client's ip address, and if pid is not
+// empty for the ip address and pid together. If either is over its limit the
+// request is responded to and true is returned.
+//
+// In API mode the response is a 429 with a Retry-After header, otherwise
+// the user is redirected back to the page with a flash error.
+func (rl *RateLimit) Throttle(w http.ResponseWriter, r *http.Request, pid string) (bool, error) {
+	limiter := rl.Authboss.Config.Storage.RateLimiter
+	ip := rl.Authboss.ClientIP(r)
+
+	allowed, retryAfter, err := limiter.Allow(r.Context(), "ip:"+ip, rl.Authboss.Config.Modules.RateLimitIP)
+	if err != nil {
+		return false, err
+	}
+
+	if allowed && len(pid) != 0 {
+		allowed, retryAfter, err = limiter.Allow(r.Context(), "ippid:"+ip+";"+pid, rl.Authboss.Config.Modules.RateLimitIPPID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if allowed {
+		return false, nil
+	}
+
+	logger := rl.RequestLogger(r)
+	logger.Infof("throttled %s from %s for %q", r.URL.Path, ip, pid)
+
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+
+	redirect := path.Join(rl.Authboss.Config.Paths.Mount, r.URL.Path)
+	if len(r.URL.RawQuery) != 0 {
+		redirect += "?" + r.URL.RawQuery
+	}
+
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTooManyRequests,
+		Failure:      throttledFlash,
+		RedirectPath: redirect,
+	}
+	return true, rl.Authboss.Config.Core.Redirector.Redirect(w, r, ro)
+}
+
+// pid finds the pid that a request to a limited route is an attempt for
+func (rl *RateLimit) pid(r *http.Request, limited authboss.RateLimitedRoute) string {
+	switch {
+	case len(limited.SessionKey) != 0:
+		pid, _ := authboss.GetSession(r, limited.SessionKey)
+		return pid
+	case len(limited.Page) != 0:
+		return rl.bodyPID(r, limited.Page)
+	}
+
+	return ""
+}
+
+// bodyPID reads the pid from the request body using the BodyReader. The
+// body is buffered so the handler can read it again. At most maxBodySize
+// bytes are buffered, when the body is larger than that it's left for the
+// handler and no pid is returned.
+func (rl *RateLimit) bodyPID(r *http.Request, page string) string {
+	if r.Body == nil {
+		return ""
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		r.Body.Close()
+		return ""
+	}
+	if len(body) > maxBodySize {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return ""
+	}
+	r.Body.Close()
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	validator, err := rl.Authboss.Config.Core.BodyReader.Read(page, r)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	switch v := validator.(type) {
+	case authboss.UserValuer:
+		return v.GetPID()
+	case authboss.RecoverStartValuer:
+		return v.GetPID()
+	}
+
+	return ""
+}
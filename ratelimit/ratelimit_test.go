@@ -0,0 +1,206 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/mocks"
+)
+
+type testHarness struct {
+	rl *RateLimit
+	ab *authboss.Authboss
+
+	bodyReader *mocks.BodyReader
+	redirector *mocks.Redirector
+	router     *mocks.Router
+	session    *mocks.ClientStateRW
+}
+
+func testSetup() *testHarness {
+	harness := &testHarness{}
+
+	harness.ab = authboss.New()
+	harness.bodyReader = &mocks.BodyReader{}
+	harness.redirector = &mocks.Redirector{}
+	harness.router = &mocks.Router{}
+	harness.session = mocks.NewClientRW()
+
+	harness.ab.Paths.Mount = "/auth"
+	harness.ab.Modules.RateLimitIP = authboss.Rate{Attempts: 3, Per: time.Minute}
+	harness.ab.Modules.RateLimitIPPID = authboss.Rate{Attempts: 1, Per: time.Minute}
+
+	harness.ab.Config.Core.BodyReader = harness.bodyReader
+	harness.ab.Config.Core.Logger = mocks.Logger{}
+	harness.ab.Config.Core.Redirector = harness.redirector
+	harness.ab.Config.Core.Router = harness.router
+	harness.ab.Config.Storage.SessionState = harness.session
+
+	harness.rl = &RateLimit{}
+	if err := harness.rl.Init(harness.ab); err != nil {
+		panic(err)
+	}
+
+	// Routes that other modules would mark
+	harness.ab.LimitRoute("/login", authboss.RateLimitedRoute{Page: "login"})
+	harness.ab.LimitRoute("/recover", authboss.RateLimitedRoute{Page: "recover_start"})
+	harness.ab.LimitRoute("/2fa/totp/validate", authboss.RateLimitedRoute{SessionKey: "totp_pending"})
+
+	return harness
+}
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	if harness.ab.Config.Storage.RateLimiter == nil {
+		t.Error("a default rate limiter should have been set")
+	}
+
+	// Routes registered after Init must still reach the real router
+	harness.ab.Config.Core.Router.Post("/login", http.NotFoundHandler())
+	if err := harness.router.HasPosts("/login"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestThrottleIPPID(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+
+	send := func(pid string) *httptest.ResponseRecorder {
+		harness.bodyReader.Return = mocks.Values{PID: pid}
+		r := httptest.NewRequest("POST", "/login?redir=%2Fhome", nil)
+		w := httptest.NewRecorder()
+		harness.ab.Config.Core.Router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := send("a@a.com"); w.Code == http.StatusTooManyRequests {
+		t.Error("first attempt should not be throttled")
+	}
+
+	w := send("a@a.com")
+	if w.Code != http.StatusTooManyRequests {
+		t.Error("second attempt for the same pid should be throttled, got:", w.Code)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "60" {
+		t.Error("retry after header was wrong:", retry)
+	}
+	if p := harness.redirector.Options.RedirectPath; p != "/auth/login?redir=%2Fhome" {
+		t.Error("redirect path was wrong:", p)
+	}
+	if len(harness.redirector.Options.Failure) == 0 {
+		t.Error("there should be a failure message")
+	}
+
+	if w := send("b@b.com"); w.Code == http.StatusTooManyRequests {
+		t.Error("another pid should not be throttled yet")
+	}
+	if w := send("c@c.com"); w.Code != http.StatusTooManyRequests {
+		t.Error("the ip should be throttled after 3 attempts, got:", w.Code)
+	}
+}
+
+func TestThrottleUnthrottledRoutes(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Modules.RateLimitIP = authboss.Rate{Attempts: 1, Per: time.Minute}
+
+	for i := 0; i < 3; i++ {
+		for _, req := range []*http.Request{
+			httptest.NewRequest("GET", "/login", nil),
+			httptest.NewRequest("POST", "/logout", nil),
+		} {
+			w := httptest.NewRecorder()
+			harness.ab.Config.Core.Router.ServeHTTP(w, req)
+			if w.Code == http.StatusTooManyRequests {
+				t.Errorf("%s %s should not be throttled", req.Method, req.URL.Path)
+			}
+		}
+	}
+}
+
+func TestThrottleTrustedProxy(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.ab.Modules.TrustedProxyHeader = "X-Forwarded-For"
+	harness.ab.Modules.RateLimitIP = authboss.Rate{Attempts: 1, Per: time.Minute}
+
+	send := func(forwarded string) int {
+		r := httptest.NewRequest("POST", "/recover", nil)
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		harness.ab.Config.Core.Router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := send("1.1.1.1, 10.0.0.1"); code == http.StatusTooManyRequests {
+		t.Error("first attempt should not be throttled")
+	}
+	if code := send("2.2.2.2, 10.0.0.2"); code == http.StatusTooManyRequests {
+		t.Error("different client address should not be throttled")
+	}
+	if code := send("3.3.3.3, 10.0.0.1"); code != http.StatusTooManyRequests {
+		t.Error("only the right-most address should be trusted, got:", code)
+	}
+}
+
+func TestThrottleSessionPID(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.session.ClientValues["totp_pending"] = "a@a.com"
+
+	send := func() int {
+		r := httptest.NewRequest("POST", "/2fa/totp/validate", nil)
+		w := harness.ab.NewResponse(httptest.NewRecorder())
+		r, err := harness.ab.LoadClientState(w, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		harness.ab.Config.Core.Router.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	if code := send(); code == http.StatusTooManyRequests {
+		t.Error("first attempt should not be throttled")
+	}
+	if code := send(); code != http.StatusTooManyRequests {
+		t.Error("second attempt for the pending pid should be throttled, got:", code)
+	}
+}
+
+func TestBodyPIDLimit(t *testing.T) {
+	t.Parallel()
+
+	harness := testSetup()
+	harness.bodyReader.Return = mocks.Values{PID: "a@a.com"}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader("email=a@a.com"))
+	if pid := harness.rl.bodyPID(r, "login"); pid != "a@a.com" {
+		t.Error("pid was wrong:", pid)
+	}
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != "email=a@a.com" {
+		t.Error("body should be readable again, got:", string(body))
+	}
+
+	large := strings.Repeat("a", maxBodySize+10)
+	r = httptest.NewRequest("POST", "/login", strings.NewReader(large))
+	if pid := harness.rl.bodyPID(r, "login"); len(pid) != 0 {
+		t.Error("a body over the limit should not be read for a pid, got:", pid)
+	}
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != large {
+		t.Error("the whole body should be left for the handler, got length:", len(body))
+	}
+}
@@ -0,0 +1,16 @@
+package authboss
+
+// RecoverChannel is how password recovery is delivered to a user, see
+// Config.Modules.RecoverChannel.
+type RecoverChannel int
+
+// Recover channels
+const (
+	// RecoverChannelEmail always e-mails the user a link
+	RecoverChannelEmail RecoverChannel = iota
+	// RecoverChannelSMS texts a code to users that have an sms2fa phone
+	// number, and e-mails everyone else
+	RecoverChannelSMS
+	// RecoverChannelUser lets the user choose between e-mail and sms
+	RecoverChannelUser
+)
@@ -23,8 +23,12 @@ import (
 const (
 	DataRecoverToken = "recover_token"
 	DataRecoverURL   = "recover_url"
+	// DataRecover2FA is true when the user must also enter a totp
+	// code or a recovery code to reset their password
+	DataRecover2FA = "recover_2fa"
 
 	FormValueToken = "token"
+	FormValueCode  = "code"
 
 	EmailRecoverHTML = "recover_html"
 	EmailRecoverTxt  = "recover_txt"
@@ -63,15 +67,26 @@ func (r *Recover) Init(ab *authboss.Authboss) (err error) {
 
 	r.Authboss.Config.Core.Router.Get("/recover", r.Core.ErrorHandler.Wrap(r.StartGet))
 	r.Authboss.Config.Core.Router.Post("/recover", r.Core.ErrorHandler.Wrap(r.StartPost))
+	r.Authboss.LimitRoute("/recover", authboss.RateLimitedRoute{Page: PageRecoverStart})
 	r.Authboss.Config.Core.Router.Get("/recover/end", r.Core.ErrorHandler.Wrap(r.EndGet))
 	r.Authboss.Config.Core.Router.Post("/recover/end", r.Core.ErrorHandler.Wrap(r.EndPost))
+	r.Authboss.LimitRoute("/recover/end", authboss.RateLimitedRoute{})
+
+	if r.Authboss.Config.Modules.RecoverChannel != authboss.RecoverChannelEmail || r.Authboss.Config.Modules.RecoverSMSSender != nil {
+		return r.initCode()
+	}
 
 	return nil
 }
 
 // StartGet starts the recover procedure by rendering a form for the user.
 func (r *Recover) StartGet(w http.ResponseWriter, req *http.Request) error {
-	return r.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverStart, nil)
+	challenge, err := r.Authboss.ChallengeData(req, "")
+	if err != nil {
+		return err
+	}
+
+	return r.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverStart, challenge)
 }
 
 // StartPost starts the recover procedure using values provided from the user
@@ -86,26 +101,47 @@ func (r *Recover) StartPost(w http.ResponseWriter, req *http.Request) error {
 
 	if errs := validatable.Validate(); errs != nil {
 		logger.Info("recover validation failed")
+		challenge, err := r.Authboss.ChallengeData(req, "")
+		if err != nil {
+			return err
+		}
 		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
-		return r.Authboss.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverStart, data)
+		return r.Authboss.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverStart, data.Merge(challenge))
 	}
 
 	recoverVals := authboss.MustHaveRecoverStartValues(validatable)
 
+	challenge, ok, err := r.Authboss.CheckChallenge(req, recoverVals.GetPID(), validatable)
+	if err != nil {
+		return err
+	} else if !ok {
+		logger.Infof("user %s failed to complete the recover challenge", recoverVals.GetPID())
+		return r.Authboss.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverStart, challenge)
+	}
+
+	viaSMS := r.wantsSMS(validatable)
+
 	user, err := r.Authboss.Storage.Server.Load(req.Context(), recoverVals.GetPID())
 	if err == authboss.ErrUserNotFound {
 		logger.Infof("user %s was attempted to be recovered, user does not exist, faking successful response", recoverVals.GetPID())
-		ro := authboss.RedirectOptions{
-			Code:         http.StatusTemporaryRedirect,
-			RedirectPath: r.Authboss.Config.Paths.RecoverOK,
-			Success:      recoverInitiateSuccessFlash,
-		}
-		return r.Authboss.Core.Redirector.Redirect(w, req, ro)
+		return r.startRedirect(w, req, viaSMS, "")
 	}
 
 	ru := authboss.MustBeRecoverable(user)
 
 	req = req.WithContext(context.WithValue(req.Context(), authboss.CTXKeyUser, user))
+
+	throttled, err := r.throttled(req, ru)
+	if err != nil {
+		return err
+	} else if throttled {
+		if _, err = r.Authboss.Events.FireAfter(authboss.EventRecoverThrottled, w, req); err != nil {
+			return err
+		}
+
+		return r.startRedirect(w, req, viaSMS, "")
+	}
+
 	handled, err := r.Authboss.Events.FireBefore(authboss.EventRecoverStart, w, req)
 	if err != nil {
 		return err
@@ -113,6 +149,20 @@ func (r *Recover) StartPost(w http.ResponseWriter, req *http.Request) error {
 		return nil
 	}
 
+	if number := phoneNumber(ru); viaSMS && len(number) != 0 {
+		token, err := r.startCode(req.Context(), ru, number)
+		if err != nil {
+			return err
+		}
+
+		if _, err = r.Authboss.Events.FireAfter(authboss.EventRecoverStart, w, req); err != nil {
+			return err
+		}
+
+		logger.Infof("user %s password recovery by text message initiated", ru.GetPID())
+		return r.startRedirect(w, req, viaSMS, token)
+	}
+
 	selector, verifier, token, err := GenerateRecoverCreds()
 	if err != nil {
 		return err
@@ -121,6 +171,13 @@ func (r *Recover) StartPost(w http.ResponseWriter, req *http.Request) error {
 	ru.PutRecoverSelector(selector)
 	ru.PutRecoverVerifier(verifier)
 	ru.PutRecoverExpiry(time.Now().UTC().Add(r.Config.Modules.RecoverTokenDuration))
+	if rru, ok := ru.(authboss.RecoverResendableUser); ok {
+		rru.PutRecoverLastSent(time.Now().UTC())
+	}
+	if rcu, ok := ru.(authboss.RecoverCodeUser); ok {
+		rcu.PutRecoverAttempts(0)
+		rcu.PutRecoverPhoneVerified(false)
+	}
 
 	if err := r.Authboss.Storage.Server.Save(req.Context(), ru); err != nil {
 		return err
@@ -138,12 +195,38 @@ func (r *Recover) StartPost(w http.ResponseWriter, req *http.Request) error {
 	}
 
 	logger.Infof("user %s password recovery initiated", ru.GetPID())
-	ro := authboss.RedirectOptions{
-		Code:         http.StatusTemporaryRedirect,
-		RedirectPath: r.Authboss.Config.Paths.RecoverOK,
-		Success:      recoverInitiateSuccessFlash,
+	return r.startRedirect(w, req, viaSMS, "")
+}
+
+// throttled checks if a recovery e-mail was sent to the user too recently
+// or too many were requested by the client's ip address. It's logged here
+// since the response must look the same as when an e-mail is sent.
+func (r *Recover) throttled(req *http.Request, ru authboss.RecoverableUser) (bool, error) {
+	logger := r.RequestLogger(req)
+
+	if rru, ok := ru.(authboss.RecoverResendableUser); ok {
+		next := rru.GetRecoverLastSent().Add(r.Authboss.Config.Modules.RecoverMinInterval)
+		if time.Now().UTC().Before(next) {
+			logger.Infof("user %s recovery e-mail suppressed, one was sent too recently, faking successful response", ru.GetPID())
+			return true, nil
+		}
+	}
+
+	limiter := r.Authboss.Config.Storage.RateLimiter
+	if limiter == nil || r.Authboss.Config.Modules.RecoverRateIP.Attempts <= 0 {
+		return false, nil
+	}
+
+	ip := r.Authboss.ClientIP(req)
+	allowed, _, err := limiter.Allow(req.Context(), "recover:"+ip, r.Authboss.Config.Modules.RecoverRateIP)
+	if err != nil {
+		return false, err
+	} else if !allowed {
+		logger.Infof("user %s recovery e-mail suppressed, too many were requested from %s, faking successful response", ru.GetPID(), ip)
+		return true, nil
 	}
-	return r.Authboss.Core.Redirector.Redirect(w, req, ro)
+
+	return false, nil
 }
 
 // SendRecoverEmail to a specific e-mail address passing along the encodedToken
@@ -189,6 +272,13 @@ func (r *Recover) EndGet(w http.ResponseWriter, req *http.Request) error {
 		DataRecoverToken: token,
 	}
 
+	user, err := r.verifyToken(req, token)
+	if err != nil {
+		return err
+	} else if user != nil && r.requiresSecondFactor(user) {
+		data[DataRecover2FA] = true
+	}
+
 	return r.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverEnd, data)
 }
 
@@ -214,45 +304,35 @@ func (r *Recover) EndPost(w http.ResponseWriter, req *http.Request) error {
 		return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverEnd, data)
 	}
 
-	rawToken, err := base64.URLEncoding.DecodeString(token)
+	user, err := r.verifyToken(req, token)
 	if err != nil {
-		logger.Infof("invalid recover token submitted, base64 decode failed: %+v", err)
-		return r.invalidToken(PageRecoverEnd, w, req)
-	}
-
-	if len(rawToken) != recoverTokenSize {
-		logger.Infof("invalid recover token submitted, size was wrong: %d", len(rawToken))
-		return r.invalidToken(PageRecoverEnd, w, req)
-	}
-
-	selectorBytes := sha512.Sum512(rawToken[:recoverTokenSplit])
-	verifierBytes := sha512.Sum512(rawToken[recoverTokenSplit:])
-	selector := base64.StdEncoding.EncodeToString(selectorBytes[:])
-
-	storer := authboss.EnsureCanRecover(r.Authboss.Config.Storage.Server)
-	user, err := storer.LoadByRecoverSelector(req.Context(), selector)
-	if err == authboss.ErrUserNotFound {
-		logger.Info("invalid recover token submitted, user not found")
-		return r.invalidToken(PageRecoverEnd, w, req)
-	} else if err != nil {
 		return err
-	}
-
-	if time.Now().UTC().After(user.GetRecoverExpiry()) {
-		logger.Infof("invalid recover token submitted, already expired: %+v", err)
+	} else if user == nil {
 		return r.invalidToken(PageRecoverEnd, w, req)
 	}
 
-	dbVerifierBytes, err := base64.StdEncoding.DecodeString(user.GetRecoverVerifier())
-	if err != nil {
-		logger.Infof("invalid recover verifier stored in database: %s", user.GetRecoverVerifier())
-		return r.invalidToken(PageRecoverEnd, w, req)
-	}
+	if r.requiresSecondFactor(user) {
+		if number := r.textableSecondFactor(user, validatable); len(number) != 0 {
+			return r.textSecondFactor(w, req, user, number)
+		}
 
-	if subtle.ConstantTimeEq(int32(len(verifierBytes)), int32(len(dbVerifierBytes))) != 1 ||
-		subtle.ConstantTimeCompare(verifierBytes[:], dbVerifierBytes) != 1 {
-		logger.Info("stored recover verifier does not match provided one")
-		return r.invalidToken(PageRecoverEnd, w, req)
+		if status := r.useSecondFactor(user, validatable); len(status) != 0 {
+			logger.Infof("user %s failed the second factor during recovery: %s", user.GetPID(), status)
+			if status != secondFactorRequired {
+				if tooMany, err := r.secondFactorFailed(req, user); err != nil {
+					return err
+				} else if tooMany {
+					return r.invalidToken(PageRecoverEnd, w, req)
+				}
+			}
+
+			data := authboss.HTMLData{
+				authboss.DataValidation: map[string][]string{FormValueCode: {status}},
+				DataRecoverToken:        token,
+				DataRecover2FA:          true,
+			}
+			return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverEnd, data)
+		}
 	}
 
 	req = req.WithContext(context.WithValue(req.Context(), authboss.CTXKeyUser, user))
@@ -272,14 +352,27 @@ func (r *Recover) EndPost(w http.ResponseWriter, req *http.Request) error {
 	user.PutRecoverSelector("")             // Don't allow another recovery
 	user.PutRecoverVerifier("")             // Don't allow another recovery
 	user.PutRecoverExpiry(time.Now().UTC()) // Put current time for those DBs that can't handle 0 time
+	if rcu, ok := user.(authboss.RecoverCodeUser); ok {
+		rcu.PutRecoverAttempts(0)
+		rcu.PutRecoverPhoneVerified(false)
+	}
+	if err := authboss.BumpSecurityStamp(user); err != nil {
+		return err
+	}
+
+	if err := r.Authboss.Config.Storage.Server.Save(req.Context(), user); err != nil {
+		return err
+	}
 
-	if err := storer.Save(req.Context(), user); err != nil {
+	_, err = r.Authboss.Events.FireAfter(authboss.EventPasswordChange, w, req)
+	if err != nil {
 		return err
 	}
 
 	successMsg := "Successfully updated password"
 	if r.Authboss.Config.Modules.RecoverLoginAfterRecovery {
 		authboss.PutSession(w, authboss.SessionKey, user.GetPID())
+		authboss.PutSessionSecurityStamp(w, user)
 		successMsg += " and logged in"
 	}
 
@@ -296,6 +389,55 @@ func (r *Recover) EndPost(w http.ResponseWriter, req *http.Request) error {
 	return r.Authboss.Config.Core.Redirector.Redirect(w, req, ro)
 }
 
+// verifyToken loads the user that a recover token belongs to. If the token
+// is invalid or has expired the returned user is nil.
+func (r *Recover) verifyToken(req *http.Request, token string) (authboss.RecoverableUser, error) {
+	logger := r.RequestLogger(req)
+
+	rawToken, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		logger.Infof("invalid recover token submitted, base64 decode failed: %+v", err)
+		return nil, nil
+	}
+
+	if len(rawToken) != recoverTokenSize {
+		logger.Infof("invalid recover token submitted, size was wrong: %d", len(rawToken))
+		return nil, nil
+	}
+
+	selectorBytes := sha512.Sum512(rawToken[:recoverTokenSplit])
+	verifierBytes := sha512.Sum512(rawToken[recoverTokenSplit:])
+	selector := base64.StdEncoding.EncodeToString(selectorBytes[:])
+
+	storer := authboss.EnsureCanRecover(r.Authboss.Config.Storage.Server)
+	user, err := storer.LoadByRecoverSelector(req.Context(), selector)
+	if err == authboss.ErrUserNotFound {
+		logger.Info("invalid recover token submitted, user not found")
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if time.Now().UTC().After(user.GetRecoverExpiry()) {
+		logger.Infof("invalid recover token submitted, already expired: %+v", err)
+		return nil, nil
+	}
+
+	dbVerifierBytes, err := base64.StdEncoding.DecodeString(user.GetRecoverVerifier())
+	if err != nil {
+		logger.Infof("invalid recover verifier stored in database: %s", user.GetRecoverVerifier())
+		return nil, nil
+	}
+
+	if subtle.ConstantTimeEq(int32(len(verifierBytes)), int32(len(dbVerifierBytes))) != 1 ||
+		subtle.ConstantTimeCompare(verifierBytes[:], dbVerifierBytes) != 1 {
+		logger.Info("stored recover verifier does not match provided one")
+		return nil, nil
+	}
+
+	return user, nil
+}
+
 func (r *Recover) invalidToken(page string, w http.ResponseWriter, req *http.Request) error {
 	errorsAll := []error{errors.New("recovery token is invalid")}
 	data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errorsAll)}
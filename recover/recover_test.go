@@ -2,6 +2,7 @@ package recover
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha512"
 	"encoding/base64"
 	"errors"
@@ -11,8 +12,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pquerna/otp/totp"
+
 	"github.com/p000ic/authboss-echo"
 	"github.com/p000ic/authboss-echo/mocks"
+	"github.com/p000ic/authboss-echo/otp/twofactor"
+	"github.com/p000ic/authboss-echo/otp/twofactor/totp2fa"
 )
 
 const (
@@ -53,6 +58,12 @@ func TestInit(t *testing.T) {
 	if err := router.HasPosts("/recover", "/recover/end"); err != nil {
 		t.Error(err)
 	}
+	if limited, ok := ab.LimitedRoute("/recover"); !ok || limited.Page != PageRecoverStart {
+		t.Error("recover should be rate limited by the pid in the body:", limited)
+	}
+	if _, ok := ab.LimitedRoute("/recover/end"); !ok {
+		t.Error("recover end should be rate limited")
+	}
 }
 
 type testHarness struct {
@@ -268,13 +279,15 @@ func TestEndPostSuccessLogin(t *testing.T) {
 	h.bodyReader.Return = &mocks.Values{
 		Token: testToken,
 	}
-	h.storer.Users["test@test.com"] = &mocks.User{
+	user := &mocks.User{
 		Email:              "test@test.com",
 		Password:           "to-overwrite",
 		RecoverSelector:    testSelector,
 		RecoverVerifier:    testVerifier,
 		RecoverTokenExpiry: time.Now().UTC().AddDate(0, 0, 1),
+		SecurityStamp:      "old",
 	}
+	h.storer.Users["test@test.com"] = user
 
 	r := mocks.Request("POST")
 	w := httptest.NewRecorder()
@@ -295,6 +308,12 @@ func TestEndPostSuccessLogin(t *testing.T) {
 	if !strings.Contains(h.redirector.Options.Success, "logged in") {
 		t.Error("should talk about logging in")
 	}
+	if user.SecurityStamp == "old" {
+		t.Error("security stamp should have been bumped")
+	}
+	if stamp := h.session.ClientValues[authboss.SessionSecurityStamp]; stamp != user.SecurityStamp {
+		t.Error("session should have the new security stamp:", stamp)
+	}
 }
 
 func TestEndPostValidationFailure(t *testing.T) {
@@ -484,3 +503,410 @@ func TestGenerateRecoverCreds(t *testing.T) {
 		t.Error("expected verifier to match")
 	}
 }
+
+func TestStartPostChallenge(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Core.ChallengeVerifier = &mocks.ChallengeVerifier{Challenge: "challenge", Response: "solved"}
+	h.ab.Config.Modules.ChallengeAfter = 0
+
+	h.bodyReader.Return = &mocks.Values{PID: "test@test.com"}
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.recover.StartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageRecoverStart {
+		t.Error("page was wrong:", h.responder.Page)
+	}
+	if h.responder.Data[authboss.DataChallengeRequired] != true {
+		t.Error("a challenge should be rendered:", h.responder.Data)
+	}
+	if len(h.mailer.Email.To) != 0 {
+		t.Error("should not have sent an e-mail out")
+	}
+}
+
+type testLimiter struct {
+	allowed bool
+	keys    []string
+}
+
+func (t *testLimiter) Allow(_ context.Context, key string, _ authboss.Rate) (bool, time.Duration, error) {
+	t.keys = append(t.keys, key)
+	return t.allowed, time.Minute, nil
+}
+
+func TestStartPostThrottled(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Name     string
+		LastSent time.Time
+		Allowed  bool
+		Sent     bool
+	}{
+		{"Allowed", time.Now().UTC().Add(-time.Hour), true, true},
+		{"Account", time.Now().UTC().Add(-time.Minute), true, false},
+		{"IP", time.Time{}, false, false},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			h := testSetup()
+			limiter := &testLimiter{allowed: test.Allowed}
+			h.ab.Config.Storage.RateLimiter = limiter
+
+			user := &mocks.User{Email: "test@test.com", RecoverLastSent: test.LastSent}
+			h.storer.Users["test@test.com"] = user
+			h.bodyReader.Return = &mocks.Values{PID: "test@test.com"}
+
+			throttled := false
+			h.ab.Events.After(authboss.EventRecoverThrottled, func(_ http.ResponseWriter, r *http.Request, _ bool) (bool, error) {
+				throttled = r.Context().Value(authboss.CTXKeyUser) != nil
+				return false, nil
+			})
+
+			r := mocks.Request("POST")
+			w := httptest.NewRecorder()
+
+			if err := h.recover.StartPost(w, r); err != nil {
+				t.Fatal(err)
+			}
+
+			if sent := len(h.mailer.Email.To) != 0; sent != test.Sent {
+				t.Error("e-mail sent should be:", test.Sent)
+			}
+			if throttled == test.Sent {
+				t.Error("throttled event fired should be:", !test.Sent)
+			}
+			if test.Sent && time.Since(user.RecoverLastSent) > time.Minute {
+				t.Error("the time the e-mail was sent should have been stored")
+			}
+			if !test.Sent && len(user.RecoverSelector) != 0 {
+				t.Error("no recover token should have been stored")
+			}
+
+			if h.redirector.Options.RedirectPath != h.ab.Config.Paths.RecoverOK {
+				t.Error("redirect path was wrong:", h.redirector.Options.RedirectPath)
+			}
+			if h.redirector.Options.Success != recoverInitiateSuccessFlash {
+				t.Error("response should look successful:", h.redirector.Options.Success)
+			}
+		})
+	}
+}
+
+func twoFactorSetup(t *testing.T) (*testHarness, *mocks.User, string) {
+	h := testSetup()
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "authboss", AccountName: "test@test.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &mocks.User{
+		Email:              "test@test.com",
+		Password:           "to-overwrite",
+		RecoverSelector:    testSelector,
+		RecoverVerifier:    testVerifier,
+		RecoverTokenExpiry: time.Now().UTC().AddDate(0, 0, 1),
+		TOTPSecretKey:      key.Secret(),
+	}
+	h.storer.Users["test@test.com"] = user
+
+	return h, user, key.Secret()
+}
+
+func TestEndGet2FA(t *testing.T) {
+	t.Parallel()
+
+	h, _, _ := twoFactorSetup(t)
+	h.bodyReader.Return = &mocks.Values{Token: testToken}
+
+	r := mocks.Request("GET")
+	w := httptest.NewRecorder()
+
+	if err := h.recover.EndGet(w, r); err != nil {
+		t.Error(err)
+	}
+
+	if required, ok := h.responder.Data[DataRecover2FA].(bool); !ok || !required {
+		t.Errorf("should require a second factor: %#v", h.responder.Data)
+	}
+}
+
+func TestEndPost2FARequired(t *testing.T) {
+	t.Parallel()
+
+	h, user, _ := twoFactorSetup(t)
+	h.bodyReader.Return = &mocks.Values{Token: testToken}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.recover.EndPost(w, r); err != nil {
+		t.Error(err)
+	}
+
+	if h.responder.Page != PageRecoverEnd {
+		t.Error("page was wrong:", h.responder.Page)
+	}
+	errs := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if errs[FormValueCode][0] != secondFactorRequired {
+		t.Error("error was wrong:", errs)
+	}
+	if h.responder.Data[DataRecoverToken].(string) != testToken {
+		t.Error("token should be kept")
+	}
+	if user.Password != "to-overwrite" {
+		t.Error("password should not have changed")
+	}
+	if len(user.RecoverSelector) == 0 {
+		t.Error("recover token should still be usable")
+	}
+}
+
+func TestEndPost2FAInvalidCode(t *testing.T) {
+	t.Parallel()
+
+	h, user, _ := twoFactorSetup(t)
+	h.bodyReader.Return = &mocks.Values{Token: testToken, Code: "000000x"}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.recover.EndPost(w, r); err != nil {
+		t.Error(err)
+	}
+
+	errs := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if errs[FormValueCode][0] != secondFactorInvalidCode {
+		t.Error("error was wrong:", errs)
+	}
+	if user.Password != "to-overwrite" {
+		t.Error("password should not have changed")
+	}
+}
+
+func TestEndPost2FATOTP(t *testing.T) {
+	t.Parallel()
+
+	h, user, secret := twoFactorSetup(t)
+
+	code, err := totp.GenerateCode(secret, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.bodyReader.Return = &mocks.Values{Token: testToken, Code: code}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.recover.EndPost(w, r); err != nil {
+		t.Error(err)
+	}
+
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Error("code was wrong:", w.Code)
+	}
+	if user.Password == "to-overwrite" {
+		t.Error("password should have changed")
+	}
+	if user.TOTPLastCode != code {
+		t.Error("totp code should be recorded as used")
+	}
+}
+
+func TestEndPost2FARecoveryCode(t *testing.T) {
+	t.Parallel()
+
+	h, user, _ := twoFactorSetup(t)
+	user.TOTPSecretKey = ""
+	user.SMSPhoneNumber = "number"
+
+	codes, err := twofactor.GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed, err := twofactor.BCryptRecoveryCodes(codes[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.RecoveryCodes = twofactor.EncodeRecoveryCodes(hashed)
+
+	h.bodyReader.Return = &mocks.Values{Token: testToken, Recovery: codes[1]}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.recover.EndPost(w, r); err != nil {
+		t.Error(err)
+	}
+
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Error("code was wrong:", w.Code)
+	}
+	if user.Password == "to-overwrite" {
+		t.Error("password should have changed")
+	}
+	if user.RecoveryCodes != hashed[0] {
+		t.Error("recovery code should have been used up")
+	}
+}
+
+func TestEndPost2FASkip(t *testing.T) {
+	t.Parallel()
+
+	h, user, _ := twoFactorSetup(t)
+	h.ab.Config.Modules.RecoverSkip2FA = true
+	h.bodyReader.Return = &mocks.Values{Token: testToken}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.recover.EndPost(w, r); err != nil {
+		t.Error(err)
+	}
+
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Error("code was wrong:", w.Code)
+	}
+	if user.Password == "to-overwrite" {
+		t.Error("password should have changed")
+	}
+}
+
+func TestEndPost2FAAttempts(t *testing.T) {
+	t.Parallel()
+
+	h, user, _ := twoFactorSetup(t)
+	h.ab.Config.Modules.RecoverCodeAttempts = 2
+	h.bodyReader.Return = &mocks.Values{Token: testToken, Code: "000000x"}
+
+	post := func() {
+		r := mocks.Request("POST")
+		w := httptest.NewRecorder()
+		if err := h.recover.EndPost(w, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post()
+	if user.RecoverAttempts != 1 {
+		t.Error("the wrong code should have been counted:", user.RecoverAttempts)
+	}
+	if len(user.RecoverSelector) == 0 {
+		t.Error("recover token should still be usable")
+	}
+
+	post()
+	if len(user.RecoverSelector) != 0 || len(user.RecoverVerifier) != 0 {
+		t.Error("recover token should have been deleted")
+	}
+	errs := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if len(errs[""]) == 0 {
+		t.Error("the token should be reported as invalid:", errs)
+	}
+	if user.Password != "to-overwrite" {
+		t.Error("password should not have changed")
+	}
+}
+
+// plainUser is a totp user that isn't a RecoverCodeUser
+type plainUser struct {
+	authboss.RecoverableUser
+	totp2fa.User
+}
+
+func (p plainUser) GetPID() string        { return p.RecoverableUser.GetPID() }
+func (p plainUser) PutPID(pid string)     { p.RecoverableUser.PutPID(pid) }
+func (p plainUser) GetEmail() string      { return p.RecoverableUser.GetEmail() }
+func (p plainUser) PutEmail(email string) { p.RecoverableUser.PutEmail(email) }
+
+// plainStorer loads users as plainUsers
+type plainStorer struct {
+	*mocks.ServerStorer
+}
+
+func (p plainStorer) LoadByRecoverSelector(ctx context.Context, selector string) (authboss.RecoverableUser, error) {
+	user, err := p.ServerStorer.LoadByRecoverSelector(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+	return plainUser{user, user.(*mocks.User)}, nil
+}
+
+func (p plainStorer) Save(ctx context.Context, user authboss.User) error {
+	return p.ServerStorer.Save(ctx, user.(plainUser).RecoverableUser.(*mocks.User))
+}
+
+func TestEndPost2FAInvalidCodeNoAttempts(t *testing.T) {
+	t.Parallel()
+
+	h, user, _ := twoFactorSetup(t)
+	h.ab.Config.Storage.Server = plainStorer{h.storer}
+	h.bodyReader.Return = &mocks.Values{Token: testToken, Code: "000000x"}
+
+	r := mocks.Request("POST")
+	w := httptest.NewRecorder()
+
+	if err := h.recover.EndPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if errs[FormValueCode][0] != secondFactorInvalidCode {
+		t.Error("error was wrong:", errs)
+	}
+	if user.Password != "to-overwrite" {
+		t.Error("password should not have changed")
+	}
+	if len(user.RecoverSelector) == 0 {
+		t.Error("recover token should still be usable")
+	}
+}
+
+func TestEndPost2FAText(t *testing.T) {
+	t.Parallel()
+
+	h, user, _ := twoFactorSetup(t)
+	sender := &testSMSSender{}
+	h.ab.Config.Modules.RecoverSMSSender = sender
+	h.ab.Paths.Mount = "/auth"
+	user.TOTPSecretKey = ""
+	user.SMSPhoneNumber = "555-5555"
+	h.bodyReader.Return = &mocks.Values{Token: testToken, Password: "new"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if err := h.recover.EndPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if sender.number != "555-5555" {
+		t.Error("a code should have been texted to the user:", sender.number)
+	}
+	if user.RecoverSelector == testSelector {
+		t.Error("the e-mailed token should have been swapped for the code")
+	}
+	if len(h.session.ClientValues[SessionRecoverSelector]) == 0 {
+		t.Error("the selector should have been put in the session")
+	}
+	if p := h.redirector.Options.RedirectPath; p != "/auth/recover/code" {
+		t.Error("redirect path was wrong:", p)
+	}
+	if user.Password != "to-overwrite" {
+		t.Error("password should not have changed")
+	}
+}
@@ -0,0 +1,241 @@
+package recover
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/otp/twofactor/sms2fa"
+)
+
+// Constants for recovering with a code sent by text message
+const (
+	// PageRecoverCode is the page for entering a recovery code
+	PageRecoverCode = "recover_code"
+
+	// SessionRecoverSelector is the session key holding the selector for
+	// the recovery code that was last sent, so the user only has to enter
+	// the code.
+	SessionRecoverSelector = "recover_selector"
+
+	// ChannelEmail and ChannelSMS are the values a user can choose between
+	// when Config.Modules.RecoverChannel is RecoverChannelUser
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+
+	recoverCodeSuccessFlash = "If your account exists a recovery code has been sent to your phone, or an e-mail if it has no phone number."
+	recoverCodeInvalid      = "The recovery code is invalid."
+	recoverCodeExpired      = "The recovery code has expired, please ask for a new one."
+	recoverCodeAttempts     = "Too many wrong codes were entered, please ask for a new one."
+)
+
+// ChannelValuer optionally provides the channel the user chose to
+// recover with, see ChannelEmail and ChannelSMS.
+type ChannelValuer interface {
+	GetChannel() string
+}
+
+// initCode sets up the routes and templates for recovering with a code
+func (r *Recover) initCode() error {
+	if r.Authboss.Config.Modules.RecoverSMSSender == nil {
+		return fmt.Errorf("recover: RecoverSMSSender must be set to recover by text message")
+	}
+
+	if err := r.Authboss.Config.Core.ViewRenderer.Load(PageRecoverCode); err != nil {
+		return err
+	}
+
+	r.Authboss.Config.Core.Router.Get("/recover/code", r.Core.ErrorHandler.Wrap(r.CodeGet))
+	r.Authboss.Config.Core.Router.Post("/recover/code", r.Core.ErrorHandler.Wrap(r.CodePost))
+	r.Authboss.LimitRoute("/recover/code", authboss.RateLimitedRoute{SessionKey: SessionRecoverSelector})
+
+	return nil
+}
+
+// wantsSMS is true if a code should be texted rather than a link e-mailed,
+// for the users that have a phone number.
+func (r *Recover) wantsSMS(validatable authboss.Validator) bool {
+	switch r.Authboss.Config.Modules.RecoverChannel {
+	case authboss.RecoverChannelSMS:
+		return true
+	case authboss.RecoverChannelUser:
+		cv, ok := validatable.(ChannelValuer)
+		return ok && cv.GetChannel() == ChannelSMS
+	}
+
+	return false
+}
+
+// phoneNumber returns the user's sms2fa phone number, which is only set
+// once it has been verified.
+func phoneNumber(user authboss.User) string {
+	if su, ok := user.(sms2fa.User); ok {
+		return su.GetSMSPhoneNumber()
+	}
+
+	return ""
+}
+
+// startCode stores a new recovery code on the user and texts it to them.
+// The returned selector must be submitted with the code.
+func (r *Recover) startCode(ctx context.Context, ru authboss.RecoverableUser, number string) (string, error) {
+	logger := r.Authboss.Logger(ctx)
+
+	selector, verifier, token, code, err := authboss.GenerateCode(r.Authboss.Config.Modules.RecoverCodeLength)
+	if err != nil {
+		return "", err
+	}
+
+	ru.PutRecoverSelector(selector)
+	ru.PutRecoverVerifier(verifier)
+	ru.PutRecoverExpiry(time.Now().UTC().Add(r.Authboss.Config.Modules.RecoverCodeDuration))
+	rcu := authboss.MustBeRecoverCode(ru)
+	rcu.PutRecoverAttempts(0)
+	rcu.PutRecoverPhoneVerified(false)
+	if rru, ok := ru.(authboss.RecoverResendableUser); ok {
+		rru.PutRecoverLastSent(time.Now().UTC())
+	}
+
+	if err := r.Authboss.Storage.Server.Save(ctx, ru); err != nil {
+		return "", err
+	}
+
+	logger.Infof("sending recover code text message to user: %s", ru.GetPID())
+	text := fmt.Sprintf("Your password recovery code is: %s", code)
+	if err := r.Authboss.Config.Modules.RecoverSMSSender.Send(ctx, number, text); err != nil {
+		logger.Errorf("failed to send recover code text message to %s: %+v", ru.GetPID(), err)
+	}
+
+	return token, nil
+}
+
+// startRedirect responds to a recover start the same way whether or not
+// a code was texted. When a code was asked for the user is sent to the code
+// page with the selector of the code that was sent in the session. If none
+// was sent the session's selector is written again, or one that matches
+// nothing if there isn't one.
+func (r *Recover) startRedirect(w http.ResponseWriter, req *http.Request, viaSMS bool, token string) error {
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: r.Authboss.Config.Paths.RecoverOK,
+		Success:      recoverInitiateSuccessFlash,
+	}
+
+	if viaSMS {
+		if len(token) == 0 {
+			token, _ = authboss.GetSession(req, SessionRecoverSelector)
+		}
+		if len(token) == 0 {
+			var err error
+			if _, _, token, _, err = authboss.GenerateCode(r.Authboss.Config.Modules.RecoverCodeLength); err != nil {
+				return err
+			}
+		}
+
+		authboss.PutSession(w, SessionRecoverSelector, token)
+		ro.RedirectPath = path.Join(r.Authboss.Config.Paths.Mount, "recover/code")
+		ro.Success = recoverCodeSuccessFlash
+	}
+
+	return r.Authboss.Core.Redirector.Redirect(w, req, ro)
+}
+
+// CodeGet renders a form for a user to enter their recovery code.
+func (r *Recover) CodeGet(w http.ResponseWriter, req *http.Request) error {
+	return r.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverCode, nil)
+}
+
+// CodePost checks the recovery code, and if it's valid sends the user on
+// to the recover end page to choose a new password.
+func (r *Recover) CodePost(w http.ResponseWriter, req *http.Request) error {
+	logger := r.RequestLogger(req)
+
+	validatable, err := r.Authboss.Config.Core.BodyReader.Read(PageRecoverCode, req)
+	if err != nil {
+		return err
+	}
+
+	if errs := validatable.Validate(); errs != nil {
+		logger.Info("recover code validation failed")
+		data := authboss.HTMLData{authboss.DataValidation: authboss.ErrorMap(errs)}
+		return r.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverCode, data)
+	}
+
+	values := authboss.MustHaveCodeValues(validatable)
+
+	token := values.GetSelector()
+	if len(token) == 0 {
+		token, _ = authboss.GetSession(req, SessionRecoverSelector)
+	}
+
+	selector, ok := authboss.CodeSelector(token)
+	if !ok {
+		logger.Infof("invalid recover code selector submitted: %s", token)
+		return r.invalidCode(w, req, recoverCodeInvalid)
+	}
+
+	storer := authboss.EnsureCanRecover(r.Authboss.Config.Storage.Server)
+	user, err := storer.LoadByRecoverSelector(req.Context(), selector)
+	if err == authboss.ErrUserNotFound {
+		logger.Infof("recover code selector was not found in database: %s", selector)
+		return r.invalidCode(w, req, recoverCodeInvalid)
+	} else if err != nil {
+		return err
+	}
+
+	ru := authboss.MustBeRecoverCode(user)
+	if time.Now().UTC().After(ru.GetRecoverExpiry()) {
+		logger.Infof("user %s recover code has expired", ru.GetPID())
+		return r.invalidCode(w, req, recoverCodeExpired)
+	}
+
+	if ru.GetRecoverAttempts() >= r.Authboss.Config.Modules.RecoverCodeAttempts {
+		logger.Infof("user %s has entered too many wrong recover codes", ru.GetPID())
+		return r.invalidCode(w, req, recoverCodeAttempts)
+	}
+
+	if !authboss.VerifyCode(token, strings.TrimSpace(values.GetCode()), ru.GetRecoverVerifier()) {
+		logger.Infof("user %s entered the wrong recover code", ru.GetPID())
+		ru.PutRecoverAttempts(ru.GetRecoverAttempts() + 1)
+		if err = r.Authboss.Config.Storage.Server.Save(req.Context(), ru); err != nil {
+			return err
+		}
+		return r.invalidCode(w, req, recoverCodeInvalid)
+	}
+
+	// Swap the code for a token the recover end page accepts, it expires
+	// along with the code so it can't be held on to. Having the code shows
+	// the user has their phone, so it also counts as their second factor.
+	selector, verifier, endToken, err := GenerateRecoverCreds()
+	if err != nil {
+		return err
+	}
+
+	ru.PutRecoverSelector(selector)
+	ru.PutRecoverVerifier(verifier)
+	ru.PutRecoverAttempts(0)
+	ru.PutRecoverPhoneVerified(true)
+	if err = r.Authboss.Config.Storage.Server.Save(req.Context(), ru); err != nil {
+		return err
+	}
+
+	logger.Infof("user %s entered a valid recover code", ru.GetPID())
+	authboss.DelSession(w, SessionRecoverSelector)
+
+	query := url.Values{FormValueToken: []string{endToken}}
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: path.Join(r.Authboss.Config.Paths.Mount, "recover/end") + "?" + query.Encode(),
+	}
+	return r.Authboss.Core.Redirector.Redirect(w, req, ro)
+}
+
+func (r *Recover) invalidCode(w http.ResponseWriter, req *http.Request, message string) error {
+	data := authboss.HTMLData{authboss.DataErr: message}
+	return r.Authboss.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRecoverCode, data)
+}
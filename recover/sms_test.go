@@ -0,0 +1,439 @@
+package recover
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/mocks"
+)
+
+type testSMSSender struct {
+	number string
+	text   string
+}
+
+func (s *testSMSSender) Send(ctx context.Context, number, text string) error {
+	s.number = number
+	s.text = text
+	return nil
+}
+
+func TestInitCode(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+
+	router := &mocks.Router{}
+	renderer := &mocks.Renderer{}
+	mailRenderer := &mocks.Renderer{}
+	errHandler := &mocks.ErrorHandler{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Core.MailRenderer = mailRenderer
+	ab.Config.Core.ErrorHandler = errHandler
+	ab.Config.Modules.RecoverChannel = authboss.RecoverChannelSMS
+
+	r := &Recover{}
+	if err := r.Init(ab); err == nil {
+		t.Error("should fail without an sms sender")
+	}
+
+	router = &mocks.Router{}
+	renderer = &mocks.Renderer{}
+	ab.Config.Core.Router = router
+	ab.Config.Core.ViewRenderer = renderer
+	ab.Config.Modules.RecoverSMSSender = &testSMSSender{}
+
+	r = &Recover{}
+	if err := r.Init(ab); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renderer.HasLoadedViews(PageRecoverStart, PageRecoverEnd, PageRecoverCode); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasGets("/recover", "/recover/end", "/recover/code"); err != nil {
+		t.Error(err)
+	}
+	if err := router.HasPosts("/recover", "/recover/end", "/recover/code"); err != nil {
+		t.Error(err)
+	}
+}
+
+func smsSetup(channel authboss.RecoverChannel) (*testHarness, *testSMSSender) {
+	h := testSetup()
+
+	sender := &testSMSSender{}
+	h.ab.Config.Modules.RecoverChannel = channel
+	h.ab.Config.Modules.RecoverSMSSender = sender
+
+	return h, sender
+}
+
+func TestStartPostSMS(t *testing.T) {
+	t.Parallel()
+
+	h, sender := smsSetup(authboss.RecoverChannelSMS)
+
+	user := &mocks.User{Email: "test@test.com", SMSPhoneNumber: "555-5555", RecoverAttempts: 3}
+	h.storer.Users["test@test.com"] = user
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if err := h.recover.StartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if sender.number != "555-5555" {
+		t.Error("text message was sent to the wrong number:", sender.number)
+	}
+	if len(h.mailer.Email.To) != 0 {
+		t.Error("should not have sent an e-mail")
+	}
+	if len(user.RecoverSelector) == 0 || len(user.RecoverVerifier) == 0 {
+		t.Error("the code should have been stored")
+	}
+	if user.RecoverAttempts != 0 {
+		t.Error("attempts should have been reset")
+	}
+	if time.Until(user.RecoverTokenExpiry) > h.ab.Config.Modules.RecoverCodeDuration {
+		t.Error("the code should expire sooner:", user.RecoverTokenExpiry)
+	}
+	if len(h.session.ClientValues[SessionRecoverSelector]) == 0 {
+		t.Error("the selector should have been put in the session")
+	}
+	if p := h.redirector.Options.RedirectPath; p != "/auth/recover/code" {
+		t.Error("redirect path was wrong:", p)
+	}
+}
+
+func TestStartPostSMSFallback(t *testing.T) {
+	t.Parallel()
+
+	h, sender := smsSetup(authboss.RecoverChannelSMS)
+
+	h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if err := h.recover.StartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if len(sender.number) != 0 {
+		t.Error("should not have sent a text message")
+	}
+	if len(h.mailer.Email.To) == 0 {
+		t.Error("should have e-mailed the user instead")
+	}
+	if len(h.session.ClientValues[SessionRecoverSelector]) == 0 {
+		t.Error("a selector should be in the session so it looks the same")
+	}
+	if p := h.redirector.Options.RedirectPath; p != "/auth/recover/code" {
+		t.Error("redirect path was wrong:", p)
+	}
+}
+
+func TestStartPostSMSUserChoice(t *testing.T) {
+	t.Parallel()
+
+	for _, channel := range []string{ChannelEmail, ChannelSMS} {
+		h, sender := smsSetup(authboss.RecoverChannelUser)
+
+		h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com", SMSPhoneNumber: "555-5555"}
+		h.bodyReader.Return = mocks.Values{PID: "test@test.com", Channel: channel}
+
+		r := mocks.Request("POST")
+		w := h.ab.NewResponse(httptest.NewRecorder())
+
+		if err := h.recover.StartPost(w, r); err != nil {
+			t.Fatal(err)
+		}
+
+		if sent := len(sender.number) != 0; sent != (channel == ChannelSMS) {
+			t.Errorf("%s: text message sent: %t", channel, sent)
+		}
+		if sent := len(h.mailer.Email.To) != 0; sent != (channel == ChannelEmail) {
+			t.Errorf("%s: e-mail sent: %t", channel, sent)
+		}
+	}
+}
+
+func TestStartPostSMSUserNotFound(t *testing.T) {
+	t.Parallel()
+
+	h, sender := smsSetup(authboss.RecoverChannelSMS)
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if err := h.recover.StartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if len(sender.number) != 0 {
+		t.Error("should not have sent a text message")
+	}
+	if len(h.session.ClientValues[SessionRecoverSelector]) == 0 {
+		t.Error("a selector should be in the session so it looks the same")
+	}
+	if p := h.redirector.Options.RedirectPath; p != "/auth/recover/code" {
+		t.Error("redirect path was wrong:", p)
+	}
+}
+
+func codePostSetup(t *testing.T, code string) (*testHarness, *mocks.User) {
+	t.Helper()
+
+	h, _ := smsSetup(authboss.RecoverChannelSMS)
+
+	selector, verifier, token, realCode, err := authboss.GenerateCode(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) == 0 {
+		code = realCode
+	}
+
+	user := &mocks.User{
+		Email: "test@test.com", RecoverSelector: selector, RecoverVerifier: verifier,
+		RecoverTokenExpiry: time.Now().UTC().Add(time.Minute), RecoverAttempts: 1,
+	}
+	h.storer.Users["test@test.com"] = user
+	h.session.ClientValues[SessionRecoverSelector] = token
+	h.bodyReader.Return = mocks.Values{Code: code}
+
+	return h, user
+}
+
+func codePost(t *testing.T, h *testHarness) {
+	t.Helper()
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	r, err := h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.recover.CodePost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestCodePostSuccess(t *testing.T) {
+	t.Parallel()
+
+	h, user := codePostSetup(t, "")
+	oldSelector := user.RecoverSelector
+
+	codePost(t, h)
+
+	p := h.redirector.Options.RedirectPath
+	if !strings.HasPrefix(p, "/auth/recover/end?") {
+		t.Fatal("redirect path was wrong:", p)
+	}
+	if user.RecoverSelector == oldSelector {
+		t.Error("the code should have been swapped for a new token")
+	}
+	if user.RecoverAttempts != 0 {
+		t.Error("attempts should have been reset")
+	}
+	if _, ok := h.session.ClientValues[SessionRecoverSelector]; ok {
+		t.Error("the selector should have been removed from the session")
+	}
+
+	query, err := url.ParseQuery(p[strings.Index(p, "?")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token must be accepted by the recover end page
+	if user, err := h.recover.verifyToken(mocks.Request("GET"), query.Get(FormValueToken)); err != nil || user == nil {
+		t.Error("the token should be valid:", err)
+	}
+}
+
+func TestCodePostWrongCode(t *testing.T) {
+	t.Parallel()
+
+	h, user := codePostSetup(t, "notacode")
+
+	codePost(t, h)
+
+	if h.responder.Page != PageRecoverCode {
+		t.Error("page was wrong:", h.responder.Page)
+	}
+	if msg := h.responder.Data[authboss.DataErr]; msg != recoverCodeInvalid {
+		t.Error("error was wrong:", msg)
+	}
+	if user.RecoverAttempts != 2 {
+		t.Error("the attempt should have been counted:", user.RecoverAttempts)
+	}
+}
+
+func TestCodePostTooManyAttempts(t *testing.T) {
+	t.Parallel()
+
+	h, user := codePostSetup(t, "")
+	user.RecoverAttempts = h.ab.Config.Modules.RecoverCodeAttempts
+
+	codePost(t, h)
+
+	if msg := h.responder.Data[authboss.DataErr]; msg != recoverCodeAttempts {
+		t.Error("error was wrong:", msg)
+	}
+}
+
+func TestCodePostExpired(t *testing.T) {
+	t.Parallel()
+
+	h, user := codePostSetup(t, "")
+	user.RecoverTokenExpiry = time.Now().UTC().Add(-time.Minute)
+
+	codePost(t, h)
+
+	if msg := h.responder.Data[authboss.DataErr]; msg != recoverCodeExpired {
+		t.Error("error was wrong:", msg)
+	}
+}
+
+func TestSMSRecoveryEndToEnd(t *testing.T) {
+	t.Parallel()
+
+	h, sender := smsSetup(authboss.RecoverChannelSMS)
+
+	// sms two factor users can only recover by text message, so the code
+	// must count as their second factor
+	user := &mocks.User{Email: "test@test.com", Password: "old", SMSPhoneNumber: "555-5555"}
+	h.storer.Users["test@test.com"] = user
+
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.recover.StartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	code := sender.text[strings.LastIndex(sender.text, " ")+1:]
+	h.bodyReader.Return = mocks.Values{Code: code}
+	codePost(t, h)
+
+	p := h.redirector.Options.RedirectPath
+	if !strings.HasPrefix(p, "/auth/recover/end?") {
+		t.Fatal("redirect path was wrong:", p)
+	}
+	query, err := url.ParseQuery(p[strings.Index(p, "?")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.RecoverPhoneOK {
+		t.Error("the token should be marked as having passed the phone")
+	}
+
+	h.bodyReader.Return = mocks.Values{Token: query.Get(FormValueToken), Password: "new"}
+	r = mocks.Request("POST")
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.recover.EndPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := h.redirector.Options.RedirectPath; p != h.ab.Config.Paths.RecoverOK {
+		t.Error("should have finished the recovery, redirect path was:", p, h.responder.Data)
+	}
+	if user.Password == "old" {
+		t.Error("the password should have been changed")
+	}
+	if len(user.RecoverSelector) != 0 || user.RecoverPhoneOK {
+		t.Error("the token should have been used up")
+	}
+}
+
+func TestSMSRecoveryTOTPStillNeeded(t *testing.T) {
+	t.Parallel()
+
+	h, sender := smsSetup(authboss.RecoverChannelSMS)
+
+	// A texted code is not enough for users that also have totp
+	user := &mocks.User{Email: "test@test.com", Password: "old", SMSPhoneNumber: "555-5555", TOTPSecretKey: "secret"}
+	h.storer.Users["test@test.com"] = user
+
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com"}
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.recover.StartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	code := sender.text[strings.LastIndex(sender.text, " ")+1:]
+	h.bodyReader.Return = mocks.Values{Code: code}
+	codePost(t, h)
+
+	p := h.redirector.Options.RedirectPath
+	query, err := url.ParseQuery(p[strings.Index(p, "?")+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.bodyReader.Return = mocks.Values{Token: query.Get(FormValueToken), Password: "new"}
+	r = mocks.Request("POST")
+	w = h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.recover.EndPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageRecoverEnd {
+		t.Error("page was wrong:", h.responder.Page)
+	}
+	errs := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if e := errs[FormValueCode]; len(e) != 1 || e[0] != secondFactorRequired {
+		t.Error("the totp code should still be required:", errs)
+	}
+	if user.Password != "old" {
+		t.Error("the password should not have been changed")
+	}
+}
+
+func TestEndPostEmailTokenStillNeedsSecondFactor(t *testing.T) {
+	t.Parallel()
+
+	h, _ := smsSetup(authboss.RecoverChannelUser)
+
+	user := &mocks.User{Email: "test@test.com", Password: "old", SMSPhoneNumber: "555-5555", RecoverPhoneOK: true}
+	h.storer.Users["test@test.com"] = user
+
+	// Asking for a link by e-mail replaces a token given for a texted code
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com", Channel: ChannelEmail}
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.recover.StartPost(w, r); err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if user.RecoverPhoneOK {
+		t.Error("an e-mailed token should not count as the second factor")
+	}
+}
@@ -0,0 +1,168 @@
+package recover
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+
+	"github.com/p000ic/authboss-echo"
+	"github.com/p000ic/authboss-echo/otp/twofactor"
+	"github.com/p000ic/authboss-echo/otp/twofactor/sms2fa"
+	"github.com/p000ic/authboss-echo/otp/twofactor/totp2fa"
+)
+
+const (
+	secondFactorRequired    = "2fa code or recovery code is required"
+	secondFactorInvalidCode = "2fa code was invalid"
+	secondFactorRepeatCode  = "2fa code was previously used"
+	secondFactorInvalidRec  = "recovery code was invalid"
+
+	secondFactorTextedFlash = "A code has been sent to your phone, please enter it to continue."
+)
+
+// SecondFactorValuer provides the totp code or recovery code that users
+// with two factor authentication enabled must enter to reset their password
+type SecondFactorValuer interface {
+	authboss.Validator
+
+	GetCode() string
+	GetRecoveryCode() string
+}
+
+// requiresSecondFactor is true if the user has totp or sms two factor
+// authentication enabled and it hasn't been relaxed in the config. Users
+// whose only second factor is sms and whose recover token was given for a
+// code texted to their phone have already passed it, totp users must still
+// enter a totp or recovery code as they would to log in.
+func (r *Recover) requiresSecondFactor(user authboss.User) bool {
+	if r.Authboss.Config.Modules.RecoverSkip2FA {
+		return false
+	}
+
+	if tu, ok := user.(totp2fa.User); ok && len(tu.GetTOTPSecretKey()) != 0 {
+		return true
+	}
+	if rcu, ok := user.(authboss.RecoverCodeUser); ok && rcu.GetRecoverPhoneVerified() {
+		return false
+	}
+	if su, ok := user.(sms2fa.User); ok && len(su.GetSMSPhoneNumber()) != 0 {
+		return true
+	}
+
+	return false
+}
+
+// textableSecondFactor returns the phone number to text a code to when
+// the user's only second factor is sms and they didn't enter a recovery
+// code instead. It's empty if a code can't be texted.
+func (r *Recover) textableSecondFactor(user authboss.User, validatable authboss.Validator) string {
+	if r.Authboss.Config.Modules.RecoverSMSSender == nil {
+		return ""
+	}
+	if tu, ok := user.(totp2fa.User); ok && len(tu.GetTOTPSecretKey()) != 0 {
+		return ""
+	}
+	if values, ok := validatable.(SecondFactorValuer); ok && len(strings.TrimSpace(values.GetRecoveryCode())) != 0 {
+		return ""
+	}
+
+	return phoneNumber(user)
+}
+
+// textSecondFactor swaps the user's recover token for a code texted to
+// their phone, and sends them to the code page to enter it. Entering it
+// gives them a token that has passed their second factor.
+func (r *Recover) textSecondFactor(w http.ResponseWriter, req *http.Request, user authboss.RecoverableUser, number string) error {
+	token, err := r.startCode(req.Context(), user, number)
+	if err != nil {
+		return err
+	}
+
+	r.RequestLogger(req).Infof("user %s texted a code as their second factor during recovery", user.GetPID())
+	authboss.PutSession(w, SessionRecoverSelector, token)
+	ro := authboss.RedirectOptions{
+		Code:         http.StatusTemporaryRedirect,
+		RedirectPath: path.Join(r.Authboss.Config.Paths.Mount, "recover/code"),
+		Success:      secondFactorTextedFlash,
+	}
+	return r.Authboss.Core.Redirector.Redirect(w, req, ro)
+}
+
+// secondFactorFailed counts a wrong second factor for the user. After
+// RecoverCodeAttempts of them the recover token is deleted so it can't be
+// used to keep guessing, and true is returned. Attempts are only counted
+// for users that are RecoverCodeUsers.
+func (r *Recover) secondFactorFailed(req *http.Request, user authboss.RecoverableUser) (bool, error) {
+	rcu, ok := user.(authboss.RecoverCodeUser)
+	if !ok {
+		return false, nil
+	}
+
+	attempts := rcu.GetRecoverAttempts() + 1
+	rcu.PutRecoverAttempts(attempts)
+
+	tooMany := attempts >= r.Authboss.Config.Modules.RecoverCodeAttempts
+	if tooMany {
+		r.RequestLogger(req).Infof("user %s failed the second factor too many times during recovery, deleting the recover token", user.GetPID())
+		rcu.PutRecoverSelector("")
+		rcu.PutRecoverVerifier("")
+		rcu.PutRecoverExpiry(time.Now().UTC())
+	}
+
+	return tooMany, r.Authboss.Config.Storage.Server.Save(req.Context(), rcu)
+}
+
+// useSecondFactor checks the recovery code or totp code submitted with the
+// new password. A recovery code is used up and a totp code is recorded for
+// one-time users, the caller must save the user. If the code was not valid
+// the returned status describes why.
+func (r *Recover) useSecondFactor(user authboss.User, validatable authboss.Validator) string {
+	values, ok := validatable.(SecondFactorValuer)
+	if !ok {
+		return secondFactorRequired
+	}
+
+	if recoveryCode := strings.TrimSpace(values.GetRecoveryCode()); len(recoveryCode) != 0 {
+		tu, ok := user.(twofactor.User)
+		if !ok {
+			return secondFactorInvalidRec
+		}
+
+		codes := twofactor.DecodeRecoveryCodes(tu.GetRecoveryCodes())
+		codes, ok = twofactor.UseRecoveryCode(codes, recoveryCode)
+		if !ok {
+			return secondFactorInvalidRec
+		}
+
+		tu.PutRecoveryCodes(twofactor.EncodeRecoveryCodes(codes))
+		return ""
+	}
+
+	code := strings.TrimSpace(values.GetCode())
+	if len(code) == 0 {
+		return secondFactorRequired
+	}
+
+	tu, ok := user.(totp2fa.User)
+	if !ok || len(tu.GetTOTPSecretKey()) == 0 {
+		return secondFactorInvalidCode
+	}
+
+	oneTime, isOneTime := user.(totp2fa.UserOneTime)
+	if isOneTime && oneTime.GetTOTPLastCode() == code {
+		return secondFactorRepeatCode
+	}
+
+	if !totp.Validate(code, tu.GetTOTPSecretKey()) {
+		return secondFactorInvalidCode
+	}
+
+	if isOneTime {
+		oneTime.PutTOTPLastCode(code)
+	}
+
+	return ""
+}
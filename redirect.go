@@ -0,0 +1,108 @@
+package authboss
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// RedirectPolicy decides where users can be sent with the redir parameter
+// (FormValueRedirect), which anyone can put in a link to the site. With the
+// zero value only paths on this site are allowed.
+type RedirectPolicy struct {
+	// AllowedHosts are hosts (with a port if it isn't the default one) that
+	// absolute http and https urls may redirect to
+	AllowedHosts []string
+	// AllowedPaths are the paths that redirects may go to, along with
+	// everything beneath them. All paths are allowed when it's empty.
+	AllowedPaths []string
+}
+
+// Allowed checks if users can be redirected to the url. Relative urls must be
+// a path beginning with a single slash, absolute ones must be on one of the
+// allowed hosts. Backslashes and control characters are never allowed since
+// browsers are lenient with them, eg. /\evil.com is the same as //evil.com.
+func (p RedirectPolicy) Allowed(redirect string) bool {
+	if len(redirect) == 0 {
+		return false
+	}
+
+	for _, c := range redirect {
+		if c == '\\' || c < 0x20 || c == 0x7f {
+			return false
+		}
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil || u.User != nil || len(u.Opaque) != 0 {
+		return false
+	}
+
+	if len(u.Scheme) == 0 && len(u.Host) == 0 {
+		if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+			return false
+		}
+	} else if u.Scheme != "http" && u.Scheme != "https" || !p.allowedHost(u.Host) {
+		return false
+	}
+
+	return p.allowedPath(u.Path)
+}
+
+func (p RedirectPolicy) allowedHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range p.AllowedHosts {
+		if host == strings.ToLower(allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p RedirectPolicy) allowedPath(urlPath string) bool {
+	if len(p.AllowedPaths) == 0 {
+		return true
+	}
+
+	// Clean so that /allowed/../elsewhere doesn't count as beneath /allowed
+	urlPath = path.Clean("/" + urlPath)
+	for _, allowed := range p.AllowedPaths {
+		allowed = path.Clean("/" + allowed)
+		if urlPath == allowed || strings.HasPrefix(urlPath, strings.TrimSuffix(allowed, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Query returns the request's query string, starting with ?, to pass along
+// when redirecting elsewhere. A redir parameter that isn't allowed is
+// removed from it.
+func (p RedirectPolicy) Query(r *http.Request) string {
+	if len(r.URL.RawQuery) == 0 {
+		return ""
+	}
+
+	query := r.URL.Query()
+	redirects, ok := query[FormValueRedirect]
+	if !ok {
+		return "?" + r.URL.RawQuery
+	}
+
+	allowed := true
+	for _, redirect := range redirects {
+		allowed = allowed && p.Allowed(redirect)
+	}
+	if allowed {
+		return "?" + r.URL.RawQuery
+	}
+
+	query.Del(FormValueRedirect)
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
@@ -0,0 +1,82 @@
+package authboss
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectPolicyAllowed(t *testing.T) {
+	t.Parallel()
+
+	relative := RedirectPolicy{}
+	hosts := RedirectPolicy{AllowedHosts: []string{"app.example.com", "localhost:3000"}}
+	paths := RedirectPolicy{AllowedPaths: []string{"/app", "/settings/"}}
+
+	tests := []struct {
+		Policy   RedirectPolicy
+		Redirect string
+		Allowed  bool
+	}{
+		{relative, "", false},
+		{relative, "/", true},
+		{relative, "/home?tab=1#top", true},
+		{relative, "home", false},
+		{relative, "//evil.com", false},
+		{relative, "///evil.com", false},
+		{relative, `/\evil.com`, false},
+		{relative, `\\evil.com`, false},
+		{relative, "/\t/evil.com", false},
+		{relative, "/\n/evil.com", false},
+		{relative, "https://evil.com", false},
+		{relative, "javascript:alert(1)", false},
+		{relative, "mailto:someone@evil.com", false},
+
+		{hosts, "https://app.example.com/home", true},
+		{hosts, "http://APP.example.com", true},
+		{hosts, "http://localhost:3000/", true},
+		{hosts, "http://localhost/", false},
+		{hosts, "https://app.example.com.evil.com/", false},
+		{hosts, "https://app.example.com@evil.com/", false},
+		{hosts, "https://evil.com/?https://app.example.com", false},
+		{hosts, "//app.example.com/home", false},
+		{hosts, "ftp://app.example.com/", false},
+		{hosts, "/home", true},
+
+		{paths, "/app", true},
+		{paths, "/app/projects?id=1", true},
+		{paths, "/settings", true},
+		{paths, "/settings/profile", true},
+		{paths, "/apple", false},
+		{paths, "/app/../admin", false},
+		{paths, "/", false},
+	}
+
+	for i, test := range tests {
+		if allowed := test.Policy.Allowed(test.Redirect); allowed != test.Allowed {
+			t.Errorf("%d) %q: want allowed %t, got %t", i, test.Redirect, test.Allowed, allowed)
+		}
+	}
+}
+
+func TestRedirectPolicyQuery(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		URL   string
+		Query string
+	}{
+		{"/login", ""},
+		{"/login?b=2&a=1", "?b=2&a=1"},
+		{"/login?b=2&redir=%2Fhome&a=1", "?b=2&redir=%2Fhome&a=1"},
+		{"/login?b=2&redir=%2F%2Fevil.com&a=1", "?a=1&b=2"},
+		{"/login?redir=https%3A%2F%2Fevil.com", ""},
+		{"/login?redir=%2Fhome&redir=%2F%2Fevil.com", ""},
+	}
+
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", test.URL, nil)
+		if query := (RedirectPolicy{}).Query(r); query != test.Query {
+			t.Errorf("%d) %s: query was wrong: %q", i, test.URL, query)
+		}
+	}
+}
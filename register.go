@@ -0,0 +1,20 @@
+package authboss
+
+// RegisterAfter is what happens once a user has registered, unless an
+// EventRegister handler (like confirm's) took over, see
+// Config.Modules.RegisterAfter.
+type RegisterAfter int
+
+// Register results
+const (
+	// RegisterAfterLogin logs the user in and redirects to
+	// Paths.RegisterOK
+	RegisterAfterLogin RegisterAfter = iota
+	// RegisterAfterRedirectLogin redirects to the login page without
+	// logging the user in
+	RegisterAfterRedirectLogin
+	// RegisterAfterRender logs the user in and renders the
+	// register_success page with the user's public fields, which is a
+	// JSON body for APIs
+	RegisterAfterRender
+)
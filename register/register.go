@@ -4,7 +4,10 @@ package register
 import (
 	"context"
 	"net/http"
+	"path"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/friendsofgo/errors"
 
@@ -15,6 +18,19 @@ import (
 // Pages
 const (
 	PageRegister = "register"
+	// PageRegisterSuccess is rendered after registering when
+	// Config.Modules.RegisterAfter is authboss.RegisterAfterRender
+	PageRegisterSuccess = "register_success"
+)
+
+// Data constants
+const (
+	// DataInviteToken is the invitation token the register page was opened
+	// with, it should be posted back as authboss.FormValueInvite
+	DataInviteToken = "invite_token"
+	// DataUser holds the public fields of the user that registered on the
+	// register_success page, see authboss.PublicUser
+	DataUser = "user"
 )
 
 func init() {
@@ -33,22 +49,42 @@ func (r *Register) Init(ab *authboss.Authboss) (err error) {
 	if _, ok := ab.Config.Storage.Server.(authboss.CreatingServerStorer); !ok {
 		return errors.New("register module activated but storer could not be upgraded to CreatingServerStorer")
 	}
+	if _, ok := ab.Config.Storage.Server.(authboss.InvitingServerStorer); ab.Config.Modules.RegisterRequireInvite && !ok {
+		return errors.New("register module requires invites but storer could not be upgraded to InvitingServerStorer")
+	}
 
 	if err := ab.Config.Core.ViewRenderer.Load(PageRegister); err != nil {
 		return err
 	}
+	if ab.Config.Modules.RegisterAfter == authboss.RegisterAfterRender {
+		if err := ab.Config.Core.ViewRenderer.Load(PageRegisterSuccess); err != nil {
+			return err
+		}
+	}
 
 	sort.Strings(ab.Config.Modules.RegisterPreserveFields)
 
 	ab.Config.Core.Router.Get("/register", ab.Config.Core.ErrorHandler.Wrap(r.Get))
 	ab.Config.Core.Router.Post("/register", ab.Config.Core.ErrorHandler.Wrap(r.Post))
+	ab.LimitRoute("/register", authboss.RateLimitedRoute{Page: PageRegister})
 
 	return nil
 }
 
 // Get the register page
 func (r *Register) Get(w http.ResponseWriter, req *http.Request) error {
-	return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, nil)
+	challenge, err := r.Authboss.ChallengeData(req, "")
+	if err != nil {
+		return err
+	}
+
+	if r.Config.Modules.RegisterRequireInvite {
+		if token := req.URL.Query().Get(authboss.FormValueInvite); len(token) != 0 {
+			challenge = authboss.HTMLData{DataInviteToken: token}.Merge(challenge)
+		}
+	}
+
+	return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, challenge)
 }
 
 // Post to the register page
@@ -72,22 +108,43 @@ func (r *Register) Post(w http.ResponseWriter, req *http.Request) error {
 		}
 	}
 
+	challenge, ok, err := r.Authboss.CheckChallenge(req, "", validatable)
+	if err != nil {
+		return err
+	} else if !ok {
+		logger.Info("registration challenge failed")
+		if preserve != nil {
+			challenge[authboss.DataPreserve] = preserve
+		}
+		return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, challenge)
+	}
+
 	errs := validatable.Validate()
 	if errs != nil {
 		logger.Info("registration validation failed")
-		data := authboss.HTMLData{
-			authboss.DataValidation: authboss.ErrorMap(errs),
-		}
-		if preserve != nil {
-			data[authboss.DataPreserve] = preserve
-		}
-		return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, data)
+		return r.fail(w, req, validatable, errs, preserve)
 	}
 
 	// Get values from request
 	userVals := authboss.MustHaveUserValues(validatable)
 	pid, password := userVals.GetPID(), userVals.GetPassword()
 
+	var invitation authboss.Invitation
+	if r.Config.Modules.RegisterRequireInvite {
+		var token string
+		if iv, ok := validatable.(authboss.InviteValuer); ok {
+			token = iv.GetInviteToken()
+		}
+
+		invitation, err = r.Authboss.LoadInvitation(req.Context(), token)
+		if err == authboss.ErrTokenNotFound {
+			logger.Infof("user %s tried to register without a valid invitation", pid)
+			return r.fail(w, req, validatable, []error{errors.New("invitation is invalid or has expired")}, preserve)
+		} else if err != nil {
+			return err
+		}
+	}
+
 	// Put values into newly created user for storage
 	storer := authboss.EnsureCanCreate(r.Config.Storage.Server)
 	user := authboss.MustBeAuthable(storer.New(req.Context()))
@@ -103,24 +160,59 @@ func (r *Register) Post(w http.ResponseWriter, req *http.Request) error {
 	if arbUser, ok := user.(authboss.ArbitraryUser); ok && arbitrary != nil {
 		arbUser.PutArbitrary(arbitrary)
 	}
+	if createdUser, ok := user.(authboss.CreatedAtUser); ok {
+		createdUser.PutCreatedAt(time.Now().UTC())
+	}
+
+	if err := r.Authboss.CheckEmailDomain(userEmail(user)); err != nil {
+		logger.Infof("user %s tried to register with a disallowed e-mail address: %v", pid, err)
+		return r.fail(w, req, validatable, []error{authboss.NewFieldError(authboss.FormValueEmail, err)}, preserve)
+	}
+
+	if r.Config.Modules.RegisterRequireInvite {
+		if !strings.EqualFold(userEmail(user), invitation.Email) {
+			logger.Infof("user %s tried to register with an invitation for %s", pid, invitation.Email)
+			return r.fail(w, req, validatable, []error{errors.New("invitation was sent to a different e-mail address")}, preserve)
+		}
+
+		// The invitation was e-mailed so the address doesn't need confirming
+		if confirmUser, ok := user.(authboss.ConfirmableUser); ok {
+			confirmUser.PutConfirmed(true)
+		}
+		if invitedUser, ok := user.(authboss.InvitedUser); ok {
+			invitedUser.PutInvitation(invitation)
+		}
+	}
+
+	// Claim the invitation before creating the user so that two
+	// registrations can't both use it
+	if r.Config.Modules.RegisterRequireInvite {
+		err = authboss.EnsureCanInvite(storer).DelInvitation(req.Context(), invitation.Selector)
+		if err == authboss.ErrTokenNotFound {
+			logger.Infof("user %s tried to register with an invitation that was already used", pid)
+			return r.fail(w, req, validatable, []error{errors.New("invitation is invalid or has expired")}, preserve)
+		} else if err != nil {
+			return err
+		}
+	}
 
 	err = storer.Create(req.Context(), user)
+	if err != nil && r.Config.Modules.RegisterRequireInvite {
+		// Give the invitation back since it wasn't used
+		if putErr := authboss.EnsureCanInvite(storer).PutInvitation(req.Context(), invitation); putErr != nil {
+			return putErr
+		}
+	}
 	switch {
 	case err == authboss.ErrUserFound:
 		logger.Infof("user %s attempted to re-register", pid)
-		errs = []error{errors.New("user already exists")}
-		data := authboss.HTMLData{
-			authboss.DataValidation: authboss.ErrorMap(errs),
-		}
-		if preserve != nil {
-			data[authboss.DataPreserve] = preserve
-		}
-		return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, data)
+		return r.fail(w, req, validatable, []error{errors.New("user already exists")}, preserve)
 	case err != nil:
 		return err
 	}
 
 	req = req.WithContext(context.WithValue(req.Context(), authboss.CTXKeyUser, user))
+	req = req.WithContext(context.WithValue(req.Context(), authboss.CTXKeyValues, validatable))
 	handled, err := r.Events.FireAfter(authboss.EventRegister, w, req)
 	if err != nil {
 		return err
@@ -128,11 +220,27 @@ func (r *Register) Post(w http.ResponseWriter, req *http.Request) error {
 		return nil
 	}
 
+	if r.Config.Modules.RegisterAfter == authboss.RegisterAfterRedirectLogin {
+		logger.Infof("registered user %s", pid)
+		ro := authboss.RedirectOptions{
+			Code:         http.StatusTemporaryRedirect,
+			Success:      "Account successfully created, please log in",
+			RedirectPath: path.Join(r.Config.Paths.Mount, "login"),
+		}
+		return r.Config.Core.Redirector.Redirect(w, req, ro)
+	}
+
 	// Log the user in, but only if the response wasn't handled previously
 	// by a module like confirm.
 	authboss.PutSession(w, authboss.SessionKey, pid)
+	authboss.PutSessionSecurityStamp(w, user)
 
 	logger.Infof("registered and logged in user %s", pid)
+	if r.Config.Modules.RegisterAfter == authboss.RegisterAfterRender {
+		data := authboss.HTMLData{DataUser: publicFields(user)}
+		return r.Config.Core.Responder.Respond(w, req, http.StatusCreated, PageRegisterSuccess, data)
+	}
+
 	ro := authboss.RedirectOptions{
 		Code:         http.StatusTemporaryRedirect,
 		Success:      "Account successfully created, you are now logged in",
@@ -141,6 +249,54 @@ func (r *Register) Post(w http.ResponseWriter, req *http.Request) error {
 	return r.Config.Core.Redirector.Redirect(w, req, ro)
 }
 
+// fail fires EventRegisterFail with the submitted values in the context and,
+// unless a handler took over the request, re-renders the register page with
+// the errors and preserved fields.
+func (r *Register) fail(w http.ResponseWriter, req *http.Request, validatable authboss.Validator, errs []error, preserve map[string]string) error {
+	req = req.WithContext(context.WithValue(req.Context(), authboss.CTXKeyValues, validatable))
+	handled, err := r.Events.FireAfter(authboss.EventRegisterFail, w, req)
+	if err != nil {
+		return err
+	} else if handled {
+		return nil
+	}
+
+	challenge, err := r.Authboss.ChallengeData(req, "")
+	if err != nil {
+		return err
+	}
+
+	data := authboss.HTMLData{
+		authboss.DataValidation: authboss.ErrorMap(errs),
+	}
+	if preserve != nil {
+		data[authboss.DataPreserve] = preserve
+	}
+	if iv, ok := validatable.(authboss.InviteValuer); ok && len(iv.GetInviteToken()) != 0 {
+		data[DataInviteToken] = iv.GetInviteToken()
+	}
+	return r.Config.Core.Responder.Respond(w, req, http.StatusOK, PageRegister, data.Merge(challenge))
+}
+
+// publicFields are the fields of a user that can be shown to them
+func publicFields(user authboss.User) map[string]interface{} {
+	if pu, ok := user.(authboss.PublicUser); ok {
+		return pu.GetPublicFields()
+	}
+
+	return map[string]interface{}{"pid": user.GetPID()}
+}
+
+// userEmail is the e-mail address of a user, users that don't have one
+// are registering with their e-mail address as their pid
+func userEmail(user authboss.User) string {
+	if eu, ok := user.(interface{ GetEmail() string }); ok && len(eu.GetEmail()) != 0 {
+		return eu.GetEmail()
+	}
+
+	return user.GetPID()
+}
+
 // hasString checks to see if a sorted (ascending) array of
 // strings contains a string
 func hasString(arr []string, s string) bool {
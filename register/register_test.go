@@ -1,9 +1,11 @@
 package register
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -137,6 +139,9 @@ func TestRegisterPostSuccess(t *testing.T) {
 		if user.Arbitrary["another"] != "value" {
 			t.Error("arbitrary values not saved")
 		}
+		if user.Created.IsZero() {
+			t.Error("the time the user was created should have been saved")
+		}
 
 		if h.session.ClientValues[authboss.SessionKey] != "test@test.com" {
 			t.Error("user should have been logged in:", h.session.ClientValues)
@@ -210,6 +215,9 @@ func TestRegisterPostValidationFailure(t *testing.T) {
 		},
 	}
 
+	failEvent := mocks.NewAfterCallback()
+	h.ab.Events.After(authboss.EventRegisterFail, failEvent.Fn)
+
 	r := mocks.Request("POST")
 	resp := httptest.NewRecorder()
 	w := h.ab.NewResponse(resp)
@@ -218,6 +226,10 @@ func TestRegisterPostValidationFailure(t *testing.T) {
 		t.Error(err)
 	}
 
+	if !failEvent.HasBeenCalled {
+		t.Error("the register fail event should have fired")
+	}
+
 	if h.responder.Status != http.StatusOK {
 		t.Error("wrong status:", h.responder.Status)
 	}
@@ -262,6 +274,12 @@ func TestRegisterPostUserExists(t *testing.T) {
 		},
 	}
 
+	var failPID string
+	h.ab.Events.After(authboss.EventRegisterFail, func(w http.ResponseWriter, r *http.Request, handled bool) (bool, error) {
+		failPID = authboss.MustHaveUserValues(r.Context().Value(authboss.CTXKeyValues).(authboss.Validator)).GetPID()
+		return false, nil
+	})
+
 	r := mocks.Request("POST")
 	resp := httptest.NewRecorder()
 	w := h.ab.NewResponse(resp)
@@ -270,6 +288,9 @@ func TestRegisterPostUserExists(t *testing.T) {
 		t.Error(err)
 	}
 
+	if failPID != "test@test.com" {
+		t.Error("the register fail event should have seen the pid, got:", failPID)
+	}
 	if h.responder.Status != http.StatusOK {
 		t.Error("wrong status:", h.responder.Status)
 	}
@@ -316,3 +337,292 @@ func TestHasString(t *testing.T) {
 		t.Error("should not have f")
 	}
 }
+
+func TestRegisterPostChallenge(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Core.ChallengeVerifier = &mocks.ChallengeVerifier{Challenge: "challenge", Response: "solved"}
+	h.ab.Config.Modules.ChallengeAfter = 0
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com", Password: "hello world", Challenge: "wrong"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+
+	if err := h.reg.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.responder.Page != PageRegister {
+		t.Error("page was wrong:", h.responder.Page)
+	}
+	if h.responder.Data[authboss.DataChallengeRequired] != true {
+		t.Error("a new challenge should be rendered:", h.responder.Data)
+	}
+	if _, ok := h.storer.Users["test@test.com"]; ok {
+		t.Error("user should not have been created")
+	}
+
+	h.bodyReader.Return = mocks.Values{PID: "test@test.com", Password: "hello world", Challenge: "solved"}
+	if err := h.reg.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.storer.Users["test@test.com"]; !ok {
+		t.Error("user should have been created")
+	}
+}
+
+func TestRegisterPostInvite(t *testing.T) {
+	t.Parallel()
+
+	setup := func(t *testing.T, email, token string) (*testHarness, authboss.Invitation) {
+		t.Helper()
+
+		h := testSetup()
+		h.ab.Config.Modules.RegisterRequireInvite = true
+
+		invitation, realToken, err := authboss.GenerateInvitation("test@test.com", "admin@test.com",
+			map[string]string{"role": "member"}, time.Now().UTC().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.storer.Invitations[invitation.Selector] = invitation
+
+		if len(token) == 0 {
+			token = realToken
+		}
+		h.bodyReader.Return = mocks.Values{PID: email, Password: "hello world", InviteToken: token}
+
+		return h, invitation
+	}
+
+	post := func(t *testing.T, h *testHarness) {
+		t.Helper()
+
+		r := mocks.Request("POST")
+		w := h.ab.NewResponse(httptest.NewRecorder())
+		if err := h.reg.Post(w, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		t.Parallel()
+		h, invitation := setup(t, "test@test.com", "")
+
+		post(t, h)
+
+		user, ok := h.storer.Users["test@test.com"]
+		if !ok {
+			t.Fatal("user was not persisted in the DB")
+		}
+		if !user.Confirmed {
+			t.Error("invited user should have been confirmed")
+		}
+		if user.Invitation.Metadata["role"] != "member" {
+			t.Error("the invitation should have been given to the user")
+		}
+		if _, ok := h.storer.Invitations[invitation.Selector]; ok {
+			t.Error("the invitation should have been used up")
+		}
+		if h.redirector.Options.RedirectPath != "/ok" {
+			t.Error("redirect path was wrong:", h.redirector.Options.RedirectPath)
+		}
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		t.Parallel()
+		h, invitation := setup(t, "test@test.com", "notatoken")
+
+		post(t, h)
+
+		if _, ok := h.storer.Users["test@test.com"]; ok {
+			t.Error("user should not have been created")
+		}
+		if _, ok := h.storer.Invitations[invitation.Selector]; !ok {
+			t.Error("the invitation should not have been used")
+		}
+
+		errList := h.responder.Data[authboss.DataValidation].(map[string][]string)
+		if e := errList[""][0]; e != "invitation is invalid or has expired" {
+			t.Error("validation error wrong:", e)
+		}
+		if token := h.responder.Data[DataInviteToken]; token != "notatoken" {
+			t.Error("the token should be rendered again:", token)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		t.Parallel()
+		h, invitation := setup(t, "test@test.com", "")
+		invitation.Expires = time.Now().UTC().Add(-time.Minute)
+		h.storer.Invitations[invitation.Selector] = invitation
+
+		post(t, h)
+
+		if _, ok := h.storer.Users["test@test.com"]; ok {
+			t.Error("user should not have been created")
+		}
+	})
+
+	t.Run("WrongEmail", func(t *testing.T) {
+		t.Parallel()
+		h, invitation := setup(t, "other@test.com", "")
+
+		post(t, h)
+
+		if _, ok := h.storer.Users["other@test.com"]; ok {
+			t.Error("user should not have been created")
+		}
+		if _, ok := h.storer.Invitations[invitation.Selector]; !ok {
+			t.Error("the invitation should not have been used")
+		}
+
+		errList := h.responder.Data[authboss.DataValidation].(map[string][]string)
+		if e := errList[""][0]; e != "invitation was sent to a different e-mail address" {
+			t.Error("validation error wrong:", e)
+		}
+	})
+
+	t.Run("AlreadyClaimed", func(t *testing.T) {
+		t.Parallel()
+		h, _ := setup(t, "test@test.com", "")
+		h.ab.Config.Storage.Server = claimedInviteStorer{h.storer}
+
+		post(t, h)
+
+		if _, ok := h.storer.Users["test@test.com"]; ok {
+			t.Error("user should not have been created")
+		}
+
+		errList := h.responder.Data[authboss.DataValidation].(map[string][]string)
+		if e := errList[""][0]; e != "invitation is invalid or has expired" {
+			t.Error("validation error wrong:", e)
+		}
+	})
+
+	t.Run("UserExists", func(t *testing.T) {
+		t.Parallel()
+		h, invitation := setup(t, "test@test.com", "")
+		h.storer.Users["test@test.com"] = &mocks.User{Email: "test@test.com"}
+
+		post(t, h)
+
+		if _, ok := h.storer.Invitations[invitation.Selector]; !ok {
+			t.Error("the invitation should have been given back")
+		}
+	})
+}
+
+// claimedInviteStorer finds invitations but acts as if another registration
+// claimed them first
+type claimedInviteStorer struct {
+	*mocks.ServerStorer
+}
+
+func (claimedInviteStorer) DelInvitation(ctx context.Context, selector string) error {
+	return authboss.ErrTokenNotFound
+}
+
+func TestRegisterGetInvite(t *testing.T) {
+	t.Parallel()
+
+	ab := authboss.New()
+	responder := &mocks.Responder{}
+	ab.Config.Core.Responder = responder
+	ab.Config.Modules.RegisterRequireInvite = true
+
+	r := httptest.NewRequest("GET", "/register?invite=token", nil)
+
+	a := &Register{ab}
+	if err := a.Get(nil, r); err != nil {
+		t.Error(err)
+	}
+
+	if token := responder.Data[DataInviteToken]; token != "token" {
+		t.Error("invite token was wrong:", token)
+	}
+}
+
+func TestRegisterPostEmailDomain(t *testing.T) {
+	t.Parallel()
+
+	h := testSetup()
+	h.ab.Config.Modules.RegisterBlockDisposable = true
+	h.bodyReader.Return = mocks.Values{PID: "test@mailinator.com", Password: "hello world"}
+
+	r := mocks.Request("POST")
+	w := h.ab.NewResponse(httptest.NewRecorder())
+	if err := h.reg.Post(w, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := h.storer.Users["test@mailinator.com"]; ok {
+		t.Error("user should not have been created")
+	}
+	if h.responder.Page != PageRegister {
+		t.Error("rendered wrong page:", h.responder.Page)
+	}
+
+	errList := h.responder.Data[authboss.DataValidation].(map[string][]string)
+	if e := errList[authboss.FormValueEmail]; len(e) != 1 || e[0] != authboss.ErrEmailDisposable.Error() {
+		t.Error("validation error wrong:", errList)
+	}
+}
+
+func TestRegisterPostAfter(t *testing.T) {
+	t.Parallel()
+
+	post := func(t *testing.T, after authboss.RegisterAfter) *testHarness {
+		t.Helper()
+
+		h := testSetup()
+		h.ab.Config.Paths.Mount = "/auth"
+		h.ab.Config.Modules.RegisterAfter = after
+		h.bodyReader.Return = mocks.Values{PID: "test@test.com", Password: "hello world"}
+
+		r := mocks.Request("POST")
+		w := h.ab.NewResponse(httptest.NewRecorder())
+		if err := h.reg.Post(w, r); err != nil {
+			t.Fatal(err)
+		}
+		w.WriteHeader(http.StatusOK)
+
+		if _, ok := h.storer.Users["test@test.com"]; !ok {
+			t.Error("user was not persisted in the DB")
+		}
+
+		return h
+	}
+
+	t.Run("RedirectLogin", func(t *testing.T) {
+		t.Parallel()
+		h := post(t, authboss.RegisterAfterRedirectLogin)
+
+		if p := h.redirector.Options.RedirectPath; p != "/auth/login" {
+			t.Error("redirect path was wrong:", p)
+		}
+		if _, ok := h.session.ClientValues[authboss.SessionKey]; ok {
+			t.Error("user should not have been logged in")
+		}
+	})
+
+	t.Run("Render", func(t *testing.T) {
+		t.Parallel()
+		h := post(t, authboss.RegisterAfterRender)
+
+		if h.responder.Page != PageRegisterSuccess {
+			t.Error("rendered wrong page:", h.responder.Page)
+		}
+		if h.responder.Status != http.StatusCreated {
+			t.Error("wrong status:", h.responder.Status)
+		}
+		fields := h.responder.Data[DataUser].(map[string]interface{})
+		if fields["pid"] != "test@test.com" {
+			t.Error("public fields were wrong:", fields)
+		}
+		if pid := h.session.ClientValues[authboss.SessionKey]; pid != "test@test.com" {
+			t.Error("user should have been logged in:", pid)
+		}
+	})
+}
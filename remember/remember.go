@@ -6,16 +6,24 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/base64"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/friendsofgo/errors"
 	"github.com/p000ic/authboss-echo"
 )
 
 const (
-	nNonceSize = 32
+	nNonceSize  = 32
+	nSeriesSize = 16
+
+	// seriesTokenPrefix marks tokens made by GenerateSeriesToken. Tokens
+	// made by GenerateToken start with the pid, which is never empty, so
+	// they never start with a separator.
+	seriesTokenPrefix = ";s1;"
 )
 
 func init() {
@@ -33,7 +41,9 @@ func (r *Remember) Init(ab *authboss.Authboss) error {
 
 	r.Events.After(authboss.EventAuth, r.RememberAfterAuth)
 	r.Events.After(authboss.EventOAuth2, r.RememberAfterAuth)
+	r.Events.AfterPriority(authboss.EventRegister, authboss.PriorityLow, r.RememberAfterRegister)
 	r.Events.After(authboss.EventRecoverEnd, r.AfterPasswordReset)
+	r.Events.Before(authboss.EventLogout, r.BeforeLogout)
 
 	return nil
 }
@@ -48,12 +58,23 @@ func (r *Remember) RememberAfterAuth(w http.ResponseWriter, req *http.Request, h
 	}
 
 	user := r.Authboss.CurrentUserP(req)
+	storer := authboss.EnsureCanRemember(r.Authboss.Config.Storage.Server)
+
+	if seriesStorer, ok := storer.(authboss.SeriesRememberingServerStorer); ok {
+		token, err := newSeries(r.Authboss, seriesStorer, req, user.GetPID())
+		if err != nil {
+			return false, err
+		}
+
+		authboss.PutCookie(w, authboss.CookieRemember, token)
+		return false, nil
+	}
+
 	hash, token, err := GenerateToken(user.GetPID())
 	if err != nil {
 		return false, err
 	}
 
-	storer := authboss.EnsureCanRemember(r.Authboss.Config.Storage.Server)
 	if err = storer.AddRememberToken(req.Context(), user.GetPID(), hash); err != nil {
 		return false, err
 	}
@@ -63,6 +84,17 @@ func (r *Remember) RememberAfterAuth(w http.ResponseWriter, req *http.Request, h
 	return false, nil
 }
 
+// RememberAfterRegister remembers users that asked for it when registering
+// logs them in. It runs after the other handlers so that it does nothing
+// when one of them (like confirm) took over instead.
+func (r *Remember) RememberAfterRegister(w http.ResponseWriter, req *http.Request, handled bool) (bool, error) {
+	if handled || r.Authboss.Config.Modules.RegisterAfter == authboss.RegisterAfterRedirectLogin {
+		return false, nil
+	}
+
+	return r.RememberAfterAuth(w, req, handled)
+}
+
 // Middleware automatically authenticates users if they have remember me tokens
 // If the user has been loaded already, it returns early
 func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
@@ -87,9 +119,16 @@ func Middleware(ab *authboss.Authboss) func(http.Handler) http.Handler {
 // - Can't decode the base64
 // - Invalid token format
 // - Can't find token in DB
+// - The series has expired
+// - The token has already been used (theft)
 //
 // In order to authenticate it adds to the request context as well as to the
 // cookie and session states.
+//
+// If the storer is a SeriesRememberingServerStorer the token in the series is
+// rotated, and old style tokens are upgraded to a new series. If a token that
+// has already been used is presented with a valid series all of the user's
+// tokens are revoked and EventRememberTheft is fired.
 func Authenticate(ab *authboss.Authboss, w http.ResponseWriter, req **http.Request) error {
 	logger := ab.RequestLogger(*req)
 	cookie, ok := authboss.GetCookie(*req, authboss.CookieRemember)
@@ -104,44 +143,196 @@ func Authenticate(ab *authboss.Authboss, w http.ResponseWriter, req **http.Reque
 		return nil
 	}
 
-	index := bytes.IndexByte(rawToken, ';')
-	if index < 0 {
+	storer := authboss.EnsureCanRemember(ab.Config.Storage.Server)
+	seriesStorer, hasSeries := storer.(authboss.SeriesRememberingServerStorer)
+
+	pid, series, secret, isSeries := parseSeriesToken(rawToken)
+
+	var token string
+	if isSeries && hasSeries {
+		token, err = useSeries(ab, seriesStorer, w, *req, pid, series, secret)
+		if err != nil || len(token) == 0 {
+			return err
+		}
+	} else {
+		index := bytes.IndexByte(rawToken, ';')
+		if index < 0 {
+			authboss.DelCookie(w, authboss.CookieRemember)
+			logger.Infof("failed to decode remember me token, deleting cookie")
+			return nil
+		}
+
+		pid = string(rawToken[:index])
+		sum := sha512.Sum512(rawToken)
+		hash := base64.StdEncoding.EncodeToString(sum[:])
+
+		err = storer.UseRememberToken((*req).Context(), pid, hash)
+		switch {
+		case err == authboss.ErrTokenNotFound:
+			logger.Infof("remember me cookie had a token that was not in storage, deleting cookie")
+			authboss.DelCookie(w, authboss.CookieRemember)
+			return nil
+		case err != nil:
+			return err
+		}
+
+		if hasSeries {
+			token, err = newSeries(ab, seriesStorer, *req, pid)
+			if err != nil {
+				return err
+			}
+		} else {
+			hash, token, err = GenerateToken(pid)
+			if err != nil {
+				return err
+			}
+
+			if err = storer.AddRememberToken((*req).Context(), pid, hash); err != nil {
+				return errors.Wrap(err, "failed to save remember me token")
+			}
+		}
+	}
+
+	user, err := ab.Config.Storage.Server.Load((*req).Context(), pid)
+	switch {
+	case err == authboss.ErrUserNotFound:
+		logger.Infof("remember me cookie was for user %s who does not exist, deleting cookie", pid)
 		authboss.DelCookie(w, authboss.CookieRemember)
-		logger.Infof("failed to decode remember me token, deleting cookie")
 		return nil
+	case err != nil:
+		return err
 	}
 
-	pid := string(rawToken[:index])
-	sum := sha512.Sum512(rawToken)
-	hash := base64.StdEncoding.EncodeToString(sum[:])
+	*req = (*req).WithContext(context.WithValue((*req).Context(), authboss.CTXKeyPID, pid))
+	authboss.PutSession(w, authboss.SessionKey, pid)
+	authboss.PutSession(w, authboss.SessionHalfAuthKey, "true")
+	authboss.PutSessionSecurityStamp(w, user)
+	authboss.DelCookie(w, authboss.CookieRemember)
+	authboss.PutCookie(w, authboss.CookieRemember, token)
 
-	storer := authboss.EnsureCanRemember(ab.Config.Storage.Server)
-	err = storer.UseRememberToken((*req).Context(), pid, hash)
+	return nil
+}
+
+// useSeries checks the secret against the token stored in the series and
+// rotates it. If the series can't be used the cookie is deleted and
+// the returned token is empty.
+func useSeries(ab *authboss.Authboss, storer authboss.SeriesRememberingServerStorer, w http.ResponseWriter, req *http.Request, pid, series, secret string) (string, error) {
+	logger := ab.RequestLogger(req)
+
+	stored, err := storer.LoadRememberSeries(req.Context(), pid, series)
 	switch {
 	case err == authboss.ErrTokenNotFound:
-		logger.Infof("remember me cookie had a token that was not in storage, deleting cookie")
+		logger.Infof("remember me cookie had a series that was not in storage, deleting cookie")
 		authboss.DelCookie(w, authboss.CookieRemember)
-		return nil
+		return "", nil
 	case err != nil:
-		return err
+		return "", err
 	}
 
-	hash, token, err := GenerateToken(pid)
+	now := time.Now().UTC()
+	if now.After(stored.Expires) {
+		logger.Infof("remember me series for user %s has expired, deleting cookie", pid)
+		authboss.DelCookie(w, authboss.CookieRemember)
+		return "", storer.DelRememberSeries(req.Context(), pid, series)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(stored.Token)) != 1 {
+		logger.Infof("remember me token for user %s was reused, revoking all tokens", pid)
+		authboss.DelCookie(w, authboss.CookieRemember)
+		if err := storer.DelRememberTokens(req.Context(), pid); err != nil {
+			return "", err
+		}
+
+		user, err := ab.Config.Storage.Server.Load(req.Context(), pid)
+		if err != nil {
+			return "", err
+		}
+
+		req = req.WithContext(context.WithValue(req.Context(), authboss.CTXKeyUser, user))
+		_, err = ab.Events.FireAfter(authboss.EventRememberTheft, w, req)
+		return "", err
+	}
+
+	hash, token, err := GenerateSeriesToken(pid, series)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if err = storer.AddRememberToken((*req).Context(), pid, hash); err != nil {
-		return errors.Wrap(err, "failed to save remember me token")
+	stored.Token = hash
+	stored.LastUsed = now
+	stored.UserAgent = req.UserAgent()
+	stored.IP = ab.ClientIP(req)
+	if err = storer.PutRememberSeries(req.Context(), stored); err != nil {
+		return "", errors.Wrap(err, "failed to save remember me series")
 	}
 
-	*req = (*req).WithContext(context.WithValue((*req).Context(), authboss.CTXKeyPID, pid))
-	authboss.PutSession(w, authboss.SessionKey, pid)
-	authboss.PutSession(w, authboss.SessionHalfAuthKey, "true")
-	authboss.DelCookie(w, authboss.CookieRemember)
-	authboss.PutCookie(w, authboss.CookieRemember, token)
+	return token, nil
+}
 
-	return nil
+// newSeries creates and stores a new series for pid, returning the token
+// for the cookie.
+func newSeries(ab *authboss.Authboss, storer authboss.SeriesRememberingServerStorer, req *http.Request, pid string) (string, error) {
+	seriesBytes := make([]byte, nSeriesSize)
+	if _, err := io.ReadFull(rand.Reader, seriesBytes); err != nil {
+		return "", errors.Wrap(err, "failed to create remember me series")
+	}
+	series := base64.RawURLEncoding.EncodeToString(seriesBytes)
+
+	hash, token, err := GenerateSeriesToken(pid, series)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	err = storer.PutRememberSeries(req.Context(), authboss.RememberSeries{
+		PID:       pid,
+		Series:    series,
+		Token:     hash,
+		Expires:   now.Add(ab.Config.Modules.RememberDuration),
+		Created:   now,
+		LastUsed:  now,
+		UserAgent: req.UserAgent(),
+		IP:        ab.ClientIP(req),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to save remember me series")
+	}
+
+	return token, nil
+}
+
+// BeforeLogout revokes the token in the user's remember cookie, the
+// cookie itself is deleted by the logout module.
+func (r *Remember) BeforeLogout(w http.ResponseWriter, req *http.Request, handled bool) (bool, error) {
+	cookie, ok := authboss.GetCookie(req, authboss.CookieRemember)
+	if !ok {
+		return false, nil
+	}
+
+	rawToken, err := base64.URLEncoding.DecodeString(cookie)
+	if err != nil {
+		return false, nil
+	}
+
+	storer := authboss.EnsureCanRemember(r.Authboss.Config.Storage.Server)
+	if pid, series, _, ok := parseSeriesToken(rawToken); ok {
+		if seriesStorer, ok := storer.(authboss.SeriesRememberingServerStorer); ok {
+			return false, seriesStorer.DelRememberSeries(req.Context(), pid, series)
+		}
+	}
+
+	index := bytes.IndexByte(rawToken, ';')
+	if index < 0 {
+		return false, nil
+	}
+
+	sum := sha512.Sum512(rawToken)
+	err = storer.UseRememberToken(req.Context(), string(rawToken[:index]), base64.StdEncoding.EncodeToString(sum[:]))
+	if err == authboss.ErrTokenNotFound {
+		return false, nil
+	}
+
+	return false, err
 }
 
 // AfterPasswordReset is called after the password has been reset, since
@@ -176,3 +367,54 @@ func GenerateToken(pid string) (hash string, token string, err error) {
 	sum := sha512.Sum512(rawToken)
 	return base64.StdEncoding.EncodeToString(sum[:]), base64.URLEncoding.EncodeToString(rawToken), nil
 }
+
+// GenerateSeriesToken creates a new remember me token for a series. The
+// token is the base64 encoding of ";s1;pid;series;secret" and hash is
+// what's stored in the series.
+func GenerateSeriesToken(pid, series string) (hash string, token string, err error) {
+	secretBytes := make([]byte, nNonceSize)
+	if _, err := io.ReadFull(rand.Reader, secretBytes); err != nil {
+		return "", "", errors.Wrap(err, "failed to create remember me secret")
+	}
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	rawToken := seriesTokenPrefix + pid + ";" + series + ";" + secret
+	return hashSecret(secret), base64.URLEncoding.EncodeToString([]byte(rawToken)), nil
+}
+
+// parseSeriesToken splits a token made by GenerateSeriesToken, ok is false
+// for tokens without the series prefix, like those made by GenerateToken.
+// The pid may contain separators, the series and secret can't.
+func parseSeriesToken(rawToken []byte) (pid, series, secret string, ok bool) {
+	if !bytes.HasPrefix(rawToken, []byte(seriesTokenPrefix)) {
+		return "", "", "", false
+	}
+	rest := rawToken[len(seriesTokenPrefix):]
+
+	end := bytes.LastIndexByte(rest, ';')
+	if end < 0 {
+		return "", "", "", false
+	}
+	start := bytes.LastIndexByte(rest[:end], ';')
+	if start <= 0 {
+		return "", "", "", false
+	}
+
+	pid = string(rest[:start])
+	series = string(rest[start+1 : end])
+	secret = string(rest[end+1:])
+
+	if _, err := base64.RawURLEncoding.DecodeString(series); err != nil || len(series) == 0 {
+		return "", "", "", false
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(secret); err != nil || len(secret) == 0 {
+		return "", "", "", false
+	}
+
+	return pid, series, secret, true
+}
+
+func hashSecret(secret string) string {
+	sum := sha512.Sum512([]byte(secret))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
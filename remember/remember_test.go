@@ -7,7 +7,9 @@ import (
 	"encoding/base64"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/p000ic/authboss-echo"
 	"github.com/p000ic/authboss-echo/mocks"
@@ -114,6 +116,44 @@ func TestRememberAfterAuthSkip(t *testing.T) {
 	}
 }
 
+func TestRememberAfterRegister(t *testing.T) {
+	t.Parallel()
+
+	register := func(h *testHarness, handled bool) {
+		user := &mocks.User{Email: "test@test.com"}
+
+		r := mocks.Request("POST")
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, mocks.Values{Remember: true}))
+		r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+		w := h.ab.NewResponse(httptest.NewRecorder())
+
+		if result, err := h.remember.RememberAfterRegister(w, r, handled); err != nil {
+			t.Fatal(err)
+		} else if result {
+			t.Error("should never be handled")
+		}
+	}
+
+	h := testSetup()
+	register(h, false)
+	if len(h.storer.RMTokens["test@test.com"]) != 1 {
+		t.Error("token was not persisted:", h.storer.RMTokens)
+	}
+
+	h = testSetup()
+	register(h, true)
+	if len(h.storer.RMTokens["test@test.com"]) != 0 {
+		t.Error("should not remember users when another handler took over")
+	}
+
+	h = testSetup()
+	h.ab.Config.Modules.RegisterAfter = authboss.RegisterAfterRedirectLogin
+	register(h, false)
+	if len(h.storer.RMTokens["test@test.com"]) != 0 {
+		t.Error("should not remember users that aren't logged in")
+	}
+}
+
 func TestMiddlewareAuth(t *testing.T) {
 	t.Parallel()
 
@@ -159,7 +199,7 @@ func TestAuthenticateSuccess(t *testing.T) {
 
 	h := testSetup()
 
-	user := &mocks.User{Email: "test@test.com"}
+	user := &mocks.User{Email: "test@test.com", SecurityStamp: "stamp"}
 	hash, token, _ := GenerateToken(user.Email)
 
 	h.storer.Users[user.Email] = user
@@ -198,6 +238,9 @@ func TestAuthenticateSuccess(t *testing.T) {
 	if h.session.ClientValues[authboss.SessionHalfAuthKey] != "true" {
 		t.Error("it should have become a half-authed session")
 	}
+	if h.session.ClientValues[authboss.SessionSecurityStamp] != "stamp" {
+		t.Error("should have saved the security stamp in the session")
+	}
 
 	if r.Context().Value(authboss.CTXKeyPID).(string) != "test@test.com" {
 		t.Error("should have set the context value to log the user in")
@@ -354,3 +397,323 @@ func TestGenerateToken(t *testing.T) {
 		t.Errorf("hash wrong, want: %s, got: %s", hash, gotHash)
 	}
 }
+
+func TestParseSeriesToken(t *testing.T) {
+	t.Parallel()
+
+	_, tok, err := GenerateSeriesToken("test;user@test.com", "c2VyaWVzc2VyaWVzc2VyaQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawToken, err := base64.URLEncoding.DecodeString(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, series, secret, ok := parseSeriesToken(rawToken)
+	if !ok || pid != "test;user@test.com" || series != "c2VyaWVzc2VyaWVzc2VyaQ" || len(secret) == 0 {
+		t.Error("series token was not parsed:", ok, pid, series, secret)
+	}
+
+	// Legacy tokens are never series tokens, however long the pid is or
+	// whatever it contains
+	longPID := strings.Repeat("a", 200) + ";c2VyaWVzc2VyaWVzc2VyaQ;" + strings.Repeat("b", 43)
+	_, tok, err = GenerateToken(longPID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawToken, err = base64.URLEncoding.DecodeString(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, ok := parseSeriesToken(rawToken); ok {
+		t.Error("legacy token should not parse as a series token")
+	}
+}
+
+func seriesSetup() (*testHarness, *mocks.SeriesServerStorer) {
+	h := testSetup()
+
+	storer := mocks.NewSeriesServerStorer()
+	storer.ServerStorer = h.storer
+	h.ab.Config.Storage.Server = storer
+
+	return h, storer
+}
+
+func TestRememberAfterAuthSeries(t *testing.T) {
+	t.Parallel()
+
+	h, storer := seriesSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+
+	r := mocks.Request("POST")
+	r.Header.Set("User-Agent", "test-agent")
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyValues, mocks.Values{Remember: true}))
+	r = r.WithContext(context.WithValue(r.Context(), authboss.CTXKeyUser, user))
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	if _, err := h.remember.RememberAfterAuth(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if len(h.storer.RMTokens["test@test.com"]) != 0 {
+		t.Error("no plain tokens should be stored")
+	}
+	if len(storer.RMSeries) != 1 {
+		t.Fatal("a series should have been stored:", storer.RMSeries)
+	}
+
+	for _, series := range storer.RMSeries {
+		if series.PID != "test@test.com" {
+			t.Error("pid was wrong:", series.PID)
+		}
+		if series.UserAgent != "test-agent" {
+			t.Error("user agent was wrong:", series.UserAgent)
+		}
+		if d := time.Until(series.Expires); d < 29*24*time.Hour || d > 30*24*time.Hour {
+			t.Error("expiry was wrong:", series.Expires)
+		}
+	}
+
+	if cookie := h.cookies.ClientValues[authboss.CookieRemember]; len(cookie) == 0 {
+		t.Error("remember me cookie was not set")
+	}
+}
+
+func TestAuthenticateSeries(t *testing.T) {
+	t.Parallel()
+
+	h, storer := seriesSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	hash, token, _ := GenerateSeriesToken(user.Email, "c2VyaWVzc2VyaWVzc2VyaQ")
+	expires := time.Now().UTC().Add(time.Hour)
+	storer.RMSeries[user.Email+";c2VyaWVzc2VyaWVzc2VyaQ"] = authboss.RememberSeries{
+		PID:     user.Email,
+		Series:  "c2VyaWVzc2VyaWVzc2VyaQ",
+		Token:   hash,
+		Expires: expires,
+	}
+	h.cookies.ClientValues[authboss.CookieRemember] = token
+
+	r := mocks.Request("POST")
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	var err error
+	r, err = h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Authenticate(h.ab, w, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	newToken := h.cookies.ClientValues[authboss.CookieRemember]
+	if len(newToken) == 0 || newToken == token {
+		t.Error("the cookie should have been replaced with a new token")
+	}
+
+	series := storer.RMSeries[user.Email+";c2VyaWVzc2VyaWVzc2VyaQ"]
+	if series.Token == hash {
+		t.Error("the token in the series should have been rotated")
+	}
+	if !series.Expires.Equal(expires) {
+		t.Error("rotation should not extend the expiry")
+	}
+	if series.LastUsed.IsZero() {
+		t.Error("last used should have been set")
+	}
+
+	if h.session.ClientValues[authboss.SessionKey] != user.Email {
+		t.Error("should have saved the pid in the session")
+	}
+	if h.session.ClientValues[authboss.SessionHalfAuthKey] != "true" {
+		t.Error("it should have become a half-authed session")
+	}
+}
+
+func TestAuthenticateSeriesTheft(t *testing.T) {
+	t.Parallel()
+
+	h, storer := seriesSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+	h.storer.RMTokens[user.Email] = []string{"legacy"}
+
+	// The stolen token was already used, so the series holds a newer one
+	_, stolen, _ := GenerateSeriesToken(user.Email, "c2VyaWVzc2VyaWVzc2VyaQ")
+	current, _, _ := GenerateSeriesToken(user.Email, "c2VyaWVzc2VyaWVzc2VyaQ")
+	storer.RMSeries[user.Email+";c2VyaWVzc2VyaWVzc2VyaQ"] = authboss.RememberSeries{
+		PID:     user.Email,
+		Series:  "c2VyaWVzc2VyaWVzc2VyaQ",
+		Token:   current,
+		Expires: time.Now().UTC().Add(time.Hour),
+	}
+	storer.RMSeries[user.Email+";other"] = authboss.RememberSeries{PID: user.Email, Series: "other"}
+	h.cookies.ClientValues[authboss.CookieRemember] = stolen
+
+	var theftUser authboss.User
+	h.ab.Events.After(authboss.EventRememberTheft, func(_ http.ResponseWriter, r *http.Request, _ bool) (bool, error) {
+		theftUser = r.Context().Value(authboss.CTXKeyUser).(authboss.User)
+		return false, nil
+	})
+
+	r := mocks.Request("POST")
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	var err error
+	r, err = h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Authenticate(h.ab, w, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if theftUser == nil || theftUser.GetPID() != user.Email {
+		t.Error("the theft event should have been fired with the user")
+	}
+	if len(storer.RMSeries) != 0 || len(h.storer.RMTokens) != 0 {
+		t.Error("all of the user's tokens should have been revoked")
+	}
+	if len(h.cookies.ClientValues[authboss.CookieRemember]) != 0 {
+		t.Error("there should be no remember cookie left")
+	}
+	if len(h.session.ClientValues[authboss.SessionKey]) != 0 {
+		t.Error("it should have not logged the user in")
+	}
+}
+
+func TestAuthenticateSeriesExpired(t *testing.T) {
+	t.Parallel()
+
+	h, storer := seriesSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	h.storer.Users[user.Email] = user
+
+	hash, token, _ := GenerateSeriesToken(user.Email, "c2VyaWVzc2VyaWVzc2VyaQ")
+	storer.RMSeries[user.Email+";c2VyaWVzc2VyaWVzc2VyaQ"] = authboss.RememberSeries{
+		PID:     user.Email,
+		Series:  "c2VyaWVzc2VyaWVzc2VyaQ",
+		Token:   hash,
+		Expires: time.Now().UTC().Add(-time.Hour),
+	}
+	h.cookies.ClientValues[authboss.CookieRemember] = token
+
+	r := mocks.Request("POST")
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	var err error
+	r, err = h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Authenticate(h.ab, w, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if len(storer.RMSeries) != 0 {
+		t.Error("the expired series should have been deleted")
+	}
+	if len(h.cookies.ClientValues[authboss.CookieRemember]) != 0 {
+		t.Error("there should be no remember cookie left")
+	}
+	if len(h.session.ClientValues[authboss.SessionKey]) != 0 {
+		t.Error("it should have not logged the user in")
+	}
+}
+
+func TestAuthenticateUpgradesToSeries(t *testing.T) {
+	t.Parallel()
+
+	h, storer := seriesSetup()
+
+	user := &mocks.User{Email: "test@test.com"}
+	hash, token, _ := GenerateToken(user.Email)
+
+	h.storer.Users[user.Email] = user
+	h.storer.RMTokens[user.Email] = []string{hash}
+	h.cookies.ClientValues[authboss.CookieRemember] = token
+
+	r := mocks.Request("POST")
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	var err error
+	r, err = h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Authenticate(h.ab, w, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if len(h.storer.RMTokens[user.Email]) != 0 {
+		t.Error("the old token should have been used up")
+	}
+	if len(storer.RMSeries) != 1 {
+		t.Error("a series should have replaced the old token")
+	}
+	if h.session.ClientValues[authboss.SessionKey] != user.Email {
+		t.Error("should have saved the pid in the session")
+	}
+}
+
+func TestBeforeLogoutSeries(t *testing.T) {
+	t.Parallel()
+
+	h, storer := seriesSetup()
+
+	hash, token, _ := GenerateSeriesToken("test@test.com", "c2VyaWVzc2VyaWVzc2VyaQ")
+	storer.RMSeries["test@test.com;c2VyaWVzc2VyaWVzc2VyaQ"] = authboss.RememberSeries{
+		PID:    "test@test.com",
+		Series: "c2VyaWVzc2VyaWVzc2VyaQ",
+		Token:  hash,
+	}
+	storer.RMSeries["test@test.com;other"] = authboss.RememberSeries{PID: "test@test.com", Series: "other"}
+	h.cookies.ClientValues[authboss.CookieRemember] = token
+
+	r := mocks.Request("POST")
+	rec := httptest.NewRecorder()
+	w := h.ab.NewResponse(rec)
+
+	var err error
+	r, err = h.ab.LoadClientState(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.remember.BeforeLogout(w, r, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(storer.RMSeries) != 1 {
+		t.Error("only the current series should have been deleted")
+	} else if _, ok := storer.RMSeries["test@test.com;other"]; !ok {
+		t.Error("the wrong series was deleted")
+	}
+}
@@ -0,0 +1,121 @@
+package authboss
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"path"
+)
+
+const (
+	securityStampSize = 16
+
+	securityStampStaleFlash = "Your credentials have changed, please log in again."
+)
+
+// NewSecurityStamp creates a random security stamp
+func NewSecurityStamp() (string, error) {
+	stamp := make([]byte, securityStampSize)
+	if _, err := io.ReadFull(rand.Reader, stamp); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(stamp), nil
+}
+
+// BumpSecurityStamp gives the user a new security stamp so that the
+// sessions logged in before it was bumped are logged out by
+// SecurityStampMiddleware. It does nothing if the user is not a
+// SecurityStampUser, the caller must save the user.
+func BumpSecurityStamp(user User) error {
+	su, ok := user.(SecurityStampUser)
+	if !ok {
+		return nil
+	}
+
+	stamp, err := NewSecurityStamp()
+	if err != nil {
+		return err
+	}
+
+	su.PutSecurityStamp(stamp)
+	return nil
+}
+
+// PutSessionSecurityStamp records the user's security stamp in the session,
+// it should be called whenever a user is logged in. It does nothing if the
+// user is not a SecurityStampUser.
+func PutSessionSecurityStamp(w http.ResponseWriter, user User) {
+	if su, ok := user.(SecurityStampUser); ok {
+		PutSession(w, SessionSecurityStamp, su.GetSecurityStamp())
+	}
+}
+
+// SecurityStampMiddleware logs out sessions whose security stamp doesn't
+// match the logged in user's, which happens after their password has been
+// changed or recovered. They are redirected to the login page.
+//
+// Sessions that have no stamp are stale as well, unless
+// Config.Modules.SecurityStampMigrate is set in which case they're assumed to
+// have been logged in before stamps were recorded and are given the user's
+// current stamp.
+//
+// It must be placed after LoadClientStateMiddleware.
+func (a *Authboss) SecurityStampMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := GetSession(r, SessionKey); !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := a.LoadCurrentUser(&r)
+		if err == ErrUserNotFound {
+			h.ServeHTTP(w, r)
+			return
+		} else if err != nil {
+			logger := a.RequestLogger(r)
+			logger.Errorf("failed to load user to check security stamp: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		su, ok := user.(SecurityStampUser)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		stamp, ok := GetSession(r, SessionSecurityStamp)
+		if !ok && a.Config.Modules.SecurityStampMigrate {
+			PutSessionSecurityStamp(w, su)
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		current := su.GetSecurityStamp()
+		if ok && subtle.ConstantTimeEq(int32(len(stamp)), int32(len(current))) == 1 &&
+			subtle.ConstantTimeCompare([]byte(stamp), []byte(current)) == 1 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		logger := a.RequestLogger(r)
+		logger.Infof("logging out session of user %s, security stamp is out of date", su.GetPID())
+
+		DelAllSession(w, a.Config.Storage.SessionStateWhitelistKeys)
+		DelKnownSession(w)
+		DelKnownCookie(w)
+
+		ro := RedirectOptions{
+			Code:         http.StatusTemporaryRedirect,
+			Failure:      securityStampStaleFlash,
+			RedirectPath: path.Join(a.Config.Paths.Mount, "/login"),
+		}
+		if err := a.Config.Core.Redirector.Redirect(w, r, ro); err != nil {
+			logger.Errorf("failed to redirect stale session: %+v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package authboss
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBumpSecurityStamp(t *testing.T) {
+	t.Parallel()
+
+	user := &mockUser{}
+	if err := BumpSecurityStamp(user); err != nil {
+		t.Fatal(err)
+	}
+
+	first := user.SecurityStamp
+	if len(first) == 0 {
+		t.Fatal("security stamp should have been set")
+	}
+
+	if err := BumpSecurityStamp(user); err != nil {
+		t.Fatal(err)
+	}
+	if user.SecurityStamp == first {
+		t.Error("security stamp should have changed")
+	}
+}
+
+func TestSecurityStampMiddleware(t *testing.T) {
+	t.Parallel()
+
+	setup := func(state mockClientState, migrate bool) (*httptest.ResponseRecorder, *testRedirector, mockClientState, bool) {
+		ab := New()
+		ab.Config.Modules.SecurityStampMigrate = migrate
+		redirector := &testRedirector{}
+		ab.Config.Core.Logger = mockLogger{}
+		ab.Config.Core.Redirector = redirector
+		ab.Config.Paths.Mount = "/auth"
+		ab.Config.Storage.SessionState = mockClientStateReadWriter{state: state}
+		ab.Config.Storage.Server = &mockServerStorer{
+			Users: map[string]*mockUser{
+				"test@test.com": {Email: "test@test.com", SecurityStamp: "current"},
+			},
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		rec := httptest.NewRecorder()
+		w := ab.NewResponse(rec)
+
+		var err error
+		r, err = ab.LoadClientState(w, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var called bool
+		server := ab.SecurityStampMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		server.ServeHTTP(w, r)
+
+		return rec, redirector, state, called
+	}
+
+	t.Run("NotLoggedIn", func(t *testing.T) {
+		_, _, _, called := setup(mockClientState{}, false)
+		if !called {
+			t.Error("should have been called")
+		}
+	})
+
+	t.Run("Current", func(t *testing.T) {
+		_, _, state, called := setup(mockClientState{SessionKey: "test@test.com", SessionSecurityStamp: "current"}, false)
+		if !called {
+			t.Error("should have been called")
+		}
+		if state[SessionKey] != "test@test.com" {
+			t.Error("should still be logged in")
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, _, state, called := setup(mockClientState{SessionKey: "test@test.com"}, false)
+		if called {
+			t.Error("should not have been called")
+		}
+		if _, ok := state[SessionKey]; ok {
+			t.Error("should have been logged out")
+		}
+	})
+
+	t.Run("MissingMigrate", func(t *testing.T) {
+		_, _, state, called := setup(mockClientState{SessionKey: "test@test.com"}, true)
+		if !called {
+			t.Error("should have been called")
+		}
+		if state[SessionSecurityStamp] != "current" {
+			t.Error("session should have been given the current stamp:", state[SessionSecurityStamp])
+		}
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		state := mockClientState{
+			SessionKey:           "test@test.com",
+			SessionSecurityStamp: "old",
+			Session2FA:           "totp",
+		}
+		rec, redirector, state, called := setup(state, false)
+		if called {
+			t.Error("should not have been called")
+		}
+		if rec.Code != http.StatusTemporaryRedirect {
+			t.Error("code was wrong:", rec.Code)
+		}
+		if redirector.Opts.RedirectPath != "/auth/login" {
+			t.Error("redirect path was wrong:", redirector.Opts.RedirectPath)
+		}
+		if _, ok := state[SessionKey]; ok {
+			t.Error("should have been logged out")
+		}
+		if _, ok := state[SessionSecurityStamp]; ok {
+			t.Error("security stamp should have been removed")
+		}
+	})
+}
@@ -0,0 +1,8 @@
+package authboss
+
+import "context"
+
+// SMSSender sends SMS messages to a phone number
+type SMSSender interface {
+	Send(ctx context.Context, number, text string) error
+}
@@ -9,6 +9,7 @@ package authboss
 
 import (
 	"context"
+	"time"
 
 	"github.com/friendsofgo/errors"
 )
@@ -97,6 +98,15 @@ type ConfirmingServerStorer interface {
 	LoadByConfirmSelector(ctx context.Context, selector string) (ConfirmableUser, error)
 }
 
+// ConfirmPurgingServerStorer can delete accounts that were never confirmed
+type ConfirmPurgingServerStorer interface {
+	ServerStorer
+
+	// PurgeUnconfirmed deletes all users that are not confirmed and were
+	// created before the given time, returning how many were deleted.
+	PurgeUnconfirmed(ctx context.Context, before time.Time) (int, error)
+}
+
 // RecoveringServerStorer allows users to be recovered by a token
 type RecoveringServerStorer interface {
 	ServerStorer
@@ -106,6 +116,15 @@ type RecoveringServerStorer interface {
 	LoadByRecoverSelector(ctx context.Context, selector string) (RecoverableUser, error)
 }
 
+// UnlockingServerStorer allows locked users to be unlocked by a token
+type UnlockingServerStorer interface {
+	ServerStorer
+
+	// LoadByUnlockSelector finds a user by his unlock selector field
+	// and should return ErrUserNotFound if that user cannot be found.
+	LoadByUnlockSelector(ctx context.Context, selector string) (UnlockableUser, error)
+}
+
 // RememberingServerStorer allows users to be remembered across sessions
 type RememberingServerStorer interface {
 	ServerStorer
@@ -119,6 +138,123 @@ type RememberingServerStorer interface {
 	UseRememberToken(ctx context.Context, pid, token string) error
 }
 
+// RememberSeries is a remember me token that belongs to a series. The series
+// identifies a single remembered device and stays the same, while the token
+// is replaced every time it's used.
+type RememberSeries struct {
+	PID    string
+	Series string
+	// Token is a hash of the token in the user's cookie
+	Token string
+
+	// Expires is when the series can no longer be used, it's not extended
+	// when the token is rotated.
+	Expires time.Time
+	// Created is when the series was created, usually at login
+	Created time.Time
+	// LastUsed is when the token was last rotated
+	LastUsed time.Time
+
+	// UserAgent and IP describe the device the series was last used from,
+	// they're informational and can be shown to users managing their
+	// remembered devices.
+	UserAgent string
+	IP        string
+}
+
+// SeriesRememberingServerStorer allows users to be remembered across sessions
+// with series tokens that expire and can detect the theft of a token. The
+// remember module uses it instead of the RememberingServerStorer methods
+// when it is implemented. DelRememberTokens must also delete all of the
+// user's series.
+type SeriesRememberingServerStorer interface {
+	RememberingServerStorer
+
+	// PutRememberSeries creates or replaces the series with the same PID
+	// and Series
+	PutRememberSeries(ctx context.Context, series RememberSeries) error
+	// LoadRememberSeries finds a series, if it could not be found
+	// return ErrTokenNotFound
+	LoadRememberSeries(ctx context.Context, pid, series string) (RememberSeries, error)
+	// DelRememberSeries deletes a single series
+	DelRememberSeries(ctx context.Context, pid, series string) error
+}
+
+// Invitation lets someone register when Config.Modules.RegisterRequireInvite
+// is set. Like other tokens only hashes of the token are stored.
+type Invitation struct {
+	// Selector is a hash of the first half of the token, it's what
+	// invitations are looked up by
+	Selector string
+	// Verifier is a hash of the second half of the token
+	Verifier string
+
+	// Email is the e-mail address that was invited
+	Email string
+	// Inviter is the pid of the user that sent the invitation, it's empty
+	// for invitations created by the application itself
+	Inviter string
+	// Metadata is passed to the registered user, eg. a role or tenant
+	Metadata map[string]string
+
+	// Expires is when the invitation can no longer be used
+	Expires time.Time
+}
+
+// InvitingServerStorer stores invitations for invite only registration
+type InvitingServerStorer interface {
+	ServerStorer
+
+	// PutInvitation creates or replaces the invitation with the same selector
+	PutInvitation(ctx context.Context, invitation Invitation) error
+	// LoadInvitation finds an invitation by its selector, if it could not
+	// be found return ErrTokenNotFound
+	LoadInvitation(ctx context.Context, selector string) (Invitation, error)
+	// DelInvitation deletes an invitation when it's used. It must be
+	// atomic and return ErrTokenNotFound if the invitation had already
+	// been deleted, so that only one registration can use it.
+	DelInvitation(ctx context.Context, selector string) error
+}
+
+// OAuth2Identity links a user at an oauth2 provider to an existing user, so
+// they can log in to the same account with any of their linked providers
+type OAuth2Identity struct {
+	// Provider is the name of the provider, as in OAuth2Providers
+	Provider string
+	// UID is the user's id at the provider
+	UID string
+	// PID is the user the identity belongs to
+	PID string
+	// Email is the e-mail address the provider gave when it was linked
+	Email string
+
+	// LinkedAt is when the identity was linked
+	LinkedAt time.Time
+
+	// AccessToken, RefreshToken and Expiry are the identity's token at
+	// the provider from its last login, see OAuth2IdentityTokenSource
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// OAuth2IdentityStorer stores the oauth2 identities users linked to their
+// accounts
+type OAuth2IdentityStorer interface {
+	ServerStorer
+
+	// PutOAuth2Identity creates or replaces the identity with the same
+	// provider and uid
+	PutOAuth2Identity(ctx context.Context, identity OAuth2Identity) error
+	// LoadOAuth2Identity finds an identity by its provider and uid, if it
+	// could not be found return ErrUserNotFound
+	LoadOAuth2Identity(ctx context.Context, provider, uid string) (OAuth2Identity, error)
+	// ListOAuth2Identities finds all the identities linked to the user
+	ListOAuth2Identities(ctx context.Context, pid string) ([]OAuth2Identity, error)
+	// DelOAuth2Identity unlinks an identity
+	DelOAuth2Identity(ctx context.Context, provider, uid string) error
+}
+
 // EnsureCanCreate makes sure the server storer supports create operations
 func EnsureCanCreate(storer ServerStorer) CreatingServerStorer {
 	s, ok := storer.(CreatingServerStorer)
@@ -140,6 +276,17 @@ func EnsureCanConfirm(storer ServerStorer) ConfirmingServerStorer {
 	return s
 }
 
+// EnsureCanPurgeUnconfirmed makes sure the server storer supports
+// deleting unconfirmed users
+func EnsureCanPurgeUnconfirmed(storer ServerStorer) ConfirmPurgingServerStorer {
+	s, ok := storer.(ConfirmPurgingServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to ConfirmPurgingServerStorer, check your struct")
+	}
+
+	return s
+}
+
 // EnsureCanRecover makes sure the server storer supports
 // confirm-lookup operations
 func EnsureCanRecover(storer ServerStorer) RecoveringServerStorer {
@@ -151,6 +298,38 @@ func EnsureCanRecover(storer ServerStorer) RecoveringServerStorer {
 	return s
 }
 
+// EnsureCanUnlock makes sure the server storer supports
+// unlock-lookup operations
+func EnsureCanUnlock(storer ServerStorer) UnlockingServerStorer {
+	s, ok := storer.(UnlockingServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to UnlockingServerStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanInvite makes sure the server storer supports invitations
+func EnsureCanInvite(storer ServerStorer) InvitingServerStorer {
+	s, ok := storer.(InvitingServerStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to InvitingServerStorer, check your struct")
+	}
+
+	return s
+}
+
+// EnsureCanLinkOAuth2 makes sure the server storer supports linking oauth2
+// identities
+func EnsureCanLinkOAuth2(storer ServerStorer) OAuth2IdentityStorer {
+	s, ok := storer.(OAuth2IdentityStorer)
+	if !ok {
+		panic("could not upgrade ServerStorer to OAuth2IdentityStorer, check your struct")
+	}
+
+	return s
+}
+
 // EnsureCanRemember makes sure the server storer supports remember operations
 func EnsureCanRemember(storer ServerStorer) RememberingServerStorer {
 	s, ok := storer.(RememberingServerStorer)
@@ -22,11 +22,18 @@ func _() {
 	_ = x[EventLogout-11]
 	_ = x[EventTwoFactorAdded-12]
 	_ = x[EventTwoFactorRemoved-13]
+	_ = x[EventConfirm-14]
+	_ = x[EventLock-15]
+	_ = x[EventUnlock-16]
+	_ = x[EventPasswordChange-17]
+	_ = x[EventRegisterFail-18]
+	_ = x[EventRememberTheft-19]
+	_ = x[EventRecoverThrottled-20]
 }
 
-const _Event_name = "EventRegisterEventAuthEventAuthHijackEventOAuth2EventAuthFailEventOAuth2FailEventRecoverStartEventRecoverEndEventGetUserEventGetUserSessionEventPasswordResetEventLogoutEventTwoFactorAddedEventTwoFactorRemoved"
+const _Event_name = "EventRegisterEventAuthEventAuthHijackEventOAuth2EventAuthFailEventOAuth2FailEventRecoverStartEventRecoverEndEventGetUserEventGetUserSessionEventPasswordResetEventLogoutEventTwoFactorAddedEventTwoFactorRemovedEventConfirmEventLockEventUnlockEventPasswordChangeEventRegisterFailEventRememberTheftEventRecoverThrottled"
 
-var _Event_index = [...]uint8{0, 13, 22, 37, 48, 61, 76, 93, 108, 120, 139, 157, 168, 187, 208}
+var _Event_index = [...]uint16{0, 13, 22, 37, 48, 61, 76, 93, 108, 120, 139, 157, 168, 187, 208, 220, 229, 240, 259, 276, 294, 315}
 
 func (i Event) String() string {
 	if i < 0 || i >= Event(len(_Event_index)-1) {
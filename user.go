@@ -30,6 +30,14 @@ type AuthableUser interface {
 	PutPassword(password string)
 }
 
+// CreatedAtUser knows when it was created
+type CreatedAtUser interface {
+	User
+
+	GetCreatedAt() (created time.Time)
+	PutCreatedAt(created time.Time)
+}
+
 // ConfirmableUser can be in a state of confirmed or not
 type ConfirmableUser interface {
 	User
@@ -45,6 +53,33 @@ type ConfirmableUser interface {
 	PutConfirmVerifier(verifier string)
 }
 
+// ConfirmExpiringUser is a ConfirmableUser whose confirm tokens expire
+// after ConfirmTokenDuration
+type ConfirmExpiringUser interface {
+	ConfirmableUser
+
+	GetConfirmExpiry() (expiry time.Time)
+	PutConfirmExpiry(expiry time.Time)
+}
+
+// ConfirmCodeUser is a ConfirmExpiringUser that can be confirmed with a
+// short code, it counts the wrong codes entered so they can be limited
+type ConfirmCodeUser interface {
+	ConfirmExpiringUser
+
+	GetConfirmAttempts() (attempts int)
+	PutConfirmAttempts(attempts int)
+}
+
+// ConfirmResendableUser is a ConfirmableUser that remembers when its last
+// confirmation e-mail was sent so that resending it can be rate limited
+type ConfirmResendableUser interface {
+	ConfirmableUser
+
+	GetConfirmLastSent() (sent time.Time)
+	PutConfirmLastSent(sent time.Time)
+}
+
 // LockableUser is a user that can be locked
 type LockableUser interface {
 	User
@@ -58,6 +93,30 @@ type LockableUser interface {
 	PutLocked(locked time.Time)
 }
 
+// LockCountingUser is a LockableUser that also remembers how many times it
+// has been locked, allowing a LockPolicy to lengthen successive locks.
+type LockCountingUser interface {
+	LockableUser
+
+	GetLockCount() (count int)
+	PutLockCount(count int)
+}
+
+// UnlockableUser is a LockableUser that can unlock itself with a token
+// sent to it via e-mail
+type UnlockableUser interface {
+	LockableUser
+
+	GetEmail() (email string)
+	GetUnlockSelector() (selector string)
+	GetUnlockVerifier() (verifier string)
+	GetUnlockExpiry() (expiry time.Time)
+
+	PutUnlockSelector(selector string)
+	PutUnlockVerifier(verifier string)
+	PutUnlockExpiry(expiry time.Time)
+}
+
 // RecoverableUser is a user that can be recovered via e-mail
 type RecoverableUser interface {
 	AuthableUser
@@ -73,6 +132,59 @@ type RecoverableUser interface {
 	PutRecoverExpiry(expiry time.Time)
 }
 
+// RecoverResendableUser is a RecoverableUser that remembers when its last
+// recovery e-mail was sent so that they can be rate limited
+type RecoverResendableUser interface {
+	RecoverableUser
+
+	GetRecoverLastSent() (sent time.Time)
+	PutRecoverLastSent(sent time.Time)
+}
+
+// RecoverCodeUser is a RecoverableUser that counts the wrong recovery
+// codes entered so that guessing them can be limited, and remembers when
+// its current recover token was given for a code texted to its phone
+// number, which counts as its second factor.
+type RecoverCodeUser interface {
+	RecoverableUser
+
+	GetRecoverAttempts() (attempts int)
+	GetRecoverPhoneVerified() (verified bool)
+
+	PutRecoverAttempts(attempts int)
+	PutRecoverPhoneVerified(verified bool)
+}
+
+// ConsentUser records which version of the terms of service a user
+// accepted, and when, for the consent module
+type ConsentUser interface {
+	User
+
+	GetConsentVersion() (version string)
+	GetConsentedAt() (consentedAt time.Time)
+
+	PutConsentVersion(version string)
+	PutConsentedAt(consentedAt time.Time)
+}
+
+// InvitedUser is given the invitation it registered with so that the
+// invitation's metadata (eg. a role) can be stored on it
+type InvitedUser interface {
+	User
+
+	PutInvitation(invitation Invitation)
+}
+
+// SecurityStampUser has a security stamp that changes whenever its
+// credentials do. Sessions remember the stamp they were logged in with so
+// that SecurityStampMiddleware can log out the ones that are out of date.
+type SecurityStampUser interface {
+	User
+
+	GetSecurityStamp() (stamp string)
+	PutSecurityStamp(stamp string)
+}
+
 // ArbitraryUser allows arbitrary data from the web form through. You should
 // definitely only pull the keys you want from the map, since this is unfiltered
 // input from a web request and is an attack vector.
@@ -87,6 +199,15 @@ type ArbitraryUser interface {
 	PutArbitrary(arbitrary map[string]string)
 }
 
+// PublicUser lists the fields that are safe to show the user themselves,
+// like the register_success page (or JSON body) does. Users that don't
+// implement it only have their pid shown.
+type PublicUser interface {
+	User
+
+	GetPublicFields() map[string]interface{}
+}
+
 // OAuth2User allows reading and writing values relating to OAuth2
 // Also see MakeOAuthPID/ParseOAuthPID for helpers to fulfill the User
 // part of the interface.
@@ -126,6 +247,14 @@ func MustBeConfirmable(u User) ConfirmableUser {
 	panic(fmt.Sprintf("could not upgrade user to a confirmable user, type: %T", u))
 }
 
+// MustBeConfirmCode forces an upgrade to a ConfirmCodeUser or panic.
+func MustBeConfirmCode(u User) ConfirmCodeUser {
+	if cu, ok := u.(ConfirmCodeUser); ok {
+		return cu
+	}
+	panic(fmt.Sprintf("could not upgrade user to a confirm code user, type: %T", u))
+}
+
 // MustBeLockable forces an upgrade to a LockableUser or panic.
 func MustBeLockable(u User) LockableUser {
 	if lu, ok := u.(LockableUser); ok {
@@ -134,6 +263,14 @@ func MustBeLockable(u User) LockableUser {
 	panic(fmt.Sprintf("could not upgrade user to a lockable user, given type: %T", u))
 }
 
+// MustBeUnlockable forces an upgrade to an UnlockableUser or panic.
+func MustBeUnlockable(u User) UnlockableUser {
+	if uu, ok := u.(UnlockableUser); ok {
+		return uu
+	}
+	panic(fmt.Sprintf("could not upgrade user to an unlockable user, type: %T", u))
+}
+
 // MustBeRecoverable forces an upgrade to a RecoverableUser or panic.
 func MustBeRecoverable(u User) RecoverableUser {
 	if lu, ok := u.(RecoverableUser); ok {
@@ -142,6 +279,22 @@ func MustBeRecoverable(u User) RecoverableUser {
 	panic(fmt.Sprintf("could not upgrade user to a recoverable user, given type: %T", u))
 }
 
+// MustBeRecoverCode forces an upgrade to a RecoverCodeUser or panic.
+func MustBeRecoverCode(u User) RecoverCodeUser {
+	if ru, ok := u.(RecoverCodeUser); ok {
+		return ru
+	}
+	panic(fmt.Sprintf("could not upgrade user to a recover code user, given type: %T", u))
+}
+
+// MustBeConsent forces an upgrade to a ConsentUser or panic.
+func MustBeConsent(u User) ConsentUser {
+	if cu, ok := u.(ConsentUser); ok {
+		return cu
+	}
+	panic(fmt.Sprintf("could not upgrade user to a consent user, given type: %T", u))
+}
+
 // MustBeOAuthable forces an upgrade to an OAuth2User or panic.
 func MustBeOAuthable(u User) OAuth2User {
 	if ou, ok := u.(OAuth2User); ok {
@@ -26,6 +26,22 @@ type FieldError interface {
 	Err() error
 }
 
+// NewFieldError creates a FieldError for modules that find an error on a
+// field after the body reader's validation has passed.
+func NewFieldError(name string, err error) FieldError {
+	return fieldError{name: name, err: err}
+}
+
+// fieldError is the FieldError returned by NewFieldError
+type fieldError struct {
+	name string
+	err  error
+}
+
+func (f fieldError) Name() string  { return f.name }
+func (f fieldError) Err() error    { return f.err }
+func (f fieldError) Error() string { return f.name + ": " + f.err.Error() }
+
 // ErrorMap is a shortcut to change []error into ErrorList and call Map on it
 // since this is a common operation.
 func ErrorMap(e []error) map[string][]string {
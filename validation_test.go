@@ -90,3 +90,17 @@ func TestErrorList_MapHelper(t *testing.T) {
 
 	var _ map[string][]string = ErrorMap(errList)
 }
+
+func TestNewFieldError(t *testing.T) {
+	t.Parallel()
+
+	err := NewFieldError("email", errors.New("not allowed"))
+	if err.Name() != "email" || err.Err().Error() != "not allowed" {
+		t.Error("wrong name or error:", err.Name(), err.Err())
+	}
+
+	m := ErrorMap([]error{err})
+	if e := m["email"]; len(e) != 1 || e[0] != "not allowed" {
+		t.Error("error should be mapped to its field:", m)
+	}
+}
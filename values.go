@@ -64,6 +64,16 @@ type RecoverEndValuer interface {
 	GetToken() string
 }
 
+// CodeValuer provides a numeric code that was sent to the user, and
+// optionally the token it was generated with (see GenerateCode) for
+// clients that don't keep it in the session
+type CodeValuer interface {
+	Validator
+
+	GetCode() string
+	GetSelector() string
+}
+
 // RememberValuer allows auth/oauth2 to pass along the remember
 // bool from the user to the remember module unobtrusively.
 type RememberValuer interface {
@@ -75,6 +85,14 @@ type RememberValuer interface {
 	GetShouldRemember() bool
 }
 
+// InviteValuer provides the invitation token a user registers with,
+// it's required when Config.Modules.RegisterRequireInvite is set.
+type InviteValuer interface {
+	// Intentionally omitting validator
+
+	GetInviteToken() string
+}
+
 // ArbitraryValuer provides the "rest" of the fields
 // that aren't strictly needed for anything in particular,
 // address, secondary e-mail, etc.
@@ -142,3 +160,13 @@ func MustHaveRecoverEndValues(v Validator) RecoverEndValuer {
 
 	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to RecoverEndValuer: %T", v))
 }
+
+// MustHaveCodeValues upgrades a validatable set of values
+// to ones specific to a user entering a code that was sent to them.
+func MustHaveCodeValues(v Validator) CodeValuer {
+	if u, ok := v.(CodeValuer); ok {
+		return u
+	}
+
+	panic(fmt.Sprintf("bodyreader returned a type that could not be upgraded to CodeValuer: %T", v))
+}